@@ -0,0 +1,140 @@
+package hiae
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Multi-part associated data
+//
+// A list of AD fields is encoded injectively as
+//
+//	LE64(len(f1)) || f1 || LE64(len(f2)) || f2 || ... || LE64(len(fn)) || fn
+//
+// so that ["a", "bc"] and ["ab", "c"] authenticate differently. The encoding is absorbed
+// block by block without materialising the concatenation, and the result is identical to
+// calling EncryptTo with EncodeADFields(fields...) as the associated data.
+
+// adAbsorber streams bytes into the state, buffering at most one partial block
+type adAbsorber struct {
+	h     *HiAE
+	block [BlockLen]byte
+	n     int // Bytes buffered in block
+}
+
+// write absorbs p, processing whole blocks directly from p when nothing is buffered
+func (a *adAbsorber) write(p []byte) {
+	if a.n > 0 {
+		c := copy(a.block[a.n:], p)
+		a.n += c
+		p = p[c:]
+		if a.n < BlockLen {
+			return
+		}
+		a.h.absorb(a.block[:])
+		a.n = 0
+	}
+
+	numFullBlocks := len(p) / BlockLen
+	for i := 0; i < numFullBlocks; i++ {
+		start := i * BlockLen
+		a.h.absorb(p[start : start+BlockLen])
+	}
+	a.n = copy(a.block[:], p[numFullBlocks*BlockLen:])
+}
+
+// flush absorbs the buffered partial block with zero padding
+func (a *adAbsorber) flush() {
+	if a.n > 0 {
+		zeroBytes(a.block[a.n:])
+		a.h.absorb(a.block[:])
+		a.n = 0
+	}
+}
+
+// absorbFields absorbs the length-prefixed encoding of fields and returns its length in bytes
+func (h *HiAE) absorbFields(fields [][]byte) int {
+	a := adAbsorber{h: h}
+	total := 0
+	var prefix [8]byte
+	for _, f := range fields {
+		binary.LittleEndian.PutUint64(prefix[:], uint64(len(f)))
+		a.write(prefix[:])
+		a.write(f)
+		total += len(prefix) + len(f)
+	}
+	a.flush()
+	return total
+}
+
+// EncodeADFields returns the length-prefixed encoding of fields used as associated data by EncryptToFields
+func EncodeADFields(fields ...[]byte) []byte {
+	n := 0
+	for _, f := range fields {
+		n += 8 + len(f)
+	}
+	out := make([]byte, 0, n)
+	for _, f := range fields {
+		out = binary.LittleEndian.AppendUint64(out, uint64(len(f)))
+		out = append(out, f...)
+	}
+	return out
+}
+
+// EncryptToFields encrypts a message like EncryptTo, authenticating a list of associated data fields
+func EncryptToFields(msg, key, nonce, ctOut, tagOut []byte, ad ...[]byte) error {
+	if len(key) != KeyLen {
+		return errors.New("key must be 32 bytes")
+	}
+	if len(nonce) != NonceLen {
+		return errors.New("nonce must be 16 bytes")
+	}
+	if len(ctOut) < len(msg) {
+		return errors.New("ciphertext output buffer too small")
+	}
+	if len(tagOut) < TagLen {
+		return errors.New("tag output buffer too small")
+	}
+
+	h := NewHiAE()
+	h.init(key, nonce)
+	adLen := h.absorbFields(ad)
+	h.encryptBlocks(msg, ctOut[:len(msg)])
+	h.finalize(uint64(adLen*8), uint64(len(msg)*8), tagOut[:TagLen])
+
+	return nil
+}
+
+// DecryptToFields decrypts a ciphertext produced by EncryptToFields with the same associated data fields
+func DecryptToFields(ct, tag, key, nonce, msgOut []byte, ad ...[]byte) error {
+	if len(key) != KeyLen {
+		return errors.New("key must be 32 bytes")
+	}
+	if len(nonce) != NonceLen {
+		return errors.New("nonce must be 16 bytes")
+	}
+	if len(tag) != TagLen {
+		return errors.New("tag must be 16 bytes")
+	}
+	if len(msgOut) < len(ct) {
+		return errors.New("message output buffer too small")
+	}
+
+	h := NewHiAE()
+	h.init(key, nonce)
+	adLen := h.absorbFields(ad)
+	h.decryptBlocks(ct, msgOut[:len(ct)])
+
+	// Generate expected tag
+	var expectedTag [TagLen]byte
+	h.finalize(uint64(adLen*8), uint64(len(ct)*8), expectedTag[:])
+
+	// Verify tag in constant time
+	if !ctEq(tag, expectedTag[:]) {
+		zeroBytes(msgOut[:len(ct)])
+		zeroBytes(expectedTag[:])
+		return errors.New("authentication verification failed")
+	}
+
+	return nil
+}
@@ -11,6 +11,11 @@ func updateEncGeneric(h *HiAE, mi []byte, ci []byte) {
 		panic("updateEncGeneric: output must be exactly 16 bytes")
 	}
 
+	// Copy the message block so that ci may alias mi
+	var m [BlockLen]byte
+	copy(m[:], mi)
+	mi = m[:]
+
 	idx0 := h.offset % StateLen
 	idx1 := (1 + h.offset) % StateLen
 	idx3 := (3 + h.offset) % StateLen
@@ -0,0 +1,253 @@
+// Package blob defines a self-describing, versioned binary format for HiAE ciphertexts
+//
+// A blob is laid out as
+//
+//	magic "HIAE" || version (1) || algorithm (1) || flags (1)
+//	[ len(keyID) (1) || keyID ]                  if flags has FlagKeyID
+//	nonce || len(ciphertext) (4, big-endian) || ciphertext || tag
+//
+// Everything before the ciphertext is the header. It is authenticated together with the
+// caller's associated data as hiae.EncodeADFields(header, ad), so neither the algorithm, the
+// key ID nor the length can be changed without detection. Nonce and tag sizes are defined per
+// algorithm, leaving room for future algorithms with other parameters.
+package blob
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+
+	hiae "github.com/hiae-aead/go-hiae"
+)
+
+// Version is the only format version defined so far
+const Version = 1
+
+// Algorithm identifies the AEAD that sealed a blob
+type Algorithm uint8
+
+// Algorithm identifiers
+const (
+	AlgHiAE Algorithm = 1
+	// AlgHiAEX2 is reserved for the two-way parallel HiAE variant and not yet supported
+	AlgHiAEX2 Algorithm = 2
+)
+
+// Header flags
+const (
+	FlagKeyID = 0x01
+)
+
+// PEMType is the PEM block type used by Armor
+const PEMType = "HIAE CIPHERTEXT"
+
+var magic = []byte("HIAE")
+
+// algParams holds the sizes of one algorithm
+type algParams struct {
+	keyLen, nonceLen, tagLen int
+}
+
+var algorithms = map[Algorithm]algParams{
+	AlgHiAE: {hiae.KeyLen, hiae.NonceLen, hiae.TagLen},
+}
+
+var (
+	// ErrMalformed is returned for data that is not a well-formed blob, including trailing bytes
+	ErrMalformed = errors.New("blob: malformed data")
+	// ErrUnsupported is returned for unknown versions and algorithms
+	ErrUnsupported = errors.New("blob: unsupported version or algorithm")
+	// ErrDecrypt is returned when a blob fails authentication
+	ErrDecrypt = errors.New("blob: decryption failed")
+)
+
+// Blob is a parsed ciphertext with its parameters
+type Blob struct {
+	Version    uint8
+	Algorithm  Algorithm
+	KeyID      []byte // Optional, at most 255 bytes
+	Nonce      []byte
+	Ciphertext []byte
+	Tag        []byte
+}
+
+// params validates the version and algorithm and returns the algorithm's sizes
+func (b *Blob) params() (algParams, error) {
+	p, ok := algorithms[b.Algorithm]
+	if b.Version != Version || !ok {
+		return algParams{}, ErrUnsupported
+	}
+	return p, nil
+}
+
+// header encodes everything before the ciphertext
+func (b *Blob) header() ([]byte, error) {
+	p, err := b.params()
+	if err != nil {
+		return nil, err
+	}
+	if len(b.KeyID) > 255 || len(b.Nonce) != p.nonceLen || len(b.Tag) != p.tagLen || uint64(len(b.Ciphertext)) > 1<<32-1 {
+		return nil, ErrMalformed
+	}
+
+	h := make([]byte, 0, 7+1+len(b.KeyID)+len(b.Nonce)+4)
+	h = append(h, magic...)
+	h = append(h, b.Version, byte(b.Algorithm))
+	if len(b.KeyID) > 0 {
+		h = append(h, FlagKeyID, byte(len(b.KeyID)))
+		h = append(h, b.KeyID...)
+	} else {
+		h = append(h, 0)
+	}
+	h = append(h, b.Nonce...)
+	h = binary.BigEndian.AppendUint32(h, uint32(len(b.Ciphertext)))
+	return h, nil
+}
+
+// Marshal encodes the blob
+func (b *Blob) Marshal() ([]byte, error) {
+	h, err := b.header()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(h)+len(b.Ciphertext)+len(b.Tag))
+	out = append(out, h...)
+	out = append(out, b.Ciphertext...)
+	return append(out, b.Tag...), nil
+}
+
+// Unmarshal parses a blob, rejecting unknown flags, missing bytes and trailing bytes
+// The returned blob aliases data.
+func Unmarshal(data []byte) (*Blob, error) {
+	if len(data) < 7 || !bytes.Equal(data[:4], magic) {
+		return nil, ErrMalformed
+	}
+	b := &Blob{Version: data[4], Algorithm: Algorithm(data[5])}
+	p, err := b.params()
+	if err != nil {
+		return nil, err
+	}
+
+	flags := data[6]
+	rest := data[7:]
+	if flags&^FlagKeyID != 0 {
+		return nil, ErrMalformed
+	}
+	if flags&FlagKeyID != 0 {
+		if len(rest) < 1 || rest[0] == 0 || len(rest) < 1+int(rest[0]) {
+			return nil, ErrMalformed
+		}
+		b.KeyID = rest[1 : 1+int(rest[0])]
+		rest = rest[1+int(rest[0]):]
+	}
+
+	if len(rest) < p.nonceLen+4 {
+		return nil, ErrMalformed
+	}
+	b.Nonce = rest[:p.nonceLen]
+	ctLen := binary.BigEndian.Uint32(rest[p.nonceLen:])
+	rest = rest[p.nonceLen+4:]
+	if uint64(len(rest)) != uint64(ctLen)+uint64(p.tagLen) {
+		return nil, ErrMalformed
+	}
+	b.Ciphertext = rest[:ctLen]
+	b.Tag = rest[ctLen:]
+
+	return b, nil
+}
+
+// Seal encrypts msg into a new blob, drawing a random nonce
+// keyID is optional and is stored in the clear, authenticated, to help the reader pick the key.
+func Seal(alg Algorithm, key, keyID, msg, ad []byte) (*Blob, error) {
+	b := &Blob{Version: Version, Algorithm: alg, KeyID: keyID}
+	p, err := b.params()
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != p.keyLen {
+		return nil, errors.New("blob: wrong key length")
+	}
+	b.Nonce = make([]byte, p.nonceLen)
+	if _, err := rand.Read(b.Nonce); err != nil {
+		return nil, err
+	}
+	b.Ciphertext = make([]byte, len(msg))
+	b.Tag = make([]byte, p.tagLen)
+
+	h, err := b.header()
+	if err != nil {
+		return nil, err
+	}
+	if err := hiae.EncryptToFields(msg, key, b.Nonce, b.Ciphertext, b.Tag, h, ad); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Open authenticates and decrypts the blob
+func (b *Blob) Open(key, ad []byte) ([]byte, error) {
+	h, err := b.header()
+	if err != nil {
+		return nil, err
+	}
+	msg := make([]byte, len(b.Ciphertext))
+	if err := hiae.DecryptToFields(b.Ciphertext, b.Tag, key, b.Nonce, msg, h, ad); err != nil {
+		return nil, ErrDecrypt
+	}
+	return msg, nil
+}
+
+// MarshalText encodes the blob as unpadded base64url, for URLs, JSON and cookies
+func (b *Blob) MarshalText() ([]byte, error) {
+	raw, err := b.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, base64.RawURLEncoding.EncodedLen(len(raw)))
+	base64.RawURLEncoding.Encode(out, raw)
+	return out, nil
+}
+
+// UnmarshalText parses unpadded base64url produced by MarshalText
+func (b *Blob) UnmarshalText(text []byte) error {
+	raw := make([]byte, base64.RawURLEncoding.DecodedLen(len(text)))
+	n, err := base64.RawURLEncoding.Strict().Decode(raw, text)
+	if err != nil {
+		return ErrMalformed
+	}
+	parsed, err := Unmarshal(raw[:n])
+	if err != nil {
+		return err
+	}
+	*b = *parsed
+	return nil
+}
+
+// Armor encodes the blob as a PEM block of type PEMType
+// The key ID, if any, is repeated as a Key-Id header for readability; it is not trusted on decoding.
+func (b *Blob) Armor() ([]byte, error) {
+	raw, err := b.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	block := &pem.Block{Type: PEMType, Bytes: raw}
+	if len(b.KeyID) > 0 {
+		block.Headers = map[string]string{"Key-Id": base64.RawURLEncoding.EncodeToString(b.KeyID)}
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+// Dearmor parses a PEM block produced by Armor, rejecting anything but whitespace around it
+func Dearmor(data []byte) (*Blob, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN ")) {
+		return nil, ErrMalformed
+	}
+	block, rest := pem.Decode(data)
+	if block == nil || block.Type != PEMType || len(bytes.TrimSpace(rest)) != 0 {
+		return nil, ErrMalformed
+	}
+	return Unmarshal(block.Bytes)
+}
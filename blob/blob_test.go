@@ -0,0 +1,159 @@
+package blob
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic("invalid hex string: " + s)
+	}
+	return b
+}
+
+var testKey = bytes.Repeat([]byte{0x11}, 32)
+
+// TestRoundTrip seals, marshals, parses and opens blobs with and without key IDs
+func TestRoundTrip(t *testing.T) {
+	for _, keyID := range [][]byte{nil, []byte("k1"), bytes.Repeat([]byte{7}, 255)} {
+		for _, size := range []int{0, 1, 300} {
+			msg := bytes.Repeat([]byte{0xcd}, size)
+			b, err := Seal(AlgHiAE, testKey, keyID, msg, []byte("ad"))
+			if err != nil {
+				t.Fatalf("Seal failed: %v", err)
+			}
+			data, err := b.Marshal()
+			if err != nil {
+				t.Fatalf("Marshal failed: %v", err)
+			}
+			parsed, err := Unmarshal(data)
+			if err != nil {
+				t.Fatalf("Unmarshal failed: %v", err)
+			}
+			if !bytes.Equal(parsed.KeyID, keyID) {
+				t.Errorf("Key ID changed from %x to %x", keyID, parsed.KeyID)
+			}
+			got, err := parsed.Open(testKey, []byte("ad"))
+			if err != nil || !bytes.Equal(got, msg) {
+				t.Fatalf("Open failed: %v", err)
+			}
+			if _, err := parsed.Open(testKey, []byte("other")); !errors.Is(err, ErrDecrypt) {
+				t.Errorf("Expected ErrDecrypt for wrong associated data, got %v", err)
+			}
+		}
+	}
+
+	if _, err := Seal(AlgHiAEX2, testKey, nil, nil, nil); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported for reserved algorithm, got %v", err)
+	}
+	if _, err := Seal(AlgHiAE, testKey, make([]byte, 256), nil, nil); !errors.Is(err, ErrMalformed) {
+		t.Errorf("Expected ErrMalformed for long key ID, got %v", err)
+	}
+}
+
+// TestVector pins the exact encoding of a blob and checks it opens
+func TestVector(t *testing.T) {
+	data := mustHex("48494145010101086b65792d323032350c90322568274f075acf911eee1af1140000000bdeec07d16bc2eb83086b273d8bc03792d8c8b9cd7015c3bba077c1")
+	b, err := Unmarshal(data)
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if string(b.KeyID) != "key-2025" || b.Algorithm != AlgHiAE || b.Version != Version {
+		t.Errorf("Unexpected header fields %+v", b)
+	}
+	got, err := b.Open(testKey, []byte("ad"))
+	if err != nil || string(got) != "pinned blob" {
+		t.Fatalf("Open failed: %q, %v", got, err)
+	}
+	again, _ := b.Marshal()
+	if !bytes.Equal(again, data) {
+		t.Error("Marshal did not reproduce the pinned encoding")
+	}
+}
+
+// TestStrictParsing checks that malformed encodings are rejected
+func TestStrictParsing(t *testing.T) {
+	b, _ := Seal(AlgHiAE, testKey, []byte("id"), []byte("strict"), nil)
+	data, _ := b.Marshal()
+
+	cases := map[string][]byte{
+		"empty":           nil,
+		"bad magic":       append([]byte("HIAF"), data[4:]...),
+		"trailing byte":   append(append([]byte{}, data...), 0),
+		"missing byte":    data[:len(data)-1],
+		"header only":     data[:7],
+		"unknown flag":    append(append(append([]byte{}, data[:6]...), 0x03), data[7:]...),
+		"empty key ID":    append(append(append([]byte{}, data[:7]...), 0), data[8:]...),
+		"key ID too long": append(append(append([]byte{}, data[:7]...), 0xff), data[8:]...),
+		"unknown version": append(append(append([]byte{}, data[:4]...), 2), data[5:]...),
+		"reserved alg":    append(append(append([]byte{}, data[:5]...), byte(AlgHiAEX2)), data[6:]...),
+	}
+	for name, bad := range cases {
+		if _, err := Unmarshal(bad); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	// Changing the header without breaking the syntax is caught by authentication
+	bad := append([]byte{}, data...)
+	bad[8] ^= 1 // First byte of the key ID
+	parsed, err := Unmarshal(bad)
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if _, err := parsed.Open(testKey, nil); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Expected ErrDecrypt for modified key ID, got %v", err)
+	}
+}
+
+// TestArmor checks the base64url and PEM text forms
+func TestArmor(t *testing.T) {
+	b, _ := Seal(AlgHiAE, testKey, []byte("k"), []byte("armored"), nil)
+
+	text, err := b.MarshalText()
+	if err != nil {
+		t.Fatalf("MarshalText failed: %v", err)
+	}
+	if strings.ContainsAny(string(text), "+/=") {
+		t.Errorf("Text form is not unpadded base64url: %s", text)
+	}
+	var parsed Blob
+	if err := parsed.UnmarshalText(text); err != nil {
+		t.Fatalf("UnmarshalText failed: %v", err)
+	}
+	if got, err := parsed.Open(testKey, nil); err != nil || string(got) != "armored" {
+		t.Fatalf("Open after UnmarshalText failed: %v", err)
+	}
+	if err := parsed.UnmarshalText(append(text, '=')); err == nil {
+		t.Error("Expected error for padded text")
+	}
+
+	armored, err := b.Armor()
+	if err != nil {
+		t.Fatalf("Armor failed: %v", err)
+	}
+	if !strings.HasPrefix(string(armored), "-----BEGIN "+PEMType+"-----\nKey-Id: aw\n") {
+		t.Errorf("Unexpected armor:\n%s", armored)
+	}
+	dearmored, err := Dearmor(append([]byte("\n"), armored...))
+	if err != nil {
+		t.Fatalf("Dearmor failed: %v", err)
+	}
+	if got, err := dearmored.Open(testKey, nil); err != nil || string(got) != "armored" {
+		t.Fatalf("Open after Dearmor failed: %v", err)
+	}
+	for name, bad := range map[string][]byte{
+		"leading text":  append([]byte("junk\n"), armored...),
+		"trailing text": append(append([]byte{}, armored...), "junk"...),
+		"wrong type":    []byte(strings.Replace(string(armored), PEMType, "MESSAGE", 2)),
+	} {
+		if _, err := Dearmor(bad); !errors.Is(err, ErrMalformed) {
+			t.Errorf("%s: expected ErrMalformed, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,349 @@
+// Package cbor implements the deterministic subset of CBOR (RFC 8949) needed by COSE and OSCORE
+//
+// Supported data items are integers in the int64 range, byte strings, text strings, arrays,
+// maps with integer or text keys, tags, booleans and null. Encoding follows the core
+// deterministic encoding requirements of RFC 8949 Section 4.2.1: shortest-form arguments,
+// definite lengths only, and map keys sorted by their encoded bytes. Decoding is strict and
+// rejects anything the encoder would not have produced, so every accepted input has exactly
+// one encoding.
+//
+// Go values map to CBOR as follows:
+//
+//	int, int64, uint64     integer (major type 0 or 1)
+//	[]byte                 byte string
+//	string                 text string
+//	[]any                  array
+//	map[any]any            map; keys are int64 or string after decoding
+//	Tag                    tag
+//	bool, nil              simple values
+//
+// Decoded integers are always int64.
+package cbor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"slices"
+	"unicode/utf8"
+)
+
+// Major types
+const (
+	majorUint   = 0
+	majorNegInt = 1
+	majorBytes  = 2
+	majorText   = 3
+	majorArray  = 4
+	majorMap    = 5
+	majorTag    = 6
+	majorSimple = 7
+)
+
+// Simple values
+const (
+	simpleFalse = 20
+	simpleTrue  = 21
+	simpleNull  = 22
+)
+
+// maxDepth bounds the nesting of arrays, maps and tags accepted by Unmarshal
+const maxDepth = 32
+
+// Tag is a tagged data item
+type Tag struct {
+	Number  uint64
+	Content any
+}
+
+var (
+	// ErrMalformed is returned for input that is not well-formed deterministic CBOR
+	ErrMalformed = errors.New("cbor: malformed or non-deterministic input")
+	// ErrUnsupported is returned for Go values or CBOR items outside the supported subset
+	ErrUnsupported = errors.New("cbor: unsupported type")
+)
+
+// appendHead appends a major type and argument in shortest form
+func appendHead(dst []byte, major byte, arg uint64) []byte {
+	m := major << 5
+	switch {
+	case arg < 24:
+		return append(dst, m|byte(arg))
+	case arg <= math.MaxUint8:
+		return append(dst, m|24, byte(arg))
+	case arg <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(dst, m|25), uint16(arg))
+	case arg <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(dst, m|26), uint32(arg))
+	}
+	return binary.BigEndian.AppendUint64(append(dst, m|27), arg)
+}
+
+// appendInt appends a signed integer
+func appendInt(dst []byte, v int64) []byte {
+	if v >= 0 {
+		return appendHead(dst, majorUint, uint64(v))
+	}
+	return appendHead(dst, majorNegInt, uint64(-(v + 1)))
+}
+
+// Marshal returns the deterministic encoding of v
+func Marshal(v any) ([]byte, error) {
+	return Append(nil, v)
+}
+
+// Append appends the deterministic encoding of v to dst
+func Append(dst []byte, v any) ([]byte, error) {
+	switch x := v.(type) {
+	case nil:
+		return append(dst, majorSimple<<5|simpleNull), nil
+	case bool:
+		if x {
+			return append(dst, majorSimple<<5|simpleTrue), nil
+		}
+		return append(dst, majorSimple<<5|simpleFalse), nil
+	case int:
+		return appendInt(dst, int64(x)), nil
+	case int64:
+		return appendInt(dst, x), nil
+	case uint64:
+		if x > math.MaxInt64 {
+			return nil, ErrUnsupported
+		}
+		return appendHead(dst, majorUint, x), nil
+	case []byte:
+		return append(appendHead(dst, majorBytes, uint64(len(x))), x...), nil
+	case string:
+		if !utf8.ValidString(x) {
+			return nil, ErrUnsupported
+		}
+		return append(appendHead(dst, majorText, uint64(len(x))), x...), nil
+	case []any:
+		dst = appendHead(dst, majorArray, uint64(len(x)))
+		for _, item := range x {
+			var err error
+			if dst, err = Append(dst, item); err != nil {
+				return nil, err
+			}
+		}
+		return dst, nil
+	case map[any]any:
+		return appendMap(dst, x)
+	case Tag:
+		return Append(appendHead(dst, majorTag, x.Number), x.Content)
+	}
+	return nil, ErrUnsupported
+}
+
+// appendMap encodes a map with its entries sorted by encoded key
+func appendMap(dst []byte, m map[any]any) ([]byte, error) {
+	type entry struct{ key, value []byte }
+	entries := make([]entry, 0, len(m))
+	for k, v := range m {
+		switch k.(type) {
+		case int, int64, uint64, string:
+		default:
+			return nil, ErrUnsupported
+		}
+		kb, err := Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+		vb, err := Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry{kb, vb})
+	}
+	slices.SortFunc(entries, func(a, b entry) int { return bytes.Compare(a.key, b.key) })
+	for i := 1; i < len(entries); i++ {
+		if bytes.Equal(entries[i-1].key, entries[i].key) {
+			// Keys such as int(1) and int64(1) encode identically
+			return nil, ErrUnsupported
+		}
+	}
+
+	dst = appendHead(dst, majorMap, uint64(len(entries)))
+	for _, e := range entries {
+		dst = append(append(dst, e.key...), e.value...)
+	}
+	return dst, nil
+}
+
+// decoder reads data items from a buffer
+type decoder struct {
+	data []byte
+	off  int
+}
+
+// head reads a major type and its argument, rejecting non-shortest and indefinite forms
+func (d *decoder) head() (byte, uint64, error) {
+	if d.off >= len(d.data) {
+		return 0, 0, ErrMalformed
+	}
+	b := d.data[d.off]
+	d.off++
+	major, info := b>>5, b&0x1f
+
+	var arg uint64
+	var n int
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info == 24:
+		n = 1
+	case info == 25:
+		n = 2
+	case info == 26:
+		n = 4
+	case info == 27:
+		n = 8
+	default:
+		return 0, 0, ErrMalformed
+	}
+	if len(d.data)-d.off < n {
+		return 0, 0, ErrMalformed
+	}
+	for _, c := range d.data[d.off : d.off+n] {
+		arg = arg<<8 | uint64(c)
+	}
+	d.off += n
+
+	// The argument must not fit a shorter form
+	if (n == 1 && arg < 24) || (n == 2 && arg <= math.MaxUint8) ||
+		(n == 4 && arg <= math.MaxUint16) || (n == 8 && arg <= math.MaxUint32) {
+		return 0, 0, ErrMalformed
+	}
+	if major == majorSimple {
+		// Floats and extended simple values are outside the subset
+		return 0, 0, ErrUnsupported
+	}
+	return major, arg, nil
+}
+
+// bytes reads n raw bytes
+func (d *decoder) bytes(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.off) {
+		return nil, ErrMalformed
+	}
+	b := d.data[d.off : d.off+int(n)]
+	d.off += int(n)
+	return b, nil
+}
+
+// item decodes one data item
+func (d *decoder) item(depth int) (any, error) {
+	if depth > maxDepth {
+		return nil, ErrUnsupported
+	}
+	major, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case majorUint:
+		if arg > math.MaxInt64 {
+			return nil, ErrUnsupported
+		}
+		return int64(arg), nil
+	case majorNegInt:
+		if arg > math.MaxInt64 {
+			return nil, ErrUnsupported
+		}
+		return -int64(arg) - 1, nil
+	case majorBytes:
+		b, err := d.bytes(arg)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{}, b...), nil
+	case majorText:
+		b, err := d.bytes(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !utf8.Valid(b) {
+			return nil, ErrMalformed
+		}
+		return string(b), nil
+	case majorArray:
+		if arg > uint64(len(d.data)-d.off) {
+			return nil, ErrMalformed
+		}
+		out := make([]any, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			v, err := d.item(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+		}
+		return out, nil
+	case majorMap:
+		return d.mapItem(arg, depth)
+	case majorTag:
+		v, err := d.item(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return Tag{Number: arg, Content: v}, nil
+	case majorSimple:
+		switch arg {
+		case simpleFalse:
+			return false, nil
+		case simpleTrue:
+			return true, nil
+		case simpleNull:
+			return nil, nil
+		}
+	}
+	return nil, ErrUnsupported
+}
+
+// mapItem decodes n map entries, checking that keys are unique and in deterministic order
+func (d *decoder) mapItem(n uint64, depth int) (any, error) {
+	if n > uint64(len(d.data)-d.off)/2 {
+		return nil, ErrMalformed
+	}
+	out := make(map[any]any, n)
+	var prev []byte
+	for i := uint64(0); i < n; i++ {
+		start := d.off
+		k, err := d.item(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		switch k.(type) {
+		case int64, string:
+		default:
+			return nil, ErrUnsupported
+		}
+		key := d.data[start:d.off]
+		if prev != nil && bytes.Compare(prev, key) >= 0 {
+			return nil, ErrMalformed
+		}
+		prev = key
+
+		v, err := d.item(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		out[k] = v
+	}
+	return out, nil
+}
+
+// Unmarshal decodes exactly one data item from data, rejecting trailing bytes
+func Unmarshal(data []byte) (any, error) {
+	d := &decoder{data: data}
+	v, err := d.item(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.off != len(data) {
+		return nil, ErrMalformed
+	}
+	return v, nil
+}
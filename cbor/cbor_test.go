@@ -0,0 +1,112 @@
+package cbor
+
+import (
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// TestVectors checks encoding and decoding against examples from RFC 8949 Appendix A
+func TestVectors(t *testing.T) {
+	vectors := []struct {
+		value    any
+		expected string
+	}{
+		{int64(0), "00"},
+		{int64(23), "17"},
+		{int64(24), "1818"},
+		{int64(100), "1864"},
+		{int64(1000), "1903e8"},
+		{int64(1000000), "1a000f4240"},
+		{int64(1000000000000), "1b000000e8d4a51000"},
+		{int64(-1), "20"},
+		{int64(-1000), "3903e7"},
+		{[]byte{}, "40"},
+		{[]byte{1, 2, 3, 4}, "4401020304"},
+		{"", "60"},
+		{"a", "6161"},
+		{"IETF", "6449455446"},
+		{"ü", "62c3bc"},
+		{[]any{}, "80"},
+		{[]any{int64(1), int64(2), int64(3)}, "83010203"},
+		{[]any{int64(1), []any{int64(2), int64(3)}, []any{int64(4), int64(5)}}, "8301820203820405"},
+		{map[any]any{}, "a0"},
+		{map[any]any{int64(1): int64(2), int64(3): int64(4)}, "a201020304"},
+		{map[any]any{"a": int64(1), "b": []any{int64(2), int64(3)}}, "a26161016162820203"},
+		{Tag{Number: 1, Content: int64(1363896240)}, "c11a514b67b0"},
+		{false, "f4"},
+		{true, "f5"},
+		{nil, "f6"},
+	}
+	for _, tv := range vectors {
+		enc, err := Marshal(tv.value)
+		if err != nil {
+			t.Fatalf("Marshal(%v) failed: %v", tv.value, err)
+		}
+		if hex.EncodeToString(enc) != tv.expected {
+			t.Errorf("Marshal(%v): expected %s, got %x", tv.value, tv.expected, enc)
+		}
+		dec, err := Unmarshal(enc)
+		if err != nil {
+			t.Fatalf("Unmarshal(%s) failed: %v", tv.expected, err)
+		}
+		if !reflect.DeepEqual(dec, tv.value) {
+			t.Errorf("Unmarshal(%s): expected %#v, got %#v", tv.expected, tv.value, dec)
+		}
+	}
+}
+
+// TestDeterministicMaps checks that map keys are sorted by their encoding
+func TestDeterministicMaps(t *testing.T) {
+	m := map[any]any{"b": 1, 10: 2, -1: 3, "aa": 4, 100: 5}
+	enc, err := Marshal(m)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	// 10 (0a) < 100 (1864) < -1 (20) < "b" (6162) < "aa" (626161)
+	if hex.EncodeToString(enc) != "a50a02186405200361620162616104" {
+		t.Errorf("Unexpected encoding %x", enc)
+	}
+	if _, err := Marshal(map[any]any{1: 1, int64(1): 2}); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported for colliding keys, got %v", err)
+	}
+	if _, err := Marshal(map[any]any{true: 1}); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported for boolean key, got %v", err)
+	}
+}
+
+// TestStrictDecoding checks that non-deterministic and malformed encodings are rejected
+func TestStrictDecoding(t *testing.T) {
+	for _, bad := range []string{
+		"",
+		"1817",                 // 23 in a one-byte argument
+		"190017",               // 23 in a two-byte argument
+		"5f4101ff",             // Indefinite-length byte string
+		"9f01ff",               // Indefinite-length array
+		"a201020102",           // Duplicate key
+		"a203040102",           // Keys out of order
+		"f97c00",               // Half-precision float
+		"fb3ff199999999999a",   // Double-precision float
+		"0000",                 // Trailing byte
+		"4201",                 // Truncated byte string
+		"62c328",               // Invalid UTF-8
+		"a1f501",               // Boolean map key
+		"1bffffffffffffffff",   // Integer beyond int64
+		"9b00000000ffffffff00", // Array length larger than input
+		"1c",                   // Reserved additional information
+	} {
+		data, _ := hex.DecodeString(bad)
+		if _, err := Unmarshal(data); err == nil {
+			t.Errorf("Unmarshal(%s) accepted malformed input", bad)
+		}
+	}
+
+	deep := make([]byte, maxDepth+2)
+	for i := range deep {
+		deep[i] = 0x81
+	}
+	if _, err := Unmarshal(append(deep, 0x00)); err == nil {
+		t.Error("Unmarshal accepted excessive nesting")
+	}
+}
@@ -0,0 +1,285 @@
+package hiae
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Encrypted connection framing
+//
+// Each direction uses its own key and a 64-bit frame counter, starting at zero, as the
+// low half of a big-endian nonce. Every frame is sent as
+//
+//	header = type(1) || length(4)
+//	frame  = header || HiAE(key, nonce, ad = header, msg = payload) || tag
+//
+// A close frame authenticates the end of the stream, so an attacker who cuts the
+// underlying connection causes io.ErrUnexpectedEOF rather than a clean io.EOF.
+
+// DefaultMaxFrameSize is the default largest payload carried by one frame
+const DefaultMaxFrameSize = 16384
+
+const (
+	frameHeaderLen = 5
+	frameData      = 0x00
+	frameClose     = 0x01
+)
+
+// Config holds the pre-shared keys and framing parameters of an encrypted connection
+type Config struct {
+	ClientKey []byte // Key protecting client-to-server traffic
+	ServerKey []byte // Key protecting server-to-client traffic; must differ from ClientKey
+
+	// MaxFrameSize is the largest payload per frame. It must be a multiple of 256 so that
+	// full frames are processed with the batch fast path. Zero selects DefaultMaxFrameSize.
+	// Both peers must use the same value.
+	MaxFrameSize int
+}
+
+// Conn is a net.Conn that encrypts and authenticates all traffic with HiAE
+type Conn struct {
+	conn     net.Conn
+	maxFrame int
+
+	rmu    sync.Mutex
+	rkey   [KeyLen]byte
+	rseq   uint64
+	rraw   []byte // Buffered frame bytes received from conn
+	rplain []byte // Decrypted bytes not yet returned by Read
+	rbuf   []byte // Backing storage for rplain
+	rerr   error  // Sticky read error
+
+	wmu    sync.Mutex
+	wkey   [KeyLen]byte
+	wseq   uint64
+	wbuf   []byte
+	werr   error // Sticky write error
+	closed bool
+}
+
+var _ net.Conn = (*Conn)(nil)
+
+// Client returns an encrypted connection for the client side of conn
+func Client(conn net.Conn, cfg *Config) (*Conn, error) {
+	return newConn(conn, cfg, cfg.ClientKey, cfg.ServerKey)
+}
+
+// Server returns an encrypted connection for the server side of conn
+func Server(conn net.Conn, cfg *Config) (*Conn, error) {
+	return newConn(conn, cfg, cfg.ServerKey, cfg.ClientKey)
+}
+
+func newConn(conn net.Conn, cfg *Config, writeKey, readKey []byte) (*Conn, error) {
+	if len(cfg.ClientKey) != KeyLen || len(cfg.ServerKey) != KeyLen {
+		return nil, errors.New("key must be 32 bytes")
+	}
+	// Both directions count frames from zero, so a shared key would reuse every nonce
+	if subtle.ConstantTimeCompare(cfg.ClientKey, cfg.ServerKey) == 1 {
+		return nil, errors.New("client and server keys must differ")
+	}
+	maxFrame := cfg.MaxFrameSize
+	if maxFrame == 0 {
+		maxFrame = DefaultMaxFrameSize
+	}
+	if maxFrame < 0 || maxFrame%(16*BlockLen) != 0 {
+		return nil, errors.New("maximum frame size must be a positive multiple of 256")
+	}
+
+	c := &Conn{
+		conn:     conn,
+		maxFrame: maxFrame,
+		rraw:     make([]byte, 0, frameHeaderLen+maxFrame+TagLen),
+		rbuf:     make([]byte, maxFrame),
+		wbuf:     make([]byte, frameHeaderLen+maxFrame+TagLen),
+	}
+	copy(c.wkey[:], writeKey)
+	copy(c.rkey[:], readKey)
+
+	return c, nil
+}
+
+// frameNonce encodes a frame counter as a nonce
+func frameNonce(seq uint64) [NonceLen]byte {
+	var nonce [NonceLen]byte
+	binary.BigEndian.PutUint64(nonce[8:], seq)
+	return nonce
+}
+
+// Read reads decrypted data, returning io.EOF only after an authenticated close
+func (c *Conn) Read(p []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+
+	for len(c.rplain) == 0 {
+		if c.rerr != nil {
+			return 0, c.rerr
+		}
+		if err := c.readFrame(); err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				// Buffered bytes are kept, so the next Read resumes the same frame
+				return 0, err
+			}
+			c.rerr = err
+			return 0, err
+		}
+	}
+
+	n := copy(p, c.rplain)
+	c.rplain = c.rplain[n:]
+
+	return n, nil
+}
+
+// fill reads from conn until at least n frame bytes are buffered
+func (c *Conn) fill(n int) error {
+	for len(c.rraw) < n {
+		m, err := c.conn.Read(c.rraw[len(c.rraw):cap(c.rraw)])
+		c.rraw = c.rraw[:len(c.rraw)+m]
+		if err == io.EOF {
+			if len(c.rraw) >= n {
+				return nil
+			}
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readFrame reads, verifies and decrypts the next frame into rplain
+func (c *Conn) readFrame() error {
+	if err := c.fill(frameHeaderLen); err != nil {
+		return err
+	}
+	hdr := c.rraw[:frameHeaderLen]
+	typ := hdr[0]
+	length := binary.BigEndian.Uint32(hdr[1:])
+	if (typ != frameData && typ != frameClose) || length > uint32(c.maxFrame) {
+		return errors.New("malformed frame")
+	}
+
+	total := frameHeaderLen + int(length) + TagLen
+	if err := c.fill(total); err != nil {
+		return err
+	}
+	if c.rseq == ^uint64(0) {
+		return &NonceExhaustedError{Source: "connection"}
+	}
+
+	ct := c.rraw[frameHeaderLen : frameHeaderLen+int(length)]
+	tag := c.rraw[frameHeaderLen+int(length) : total]
+	nonce := frameNonce(c.rseq)
+	if err := DecryptTo(ct, tag, hdr, c.rkey[:], nonce[:], c.rbuf); err != nil {
+		return err
+	}
+	c.rseq++
+
+	c.rplain = c.rbuf[:length]
+	c.rraw = c.rraw[:copy(c.rraw[:cap(c.rraw)], c.rraw[total:])]
+
+	if typ == frameClose {
+		c.rplain = nil
+		return io.EOF
+	}
+
+	return nil
+}
+
+// Write encrypts p into one or more frames
+func (c *Conn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if c.werr != nil {
+		return 0, c.werr
+	}
+
+	n := 0
+	for len(p) > 0 {
+		chunk := min(len(p), c.maxFrame)
+		if err := c.writeFrame(frameData, p[:chunk]); err != nil {
+			c.werr = err
+			return n, err
+		}
+		n += chunk
+		p = p[chunk:]
+	}
+
+	return n, nil
+}
+
+// writeFrame seals one frame and writes it to conn
+func (c *Conn) writeFrame(typ byte, payload []byte) error {
+	if c.wseq == ^uint64(0) {
+		return &NonceExhaustedError{Source: "connection"}
+	}
+
+	hdr := c.wbuf[:frameHeaderLen]
+	hdr[0] = typ
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(payload)))
+
+	ct := c.wbuf[frameHeaderLen : frameHeaderLen+len(payload)]
+	tag := c.wbuf[frameHeaderLen+len(payload) : frameHeaderLen+len(payload)+TagLen]
+	nonce := frameNonce(c.wseq)
+	if err := EncryptTo(payload, hdr, c.wkey[:], nonce[:], ct, tag); err != nil {
+		return err
+	}
+	c.wseq++
+
+	_, err := c.conn.Write(c.wbuf[:frameHeaderLen+len(payload)+TagLen])
+	return err
+}
+
+// Close sends an authenticated close frame and closes the underlying connection
+func (c *Conn) Close() error {
+	c.wmu.Lock()
+	var err error
+	if !c.closed {
+		c.closed = true
+		if c.werr == nil {
+			err = c.writeFrame(frameClose, nil)
+		}
+		c.werr = net.ErrClosed
+	}
+	c.wmu.Unlock()
+
+	if cerr := c.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// LocalAddr returns the local network address
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote network address
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetDeadline sets the read and write deadlines of the underlying connection
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline of the underlying connection
+// A Read that times out mid-frame keeps the partial frame and resumes it on the next call.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline of the underlying connection
+// A Write that times out leaves the stream in an undefined state, and all later writes fail.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
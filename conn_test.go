@@ -0,0 +1,183 @@
+package hiae
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func testConnConfig() *Config {
+	return &Config{
+		ClientKey:    bytes.Repeat([]byte{0x01}, KeyLen),
+		ServerKey:    bytes.Repeat([]byte{0x02}, KeyLen),
+		MaxFrameSize: 512,
+	}
+}
+
+// connPair returns encrypted client and server ends of a net.Pipe
+func connPair(t *testing.T) (*Conn, *Conn) {
+	c1, c2 := net.Pipe()
+	client, err := Client(c1, testConnConfig())
+	if err != nil {
+		t.Fatalf("Client failed: %v", err)
+	}
+	server, err := Server(c2, testConnConfig())
+	if err != nil {
+		t.Fatalf("Server failed: %v", err)
+	}
+	return client, server
+}
+
+// TestConnRoundTrip sends data in both directions with small reads and an authenticated close
+func TestConnRoundTrip(t *testing.T) {
+	client, server := connPair(t)
+
+	msg := make([]byte, 5000)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		if _, err := client.Write(msg); err != nil {
+			errc <- err
+			return
+		}
+		reply := make([]byte, 5)
+		if _, err := io.ReadFull(client, reply); err != nil {
+			errc <- err
+			return
+		}
+		if string(reply) != "thank" {
+			errc <- errors.New("unexpected reply")
+			return
+		}
+		errc <- client.Close()
+	}()
+
+	got := make([]byte, 0, len(msg))
+	buf := make([]byte, 7)
+	for len(got) < len(msg) {
+		n, err := server.Read(buf)
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		got = append(got, buf[:n]...)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Fatal("Received data mismatch")
+	}
+	if _, err := server.Write([]byte("thank")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if _, err := server.Read(buf); err != io.EOF {
+		t.Fatalf("Expected io.EOF after authenticated close, got %v", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("Client failed: %v", err)
+	}
+}
+
+// TestConnTruncation verifies that closing the transport without a close frame is detected
+func TestConnTruncation(t *testing.T) {
+	c1, c2 := net.Pipe()
+	client, _ := Client(c1, testConnConfig())
+	server, _ := Server(c2, testConnConfig())
+
+	go func() {
+		client.Write([]byte("partial"))
+		c1.Close()
+	}()
+
+	buf := make([]byte, 64)
+	n, err := server.Read(buf)
+	if err != nil || string(buf[:n]) != "partial" {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if _, err := server.Read(buf); err != io.ErrUnexpectedEOF {
+		t.Fatalf("Expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+// TestConnTamper verifies that a modified frame fails authentication
+func TestConnTamper(t *testing.T) {
+	c1, c2 := net.Pipe()
+	server, _ := Server(c2, testConnConfig())
+
+	var sink bytes.Buffer
+	client, _ := Client(&bufConn{Conn: c1, w: &sink}, testConnConfig())
+	client.Write([]byte("hello"))
+	frame := sink.Bytes()
+	frame[frameHeaderLen] ^= 0x01
+
+	go c1.Write(frame)
+	if _, err := server.Read(make([]byte, 16)); err == nil {
+		t.Fatal("Read should have failed for a tampered frame")
+	}
+	if _, err := server.Read(make([]byte, 16)); err == nil {
+		t.Fatal("Read errors should be sticky")
+	}
+}
+
+// TestConnDeadlinePartialFrame verifies that a read deadline mid-frame does not desynchronise the stream
+func TestConnDeadlinePartialFrame(t *testing.T) {
+	c1, c2 := net.Pipe()
+	server, _ := Server(c2, testConnConfig())
+
+	// Build a frame with a client-side Conn writing into a buffer
+	var sink bytes.Buffer
+	client, _ := Client(&bufConn{Conn: c1, w: &sink}, testConnConfig())
+	client.Write([]byte("deadline"))
+	frame := sink.Bytes()
+
+	go c1.Write(frame[:3])
+	server.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	var ne net.Error
+	if _, err := server.Read(make([]byte, 16)); !errors.As(err, &ne) || !ne.Timeout() {
+		t.Fatalf("Expected timeout, got %v", err)
+	}
+
+	server.SetReadDeadline(time.Time{})
+	go c1.Write(frame[3:])
+	buf := make([]byte, 16)
+	n, err := server.Read(buf)
+	if err != nil || string(buf[:n]) != "deadline" {
+		t.Fatalf("Read after timeout failed: %v %q", err, buf[:n])
+	}
+}
+
+// TestConnConfig verifies configuration validation
+func TestConnConfig(t *testing.T) {
+	c1, _ := net.Pipe()
+	cfg := testConnConfig()
+	cfg.MaxFrameSize = 1000
+	if _, err := Client(c1, cfg); err == nil {
+		t.Error("Expected error for unaligned frame size")
+	}
+	cfg = testConnConfig()
+	cfg.ServerKey = cfg.ServerKey[:16]
+	if _, err := Server(c1, cfg); err == nil {
+		t.Error("Expected error for short key")
+	}
+	cfg = testConnConfig()
+	cfg.ServerKey = bytes.Clone(cfg.ClientKey)
+	if _, err := Client(c1, cfg); err == nil {
+		t.Error("Expected error for equal client and server keys")
+	}
+	if _, err := Server(c1, cfg); err == nil {
+		t.Error("Expected error for equal client and server keys")
+	}
+}
+
+// bufConn redirects writes into a buffer
+type bufConn struct {
+	net.Conn
+	w io.Writer
+}
+
+func (b *bufConn) Write(p []byte) (int, error) {
+	return b.w.Write(p)
+}
@@ -0,0 +1,136 @@
+package hiae
+
+import (
+	"context"
+	"errors"
+	"math"
+)
+
+// DefaultCheckInterval is the number of 256-byte batches processed between cancellation checks
+const DefaultCheckInterval = 1024 // 256 KiB
+
+// maxCheckInterval keeps the chunk size in bytes from overflowing int
+const maxCheckInterval = math.MaxInt / (16 * BlockLen)
+
+// ContextOptions configures EncryptContext and DecryptContext
+type ContextOptions struct {
+	// CheckInterval is the number of 256-byte batches processed between checks of ctx.Done().
+	// Zero or negative values select DefaultCheckInterval. Larger values are clamped so the
+	// chunk size in bytes fits in an int.
+	CheckInterval int
+
+	// Progress, if set, is called after every interval with the number of message bytes processed so far.
+	Progress func(processed int64)
+}
+
+// chunkSize returns the number of bytes to process between cancellation checks
+func (o *ContextOptions) chunkSize() int {
+	interval := DefaultCheckInterval
+	if o != nil && o.CheckInterval > 0 {
+		interval = min(o.CheckInterval, maxCheckInterval)
+	}
+	return interval * 16 * BlockLen
+}
+
+// progress reports the number of processed bytes if a callback is configured
+func (o *ContextOptions) progress(processed int) {
+	if o != nil && o.Progress != nil {
+		o.Progress(int64(processed))
+	}
+}
+
+// EncryptContext encrypts like EncryptTo, but checks ctx for cancellation between groups of batches.
+// On cancellation it wipes ctOut and tagOut and returns ctx.Err().
+func EncryptContext(ctx context.Context, msg, ad, key, nonce, ctOut, tagOut []byte, opts *ContextOptions) error {
+	if len(key) != KeyLen {
+		return errors.New("key must be 32 bytes")
+	}
+	if len(nonce) != NonceLen {
+		return errors.New("nonce must be 16 bytes")
+	}
+	if len(ctOut) < len(msg) {
+		return errors.New("ciphertext output buffer too small")
+	}
+	if len(tagOut) < TagLen {
+		return errors.New("tag output buffer too small")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	h := NewHiAE()
+	h.init(key, nonce)
+	h.absorbPadded(ad)
+
+	chunk := opts.chunkSize()
+	for done := 0; done < len(msg); {
+		select {
+		case <-ctx.Done():
+			zeroBytes(ctOut[:len(msg)])
+			zeroBytes(tagOut[:TagLen])
+			return ctx.Err()
+		default:
+		}
+
+		end := done + min(chunk, len(msg)-done)
+		h.encryptBlocks(msg[done:end], ctOut[done:end])
+		done = end
+		opts.progress(done)
+	}
+
+	h.finalize(uint64(len(ad)*8), uint64(len(msg)*8), tagOut[:TagLen])
+
+	return nil
+}
+
+// DecryptContext decrypts like DecryptTo, but checks ctx for cancellation between groups of batches.
+// On cancellation it wipes msgOut and returns ctx.Err().
+func DecryptContext(ctx context.Context, ct, tag, ad, key, nonce, msgOut []byte, opts *ContextOptions) error {
+	if len(key) != KeyLen {
+		return errors.New("key must be 32 bytes")
+	}
+	if len(nonce) != NonceLen {
+		return errors.New("nonce must be 16 bytes")
+	}
+	if len(tag) != TagLen {
+		return errors.New("tag must be 16 bytes")
+	}
+	if len(msgOut) < len(ct) {
+		return errors.New("message output buffer too small")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	h := NewHiAE()
+	h.init(key, nonce)
+	h.absorbPadded(ad)
+
+	chunk := opts.chunkSize()
+	for done := 0; done < len(ct); {
+		select {
+		case <-ctx.Done():
+			zeroBytes(msgOut[:len(ct)])
+			return ctx.Err()
+		default:
+		}
+
+		end := done + min(chunk, len(ct)-done)
+		h.decryptBlocks(ct[done:end], msgOut[done:end])
+		done = end
+		opts.progress(done)
+	}
+
+	// Generate expected tag
+	var expectedTag [TagLen]byte
+	h.finalize(uint64(len(ad)*8), uint64(len(ct)*8), expectedTag[:])
+
+	// Verify tag in constant time
+	if !ctEq(tag, expectedTag[:]) {
+		zeroBytes(msgOut[:len(ct)])
+		zeroBytes(expectedTag[:])
+		return errors.New("authentication verification failed")
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package hiae
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"testing"
+)
+
+// TestContextMatchesEncryptTo verifies that the context variants produce the same output as EncryptTo
+func TestContextMatchesEncryptTo(t *testing.T) {
+	key := make([]byte, KeyLen)
+	nonce := make([]byte, NonceLen)
+	ad := []byte("context test ad")
+
+	for _, size := range []int{0, 1, 15, 16, 255, 256, 257, 4096, 5000} {
+		msg := make([]byte, size)
+		for i := range msg {
+			msg[i] = byte(i)
+		}
+
+		expectedCt := make([]byte, size)
+		expectedTag := make([]byte, TagLen)
+		if err := EncryptTo(msg, ad, key, nonce, expectedCt, expectedTag); err != nil {
+			t.Fatalf("size %d: EncryptTo failed: %v", size, err)
+		}
+
+		ct := make([]byte, size)
+		tag := make([]byte, TagLen)
+		opts := &ContextOptions{CheckInterval: 1}
+		if err := EncryptContext(context.Background(), msg, ad, key, nonce, ct, tag, opts); err != nil {
+			t.Fatalf("size %d: EncryptContext failed: %v", size, err)
+		}
+		if !bytes.Equal(ct, expectedCt) || !bytes.Equal(tag, expectedTag) {
+			t.Errorf("size %d: EncryptContext output differs from EncryptTo", size)
+		}
+
+		pt := make([]byte, size)
+		if err := DecryptContext(context.Background(), ct, tag, ad, key, nonce, pt, opts); err != nil {
+			t.Fatalf("size %d: DecryptContext failed: %v", size, err)
+		}
+		if !bytes.Equal(pt, msg) {
+			t.Errorf("size %d: DecryptContext output mismatch", size)
+		}
+	}
+}
+
+// TestContextProgress verifies that progress is reported once per interval
+func TestContextProgress(t *testing.T) {
+	key := make([]byte, KeyLen)
+	nonce := make([]byte, NonceLen)
+	msg := make([]byte, 3*512+100)
+	ct := make([]byte, len(msg))
+	tag := make([]byte, TagLen)
+
+	var reports []int64
+	opts := &ContextOptions{
+		CheckInterval: 2,
+		Progress:      func(processed int64) { reports = append(reports, processed) },
+	}
+	if err := EncryptContext(context.Background(), msg, nil, key, nonce, ct, tag, opts); err != nil {
+		t.Fatalf("EncryptContext failed: %v", err)
+	}
+
+	expected := []int64{512, 1024, 1536, 1636}
+	if len(reports) != len(expected) {
+		t.Fatalf("Expected %d progress reports, got %d", len(expected), len(reports))
+	}
+	for i := range expected {
+		if reports[i] != expected[i] {
+			t.Errorf("Report %d: expected %d, got %d", i, expected[i], reports[i])
+		}
+	}
+}
+
+// TestContextHugeInterval verifies that an oversized CheckInterval is clamped rather than overflowing
+func TestContextHugeInterval(t *testing.T) {
+	key := make([]byte, KeyLen)
+	nonce := make([]byte, NonceLen)
+	msg := make([]byte, 1000)
+	ct := make([]byte, len(msg))
+	tag := make([]byte, TagLen)
+
+	for _, interval := range []int{maxCheckInterval, maxCheckInterval + 1, math.MaxInt} {
+		opts := &ContextOptions{CheckInterval: interval}
+		if err := EncryptContext(context.Background(), msg, nil, key, nonce, ct, tag, opts); err != nil {
+			t.Fatalf("EncryptContext with interval %d failed: %v", interval, err)
+		}
+		pt := make([]byte, len(ct))
+		if err := DecryptContext(context.Background(), ct, tag, nil, key, nonce, pt, opts); err != nil {
+			t.Fatalf("DecryptContext with interval %d failed: %v", interval, err)
+		}
+	}
+}
+
+// TestContextCancellation verifies that cancellation stops work and wipes partial output
+func TestContextCancellation(t *testing.T) {
+	key := make([]byte, KeyLen)
+	nonce := make([]byte, NonceLen)
+	msg := bytes.Repeat([]byte{0xaa}, 4096)
+	ct := make([]byte, len(msg))
+	tag := make([]byte, TagLen)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts := &ContextOptions{
+		CheckInterval: 1,
+		Progress: func(processed int64) {
+			if processed == 1024 {
+				cancel()
+			}
+		},
+	}
+
+	err := EncryptContext(ctx, msg, nil, key, nonce, ct, tag, opts)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if !bytes.Equal(ct, make([]byte, len(ct))) || !bytes.Equal(tag, make([]byte, TagLen)) {
+		t.Error("Partial output was not wiped")
+	}
+
+	// Decrypt a valid ciphertext and cancel midway
+	if err := EncryptTo(msg, nil, key, nonce, ct, tag); err != nil {
+		t.Fatalf("EncryptTo failed: %v", err)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	pt := make([]byte, len(ct))
+	err = DecryptContext(ctx, ct, tag, nil, key, nonce, pt, opts)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if !bytes.Equal(pt, make([]byte, len(pt))) {
+		t.Error("Partial plaintext was not wiped")
+	}
+}
@@ -0,0 +1,415 @@
+// Package cose implements COSE_Encrypt0 and COSE_Encrypt (RFC 9052) with HiAE as the content encryption algorithm
+//
+// HiAE has no registered COSE algorithm, so it uses AlgHiAE from the private-use range. The
+// algorithm is carried in the protected header, and the 16-byte IV and the optional key ID
+// in the unprotected header. The ciphertext is the HiAE ciphertext followed by its tag, and
+// the associated data is the deterministic CBOR encoding of
+//
+//	Enc_structure = [context, body_protected, external_aad]
+//
+// with context "Encrypt0" or "Encrypt", as RFC 9052 Section 5.3 describes. COSE_Encrypt
+// recipients use either direct key agreement with a shared key, or A256KW key wrapping of a
+// random content encryption key.
+package cose
+
+import (
+	"crypto/rand"
+	"errors"
+
+	hiae "github.com/hiae-aead/go-hiae"
+	"github.com/hiae-aead/go-hiae/cbor"
+	"github.com/hiae-aead/go-hiae/internal/keywrap"
+)
+
+// Algorithm identifiers
+const (
+	// AlgHiAE is a private-use content encryption algorithm identifier for HiAE
+	AlgHiAE int64 = -65537
+	// AlgDirect uses the recipient's shared key as the content encryption key
+	AlgDirect int64 = -6
+	// AlgA256KW wraps the content encryption key with AES-256 Key Wrap
+	AlgA256KW int64 = -5
+)
+
+// Header labels
+const (
+	HeaderAlg  int64 = 1
+	HeaderCrit int64 = 2
+	HeaderKID  int64 = 4
+	HeaderIV   int64 = 5
+)
+
+// CBOR tags of the message types
+const (
+	TagEncrypt0 = 16
+	TagEncrypt  = 96
+)
+
+var (
+	// ErrMalformed is returned for messages that are not valid COSE structures
+	ErrMalformed = errors.New("cose: malformed message")
+	// ErrUnsupported is returned for algorithms and critical headers this package does not implement
+	ErrUnsupported = errors.New("cose: unsupported algorithm or header")
+	// ErrDecrypt is returned when a message fails authentication or no recipient matches the key
+	ErrDecrypt = errors.New("cose: decryption failed")
+)
+
+// Recipient is a shared key used to reach one recipient of a COSE_Encrypt message
+type Recipient struct {
+	Alg int64  // AlgDirect or AlgA256KW
+	Key []byte // 32 bytes
+	KID []byte // Optional key ID
+}
+
+// bodyProtected encodes the protected header of a message body
+func bodyProtected() []byte {
+	b, _ := cbor.Marshal(map[any]any{HeaderAlg: AlgHiAE})
+	return b
+}
+
+// encStructure encodes the associated data for a message body
+func encStructure(context string, protected, externalAAD []byte) ([]byte, error) {
+	if externalAAD == nil {
+		externalAAD = []byte{}
+	}
+	return cbor.Marshal([]any{context, protected, externalAAD})
+}
+
+// encryptBody seals plaintext under cek and returns the unprotected IV and ciphertext || tag
+func encryptBody(context string, cek, protected, plaintext, externalAAD []byte) (iv, ct []byte, err error) {
+	ad, err := encStructure(context, protected, externalAAD)
+	if err != nil {
+		return nil, nil, err
+	}
+	iv = make([]byte, hiae.NonceLen)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, nil, err
+	}
+	ct = make([]byte, len(plaintext)+hiae.TagLen)
+	if err := hiae.EncryptTo(plaintext, ad, cek, iv, ct[:len(plaintext)], ct[len(plaintext):]); err != nil {
+		return nil, nil, err
+	}
+	return iv, ct, nil
+}
+
+// decryptBody opens ciphertext || tag under cek
+func decryptBody(context string, cek, protected, iv, ct, externalAAD []byte) ([]byte, error) {
+	if len(ct) < hiae.TagLen {
+		return nil, ErrMalformed
+	}
+	ad, err := encStructure(context, protected, externalAAD)
+	if err != nil {
+		return nil, err
+	}
+	n := len(ct) - hiae.TagLen
+	pt := make([]byte, n)
+	if err := hiae.DecryptTo(ct[:n], ct[n:], ad, cek, iv, pt); err != nil {
+		return nil, ErrDecrypt
+	}
+	return pt, nil
+}
+
+// headers holds the parsed, merged protected and unprotected headers of one layer
+type headers struct {
+	alg    int64
+	hasAlg bool
+	kid    []byte
+	iv     []byte
+}
+
+// parseHeaders decodes a protected header bstr and an unprotected map and merges them
+func parseHeaders(protected []byte, unprotected any) (*headers, error) {
+	um, ok := unprotected.(map[any]any)
+	if !ok {
+		return nil, ErrMalformed
+	}
+	pm := map[any]any{}
+	if len(protected) > 0 {
+		v, err := cbor.Unmarshal(protected)
+		if err != nil {
+			return nil, ErrMalformed
+		}
+		if pm, ok = v.(map[any]any); !ok {
+			return nil, ErrMalformed
+		}
+	}
+
+	// A label must not appear in both buckets
+	for label := range um {
+		if _, dup := pm[label]; dup {
+			return nil, ErrMalformed
+		}
+	}
+
+	h := &headers{}
+	for _, m := range []map[any]any{pm, um} {
+		for label, v := range m {
+			switch label {
+			case HeaderAlg:
+				alg, ok := v.(int64)
+				if !ok {
+					return nil, ErrMalformed
+				}
+				h.alg, h.hasAlg = alg, true
+			case HeaderKID:
+				if h.kid, ok = v.([]byte); !ok {
+					return nil, ErrMalformed
+				}
+			case HeaderIV:
+				if h.iv, ok = v.([]byte); !ok {
+					return nil, ErrMalformed
+				}
+			case HeaderCrit:
+				return nil, ErrUnsupported
+			}
+		}
+	}
+	return h, nil
+}
+
+// untag strips the expected tag, if present, and returns the array of a COSE structure
+func untag(data []byte, tag uint64, n int) ([]any, error) {
+	v, err := cbor.Unmarshal(data)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	if t, ok := v.(cbor.Tag); ok {
+		if t.Number != tag {
+			return nil, ErrMalformed
+		}
+		v = t.Content
+	}
+	arr, ok := v.([]any)
+	if !ok || len(arr) != n {
+		return nil, ErrMalformed
+	}
+	return arr, nil
+}
+
+// bodyFields extracts protected, unprotected and ciphertext from the first three array elements
+func bodyFields(arr []any) ([]byte, *headers, []byte, error) {
+	protected, ok := arr[0].([]byte)
+	if !ok {
+		return nil, nil, nil, ErrMalformed
+	}
+	h, err := parseHeaders(protected, arr[1])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ct, ok := arr[2].([]byte)
+	if !ok {
+		// Detached content is not supported
+		return nil, nil, nil, ErrUnsupported
+	}
+	return protected, h, ct, nil
+}
+
+// checkBody validates the content algorithm and IV of a message body
+func checkBody(h *headers) error {
+	if !h.hasAlg || h.alg != AlgHiAE {
+		return ErrUnsupported
+	}
+	if len(h.iv) != hiae.NonceLen {
+		return ErrMalformed
+	}
+	return nil
+}
+
+// unprotectedMap builds an unprotected header with an IV and optional key ID
+func unprotectedMap(iv, kid []byte) map[any]any {
+	m := map[any]any{HeaderIV: iv}
+	if len(kid) > 0 {
+		m[HeaderKID] = kid
+	}
+	return m
+}
+
+// SealEncrypt0 encrypts plaintext under key into a tagged COSE_Encrypt0 message
+// kid is optional and is sent in the unprotected header to help the receiver pick the key.
+func SealEncrypt0(key, kid, plaintext, externalAAD []byte) ([]byte, error) {
+	if len(key) != hiae.KeyLen {
+		return nil, errors.New("cose: key must be 32 bytes")
+	}
+	protected := bodyProtected()
+	iv, ct, err := encryptBody("Encrypt0", key, protected, plaintext, externalAAD)
+	if err != nil {
+		return nil, err
+	}
+	return cbor.Marshal(cbor.Tag{Number: TagEncrypt0, Content: []any{protected, unprotectedMap(iv, kid), ct}})
+}
+
+// Encrypt0 is a parsed COSE_Encrypt0 message
+type Encrypt0 struct {
+	KID       []byte // Key ID from the headers, if any
+	protected []byte
+	iv        []byte
+	ct        []byte
+}
+
+// ParseEncrypt0 parses a tagged or untagged COSE_Encrypt0 message
+func ParseEncrypt0(data []byte) (*Encrypt0, error) {
+	arr, err := untag(data, TagEncrypt0, 3)
+	if err != nil {
+		return nil, err
+	}
+	protected, h, ct, err := bodyFields(arr)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkBody(h); err != nil {
+		return nil, err
+	}
+	return &Encrypt0{KID: h.kid, protected: protected, iv: h.iv, ct: ct}, nil
+}
+
+// Open authenticates and decrypts the message
+func (m *Encrypt0) Open(key, externalAAD []byte) ([]byte, error) {
+	if len(key) != hiae.KeyLen {
+		return nil, errors.New("cose: key must be 32 bytes")
+	}
+	return decryptBody("Encrypt0", key, m.protected, m.iv, m.ct, externalAAD)
+}
+
+// SealEncrypt encrypts plaintext for one or more recipients into a tagged COSE_Encrypt message
+// A direct recipient must be the only one, since its key is the content encryption key.
+func SealEncrypt(plaintext, externalAAD []byte, recipients ...Recipient) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("cose: no recipients")
+	}
+	for _, r := range recipients {
+		if len(r.Key) != hiae.KeyLen {
+			return nil, errors.New("cose: key must be 32 bytes")
+		}
+		if r.Alg != AlgDirect && r.Alg != AlgA256KW {
+			return nil, ErrUnsupported
+		}
+		if r.Alg == AlgDirect && len(recipients) > 1 {
+			return nil, errors.New("cose: direct cannot be combined with other recipients")
+		}
+	}
+
+	cek := recipients[0].Key
+	if recipients[0].Alg != AlgDirect {
+		cek = make([]byte, hiae.KeyLen)
+		if _, err := rand.Read(cek); err != nil {
+			return nil, err
+		}
+		defer clear(cek)
+	}
+
+	rs := make([]any, 0, len(recipients))
+	for _, r := range recipients {
+		unprotected := map[any]any{HeaderAlg: r.Alg}
+		if len(r.KID) > 0 {
+			unprotected[HeaderKID] = r.KID
+		}
+		encryptedKey := []byte{}
+		if r.Alg == AlgA256KW {
+			var err error
+			if encryptedKey, err = keywrap.Wrap(r.Key, cek); err != nil {
+				return nil, err
+			}
+		}
+		rs = append(rs, []any{[]byte{}, unprotected, encryptedKey})
+	}
+
+	protected := bodyProtected()
+	iv, ct, err := encryptBody("Encrypt", cek, protected, plaintext, externalAAD)
+	if err != nil {
+		return nil, err
+	}
+	return cbor.Marshal(cbor.Tag{Number: TagEncrypt, Content: []any{protected, unprotectedMap(iv, nil), ct, rs}})
+}
+
+// recipientInfo is a parsed COSE_recipient
+type recipientInfo struct {
+	alg          int64
+	kid          []byte
+	encryptedKey []byte
+}
+
+// Encrypt is a parsed COSE_Encrypt message
+type Encrypt struct {
+	protected  []byte
+	iv         []byte
+	ct         []byte
+	recipients []recipientInfo
+}
+
+// ParseEncrypt parses a tagged or untagged COSE_Encrypt message
+func ParseEncrypt(data []byte) (*Encrypt, error) {
+	arr, err := untag(data, TagEncrypt, 4)
+	if err != nil {
+		return nil, err
+	}
+	protected, h, ct, err := bodyFields(arr)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkBody(h); err != nil {
+		return nil, err
+	}
+
+	rs, ok := arr[3].([]any)
+	if !ok || len(rs) == 0 {
+		return nil, ErrMalformed
+	}
+	m := &Encrypt{protected: protected, iv: h.iv, ct: ct}
+	for _, r := range rs {
+		ra, ok := r.([]any)
+		if !ok || len(ra) != 3 {
+			// Nested recipient layers are not supported
+			return nil, ErrUnsupported
+		}
+		_, rh, encryptedKey, err := bodyFields(ra)
+		if err != nil {
+			return nil, err
+		}
+		if !rh.hasAlg {
+			return nil, ErrMalformed
+		}
+		m.recipients = append(m.recipients, recipientInfo{alg: rh.alg, kid: rh.kid, encryptedKey: encryptedKey})
+	}
+	return m, nil
+}
+
+// KIDs returns the key IDs of all recipients, in order, with nil for recipients without one
+func (m *Encrypt) KIDs() [][]byte {
+	out := make([][]byte, len(m.recipients))
+	for i, r := range m.recipients {
+		out[i] = r.kid
+	}
+	return out
+}
+
+// Open decrypts the message with a recipient key
+// Every recipient whose algorithm, and key ID if r.KID is set, match is tried in turn.
+func (m *Encrypt) Open(r Recipient, externalAAD []byte) ([]byte, error) {
+	if len(r.Key) != hiae.KeyLen {
+		return nil, errors.New("cose: key must be 32 bytes")
+	}
+	for _, ri := range m.recipients {
+		if ri.alg != r.Alg || (len(r.KID) > 0 && string(ri.kid) != string(r.KID)) {
+			continue
+		}
+		var cek []byte
+		switch ri.alg {
+		case AlgDirect:
+			if len(ri.encryptedKey) != 0 {
+				return nil, ErrMalformed
+			}
+			cek = r.Key
+		case AlgA256KW:
+			var err error
+			if cek, err = keywrap.Unwrap(r.Key, ri.encryptedKey); err != nil || len(cek) != hiae.KeyLen {
+				continue
+			}
+		default:
+			continue
+		}
+		if pt, err := decryptBody("Encrypt", cek, m.protected, m.iv, m.ct, externalAAD); err == nil {
+			return pt, nil
+		}
+	}
+	return nil, ErrDecrypt
+}
@@ -0,0 +1,164 @@
+package cose
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/hiae-aead/go-hiae/cbor"
+)
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic("invalid hex string: " + s)
+	}
+	return b
+}
+
+var testKey = bytes.Repeat([]byte{0x42}, 32)
+
+// TestEncStructure pins the protected header and Enc_structure encodings
+func TestEncStructure(t *testing.T) {
+	protected := bodyProtected()
+	if hex.EncodeToString(protected) != "a1013a00010000" {
+		t.Errorf("Unexpected protected header %x", protected)
+	}
+	ad, err := encStructure("Encrypt0", protected, nil)
+	if err != nil {
+		t.Fatalf("encStructure failed: %v", err)
+	}
+	if hex.EncodeToString(ad) != "8368456e63727970743047a1013a0001000040" {
+		t.Errorf("Unexpected Enc_structure %x", ad)
+	}
+}
+
+// TestEncrypt0 round trips COSE_Encrypt0 and checks its layout
+func TestEncrypt0(t *testing.T) {
+	msg, err := SealEncrypt0(testKey, []byte("sensor-17"), []byte("21.5C"), []byte("ext"))
+	if err != nil {
+		t.Fatalf("SealEncrypt0 failed: %v", err)
+	}
+	if msg[0] != 0xd0 {
+		t.Errorf("Expected tag 16, got leading byte %02x", msg[0])
+	}
+
+	m, err := ParseEncrypt0(msg)
+	if err != nil {
+		t.Fatalf("ParseEncrypt0 failed: %v", err)
+	}
+	if string(m.KID) != "sensor-17" {
+		t.Errorf("Unexpected KID %q", m.KID)
+	}
+	pt, err := m.Open(testKey, []byte("ext"))
+	if err != nil || string(pt) != "21.5C" {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if _, err := m.Open(testKey, nil); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Expected ErrDecrypt for missing external AAD, got %v", err)
+	}
+
+	// An Encrypt0 body must not open as the body of a COSE_Encrypt
+	if _, err := ParseEncrypt(msg); !errors.Is(err, ErrMalformed) {
+		t.Errorf("Expected ErrMalformed parsing Encrypt0 as Encrypt, got %v", err)
+	}
+}
+
+// TestEncrypt0Vector opens a pinned COSE_Encrypt0 message
+func TestEncrypt0Vector(t *testing.T) {
+	m, err := ParseEncrypt0(mustHex("d08347a1013a00010000a204426b3105508827d87bb598a028364f283333c6bd3f5689f05ca795e728add39c1bbfee35cfc531d9745a935a"))
+	if err != nil {
+		t.Fatalf("ParseEncrypt0 failed: %v", err)
+	}
+	if string(m.KID) != "k1" {
+		t.Errorf("Unexpected KID %q", m.KID)
+	}
+	pt, err := m.Open(testKey, nil)
+	if err != nil || string(pt) != "pinned" {
+		t.Fatalf("Open failed: %q, %v", pt, err)
+	}
+}
+
+// TestEncrypt round trips COSE_Encrypt with direct and key wrap recipients
+func TestEncrypt(t *testing.T) {
+	a := Recipient{Alg: AlgA256KW, Key: bytes.Repeat([]byte{1}, 32), KID: []byte("a")}
+	b := Recipient{Alg: AlgA256KW, Key: bytes.Repeat([]byte{2}, 32), KID: []byte("b")}
+	msg, err := SealEncrypt([]byte("fleet update"), nil, a, b)
+	if err != nil {
+		t.Fatalf("SealEncrypt failed: %v", err)
+	}
+	m, err := ParseEncrypt(msg)
+	if err != nil {
+		t.Fatalf("ParseEncrypt failed: %v", err)
+	}
+	if kids := m.KIDs(); len(kids) != 2 || string(kids[0]) != "a" || string(kids[1]) != "b" {
+		t.Errorf("Unexpected KIDs %q", kids)
+	}
+	for _, r := range []Recipient{a, b, {Alg: AlgA256KW, Key: b.Key}} {
+		pt, err := m.Open(r, nil)
+		if err != nil || string(pt) != "fleet update" {
+			t.Fatalf("Open for %q failed: %v", r.KID, err)
+		}
+	}
+	if _, err := m.Open(Recipient{Alg: AlgA256KW, Key: testKey}, nil); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Expected ErrDecrypt for unknown key, got %v", err)
+	}
+	if _, err := m.Open(Recipient{Alg: AlgDirect, Key: a.Key}, nil); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Expected ErrDecrypt for algorithm mismatch, got %v", err)
+	}
+
+	direct := Recipient{Alg: AlgDirect, Key: testKey, KID: []byte("d")}
+	msg, _ = SealEncrypt([]byte("direct"), []byte("ext"), direct)
+	m, _ = ParseEncrypt(msg)
+	if pt, err := m.Open(direct, []byte("ext")); err != nil || string(pt) != "direct" {
+		t.Fatalf("Open with direct recipient failed: %v", err)
+	}
+	if _, err := SealEncrypt(nil, nil, direct, a); err == nil {
+		t.Error("Expected error combining direct with other recipients")
+	}
+}
+
+// TestTamper modifies each element of a COSE_Encrypt0 message
+func TestTamper(t *testing.T) {
+	msg, _ := SealEncrypt0(testKey, nil, []byte("tamper"), nil)
+	v, _ := cbor.Unmarshal(msg)
+	arr := v.(cbor.Tag).Content.([]any)
+
+	rebuild := func(f func(arr []any)) []byte {
+		c := []any{append([]byte{}, arr[0].([]byte)...), map[any]any{HeaderIV: append([]byte{}, arr[1].(map[any]any)[HeaderIV].([]byte)...)}, append([]byte{}, arr[2].([]byte)...)}
+		f(c)
+		out, _ := cbor.Marshal(cbor.Tag{Number: TagEncrypt0, Content: c})
+		return out
+	}
+	protectedKID, _ := cbor.Marshal(map[any]any{HeaderAlg: AlgHiAE, HeaderKID: []byte("x")})
+	protectedCrit, _ := cbor.Marshal(map[any]any{HeaderAlg: AlgHiAE, HeaderCrit: []any{int64(99)}})
+	protectedOther, _ := cbor.Marshal(map[any]any{HeaderAlg: int64(3)})
+	cases := map[string][]byte{
+		"iv":              rebuild(func(a []any) { a[1].(map[any]any)[HeaderIV].([]byte)[0] ^= 1 }),
+		"ciphertext":      rebuild(func(a []any) { a[2].([]byte)[0] ^= 1 }),
+		"tag":             rebuild(func(a []any) { c := a[2].([]byte); c[len(c)-1] ^= 1 }),
+		"added kid":       rebuild(func(a []any) { a[0] = protectedKID }),
+		"crit":            rebuild(func(a []any) { a[0] = protectedCrit }),
+		"other alg":       rebuild(func(a []any) { a[0] = protectedOther }),
+		"duplicate label": rebuild(func(a []any) { a[1].(map[any]any)[HeaderAlg] = AlgHiAE }),
+		"short iv":        rebuild(func(a []any) { a[1].(map[any]any)[HeaderIV] = []byte{1} }),
+		"detached":        rebuild(func(a []any) { a[2] = nil }),
+		"wrong tag":       append([]byte{0xd8, 0x60}, msg[1:]...),
+		"trailing":        append(append([]byte{}, msg...), 0),
+	}
+	for name, bad := range cases {
+		m, err := ParseEncrypt0(bad)
+		if err == nil {
+			_, err = m.Open(testKey, nil)
+		}
+		if err == nil {
+			t.Errorf("%s: modification was not detected", name)
+		}
+	}
+	if m, err := ParseEncrypt0(rebuild(func([]any) {})); err != nil {
+		t.Fatalf("Unmodified rebuild failed to parse: %v", err)
+	} else if _, err := m.Open(testKey, nil); err != nil {
+		t.Fatalf("Unmodified rebuild failed to open: %v", err)
+	}
+}
@@ -0,0 +1,166 @@
+// Package datagram provides per-packet HiAE protection with replay detection, in the style of IPsec and DTLS
+//
+// Each packet is sealed as
+//
+//	header = seq(8)
+//	packet = header || HiAE(key, nonce, ad = header, msg = payload) || tag
+//
+// where seq is an explicit 64-bit big-endian sequence number and the nonce is seq,
+// left-padded with zeros to hiae.NonceLen bytes and XORed with a static IV. Receivers
+// accept packets out of order within a sliding window and reject duplicates.
+package datagram
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	hiae "github.com/hiae-aead/go-hiae"
+)
+
+// HeaderLen is the length of the packet header carrying the sequence number
+const HeaderLen = 8
+
+// Overhead is the number of bytes a sealed packet adds to its payload
+const Overhead = HeaderLen + hiae.TagLen
+
+var (
+	// ErrSequenceExhausted is returned once all 2^64 sequence numbers have been used
+	ErrSequenceExhausted = errors.New("datagram: sequence number exhausted")
+	// ErrBadPacket is returned for packets that are malformed or fail authentication
+	ErrBadPacket = errors.New("datagram: bad packet")
+)
+
+// keys holds the key and static IV of one direction
+type keys struct {
+	key [hiae.KeyLen]byte
+	iv  [hiae.NonceLen]byte
+}
+
+func newKeys(key, iv []byte) (keys, error) {
+	var k keys
+	if len(key) != hiae.KeyLen {
+		return k, errors.New("datagram: key must be 32 bytes")
+	}
+	if len(iv) != hiae.NonceLen {
+		return k, errors.New("datagram: iv must be 16 bytes")
+	}
+	copy(k.key[:], key)
+	copy(k.iv[:], iv)
+	return k, nil
+}
+
+// nonce returns the nonce for a sequence number
+func (k *keys) nonce(seq uint64) [hiae.NonceLen]byte {
+	nonce := k.iv
+	var s [8]byte
+	binary.BigEndian.PutUint64(s[:], seq)
+	for i := range s {
+		nonce[hiae.NonceLen-8+i] ^= s[i]
+	}
+	return nonce
+}
+
+// Sealer protects outgoing packets
+type Sealer struct {
+	keys
+	mu        sync.Mutex
+	seq       uint64
+	exhausted bool
+}
+
+// NewSealer returns a Sealer whose first packet uses sequence number 0
+func NewSealer(key, iv []byte) (*Sealer, error) {
+	k, err := newKeys(key, iv)
+	if err != nil {
+		return nil, err
+	}
+	return &Sealer{keys: k}, nil
+}
+
+// Seal appends a protected packet carrying payload to dst
+func (s *Sealer) Seal(dst, payload []byte) ([]byte, error) {
+	s.mu.Lock()
+	if s.exhausted {
+		s.mu.Unlock()
+		return nil, ErrSequenceExhausted
+	}
+	seq := s.seq
+	if seq == ^uint64(0) {
+		s.exhausted = true
+	} else {
+		s.seq++
+	}
+	s.mu.Unlock()
+
+	start := len(dst)
+	dst = append(dst, make([]byte, Overhead+len(payload))...)
+	pkt := dst[start:]
+
+	hdr := pkt[:HeaderLen]
+	binary.BigEndian.PutUint64(hdr, seq)
+	ct := pkt[HeaderLen : HeaderLen+len(payload)]
+	tag := pkt[HeaderLen+len(payload):]
+
+	nonce := s.nonce(seq)
+	if err := hiae.EncryptTo(payload, hdr, s.key[:], nonce[:], ct, tag); err != nil {
+		return nil, err
+	}
+
+	return dst, nil
+}
+
+// Opener verifies incoming packets and rejects replays
+type Opener struct {
+	keys
+	mu     sync.Mutex
+	window *ReplayWindow
+}
+
+// NewOpener returns an Opener with a replay window of windowSize sequence numbers
+// A windowSize of zero selects DefaultWindowSize.
+func NewOpener(key, iv []byte, windowSize int) (*Opener, error) {
+	k, err := newKeys(key, iv)
+	if err != nil {
+		return nil, err
+	}
+	return &Opener{keys: k, window: NewReplayWindow(windowSize)}, nil
+}
+
+// Open verifies a packet and appends its payload to dst
+// It returns the extended dst and the packet's sequence number. Replays and packets that
+// fell behind the window are rejected before decryption.
+func (o *Opener) Open(dst, packet []byte) ([]byte, uint64, error) {
+	if len(packet) < Overhead {
+		return nil, 0, ErrBadPacket
+	}
+	hdr := packet[:HeaderLen]
+	seq := binary.BigEndian.Uint64(hdr)
+
+	o.mu.Lock()
+	err := o.window.Check(seq)
+	o.mu.Unlock()
+	if err != nil {
+		return nil, seq, err
+	}
+
+	ct := packet[HeaderLen : len(packet)-hiae.TagLen]
+	tag := packet[len(packet)-hiae.TagLen:]
+	start := len(dst)
+	dst = append(dst, make([]byte, len(ct))...)
+	nonce := o.nonce(seq)
+	if err := hiae.DecryptTo(ct, tag, hdr, o.key[:], nonce[:], dst[start:]); err != nil {
+		return nil, seq, ErrBadPacket
+	}
+
+	// Record the packet only once it has authenticated; a concurrent duplicate loses here
+	o.mu.Lock()
+	err = o.window.Accept(seq)
+	o.mu.Unlock()
+	if err != nil {
+		clear(dst[start:])
+		return nil, seq, err
+	}
+
+	return dst, seq, nil
+}
@@ -0,0 +1,148 @@
+package datagram
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+var (
+	testKey = bytes.Repeat([]byte{0x11}, 32)
+	testIV  = bytes.Repeat([]byte{0x22}, 16)
+)
+
+// TestReplayWindowBoundaries checks acceptance at the edges of the window
+func TestReplayWindowBoundaries(t *testing.T) {
+	w := NewReplayWindow(64)
+	if w.Size() != 64 {
+		t.Fatalf("Expected size 64, got %d", w.Size())
+	}
+
+	// The window covers top-63 .. top
+	steps := []struct {
+		seq uint64
+		err error
+	}{
+		{100, nil},
+		{100, ErrReplay},
+		{36, ErrTooOld},
+		{37, nil},
+		{101, nil},
+		{37, ErrTooOld},
+		{38, nil},
+		{38, ErrReplay},
+		{165, nil}, // Slides by exactly one window
+		{101, ErrTooOld},
+		{102, nil},
+		{150, nil},
+		{10000, nil}, // Jumping far ahead clears the whole bitmap
+		{9937, nil},
+		{9936, ErrTooOld},
+		{10000, ErrReplay},
+	}
+	for i, st := range steps {
+		err := w.Accept(st.seq)
+		if !errors.Is(err, st.err) {
+			t.Fatalf("Step %d (seq %d): expected %v, got %v", i, st.seq, st.err, err)
+		}
+	}
+}
+
+// TestReplayWindowWraparound exercises ring index wraparound and the top of the sequence space
+func TestReplayWindowWraparound(t *testing.T) {
+	w := NewReplayWindow(128)
+	for seq := uint64(0); seq < 2000; seq += 3 {
+		if err := w.Accept(seq); err != nil {
+			t.Fatalf("Accept(%d) failed: %v", seq, err)
+		}
+		if seq >= 3 {
+			if err := w.Accept(seq - 3); !errors.Is(err, ErrReplay) {
+				t.Fatalf("Accept(%d) should be a replay, got %v", seq-3, err)
+			}
+			if err := w.Accept(seq - 1); err != nil {
+				t.Fatalf("Late Accept(%d) failed: %v", seq-1, err)
+			}
+		}
+	}
+
+	max := ^uint64(0)
+	w = NewReplayWindow(128)
+	for _, seq := range []uint64{max - 200, max - 1, max, max - 127} {
+		if err := w.Accept(seq); err != nil {
+			t.Fatalf("Accept(%d) failed: %v", seq, err)
+		}
+	}
+	if err := w.Accept(max); !errors.Is(err, ErrReplay) {
+		t.Errorf("Expected ErrReplay at the top of the sequence space, got %v", err)
+	}
+	if err := w.Accept(max - 128); !errors.Is(err, ErrTooOld) {
+		t.Errorf("Expected ErrTooOld, got %v", err)
+	}
+}
+
+// TestDatagramRoundTrip verifies sealing, reordering and replay rejection
+func TestDatagramRoundTrip(t *testing.T) {
+	s, err := NewSealer(testKey, testIV)
+	if err != nil {
+		t.Fatalf("NewSealer failed: %v", err)
+	}
+	o, err := NewOpener(testKey, testIV, 64)
+	if err != nil {
+		t.Fatalf("NewOpener failed: %v", err)
+	}
+
+	var pkts [][]byte
+	for i := 0; i < 5; i++ {
+		pkt, err := s.Seal(nil, []byte{byte(i), 't', 'l', 'm'})
+		if err != nil {
+			t.Fatalf("Seal failed: %v", err)
+		}
+		pkts = append(pkts, pkt)
+	}
+
+	for _, i := range []int{0, 3, 1, 4, 2} {
+		payload, seq, err := o.Open(nil, pkts[i])
+		if err != nil {
+			t.Fatalf("Open of packet %d failed: %v", i, err)
+		}
+		if seq != uint64(i) || payload[0] != byte(i) {
+			t.Fatalf("Packet %d: got seq %d payload %x", i, seq, payload)
+		}
+	}
+	if _, _, err := o.Open(nil, pkts[2]); !errors.Is(err, ErrReplay) {
+		t.Errorf("Expected ErrReplay, got %v", err)
+	}
+
+	// A forged sequence number fails authentication and is not recorded
+	forged := append([]byte{}, pkts[4]...)
+	forged[7] = 9
+	if _, _, err := o.Open(nil, forged); !errors.Is(err, ErrBadPacket) {
+		t.Errorf("Expected ErrBadPacket, got %v", err)
+	}
+	if err := o.window.Check(9); err != nil {
+		t.Errorf("Forged sequence number was recorded: %v", err)
+	}
+	if _, _, err := o.Open(nil, pkts[0][:Overhead-1]); !errors.Is(err, ErrBadPacket) {
+		t.Errorf("Expected ErrBadPacket for short packet, got %v", err)
+	}
+}
+
+// TestSealerExhaustion verifies that the sealer stops after the last sequence number
+func TestSealerExhaustion(t *testing.T) {
+	s, _ := NewSealer(testKey, testIV)
+	s.seq = ^uint64(0) - 1
+	o, _ := NewOpener(testKey, testIV, 0)
+
+	for i := 0; i < 2; i++ {
+		pkt, err := s.Seal(nil, []byte("end"))
+		if err != nil {
+			t.Fatalf("Seal %d failed: %v", i, err)
+		}
+		if _, _, err := o.Open(nil, pkt); err != nil {
+			t.Fatalf("Open %d failed: %v", i, err)
+		}
+	}
+	if _, err := s.Seal(nil, []byte("wrap")); !errors.Is(err, ErrSequenceExhausted) {
+		t.Fatalf("Expected ErrSequenceExhausted, got %v", err)
+	}
+}
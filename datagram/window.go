@@ -0,0 +1,92 @@
+package datagram
+
+import "errors"
+
+// DefaultWindowSize is the default number of sequence numbers tracked by a ReplayWindow
+const DefaultWindowSize = 1024
+
+var (
+	// ErrReplay is returned for a sequence number that was already accepted
+	ErrReplay = errors.New("datagram: replayed packet")
+	// ErrTooOld is returned for a sequence number that fell behind the window
+	ErrTooOld = errors.New("datagram: packet outside replay window")
+)
+
+// ReplayWindow is a sliding anti-replay bitmap in the style of RFC 6479
+//
+// The bitmap is a ring of 64-bit blocks with one block more than the window needs,
+// so that sliding forward only clears whole blocks.
+type ReplayWindow struct {
+	size    uint64
+	top     uint64 // Highest accepted sequence number
+	started bool
+	bitmap  []uint64
+}
+
+// NewReplayWindow returns a window that accepts reordering within size sequence numbers
+// The size is rounded up to a multiple of 64. Zero selects DefaultWindowSize.
+func NewReplayWindow(size int) *ReplayWindow {
+	if size <= 0 {
+		size = DefaultWindowSize
+	}
+	blocks := (size + 63) / 64
+	return &ReplayWindow{
+		size:   uint64(blocks * 64),
+		bitmap: make([]uint64, blocks+1),
+	}
+}
+
+// Size returns the number of sequence numbers covered by the window
+func (w *ReplayWindow) Size() int {
+	return int(w.size)
+}
+
+// Top returns the highest accepted sequence number, and false if nothing was accepted yet
+func (w *ReplayWindow) Top() (uint64, bool) {
+	return w.top, w.started
+}
+
+// position returns the bitmap block and bit of a sequence number
+func (w *ReplayWindow) position(seq uint64) (int, uint64) {
+	return int((seq / 64) % uint64(len(w.bitmap))), uint64(1) << (seq % 64)
+}
+
+// Check reports whether seq would be accepted, without recording it
+func (w *ReplayWindow) Check(seq uint64) error {
+	if !w.started || seq > w.top {
+		return nil
+	}
+	if w.top-seq >= w.size {
+		return ErrTooOld
+	}
+	block, bit := w.position(seq)
+	if w.bitmap[block]&bit != 0 {
+		return ErrReplay
+	}
+	return nil
+}
+
+// Accept checks seq and records it, sliding the window forward if needed
+// It must only be called for packets that have been authenticated.
+func (w *ReplayWindow) Accept(seq uint64) error {
+	if err := w.Check(seq); err != nil {
+		return err
+	}
+
+	if !w.started || seq > w.top {
+		if w.started {
+			cur, next := w.top/64, seq/64
+			n := min(next-cur, uint64(len(w.bitmap)))
+			for i := uint64(1); i <= n; i++ {
+				w.bitmap[(cur+i)%uint64(len(w.bitmap))] = 0
+			}
+		}
+		w.top = seq
+		w.started = true
+	}
+
+	block, bit := w.position(seq)
+	w.bitmap[block] |= bit
+
+	return nil
+}
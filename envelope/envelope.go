@@ -0,0 +1,165 @@
+// Package envelope implements envelope encryption with a pluggable key-encryption key
+//
+// Every object is sealed under a fresh random data key, and the data key is wrapped by a
+// KEKProvider, which may keep its key in an HSM or a cloud KMS. The output is self-describing:
+//
+//	header = "HENV" || version (1) || len(providerID) (1) || providerID
+//	         || len(wrappedKey) (2, big-endian) || wrappedKey || nonce (16)
+//	blob   = header || HiAE(dataKey, nonce, ad, object) || tag
+//
+// The associated data is hiae.EncodeADFields(header, ad), so the header and the caller's
+// associated data are both authenticated and cannot be confused with each other.
+package envelope
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+
+	hiae "github.com/hiae-aead/go-hiae"
+)
+
+const (
+	version     = 1
+	maxIDLen    = 255
+	maxWrapLen  = 65535
+	fixedHeader = 4 + 1 + 1 + 2 + hiae.NonceLen
+)
+
+var magic = []byte("HENV")
+
+var (
+	// ErrBadBlob is returned for blobs that are not in the envelope format
+	ErrBadBlob = errors.New("envelope: malformed blob")
+	// ErrProviderMismatch is returned when a blob names a different provider than the one given
+	ErrProviderMismatch = errors.New("envelope: blob was wrapped by another provider")
+	// ErrDecrypt is returned when the object fails authentication
+	ErrDecrypt = errors.New("envelope: decryption failed")
+)
+
+// KEKProvider wraps and unwraps data keys with a key-encryption key it controls
+type KEKProvider interface {
+	// ID names the provider and key, and is stored in every blob it wraps for.
+	ID() string
+	// Wrap encrypts a data key.
+	Wrap(ctx context.Context, dataKey []byte) ([]byte, error)
+	// Unwrap recovers a data key from the output of Wrap.
+	Unwrap(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// header is the parsed form of a blob header
+type header struct {
+	providerID string
+	wrapped    []byte
+	nonce      []byte
+	raw        []byte // Encoded header, authenticated as associated data
+}
+
+// parseHeader splits a blob into its header and the ciphertext and tag that follow
+func parseHeader(blob []byte) (*header, []byte, error) {
+	if len(blob) < fixedHeader+hiae.TagLen || string(blob[:4]) != string(magic) || blob[4] != version {
+		return nil, nil, ErrBadBlob
+	}
+	p := 5
+	idLen := int(blob[p])
+	p++
+	if len(blob) < p+idLen+2 {
+		return nil, nil, ErrBadBlob
+	}
+	h := &header{providerID: string(blob[p : p+idLen])}
+	p += idLen
+	wrapLen := int(binary.BigEndian.Uint16(blob[p:]))
+	p += 2
+	if len(blob) < p+wrapLen+hiae.NonceLen+hiae.TagLen {
+		return nil, nil, ErrBadBlob
+	}
+	h.wrapped = blob[p : p+wrapLen]
+	p += wrapLen
+	h.nonce = blob[p : p+hiae.NonceLen]
+	p += hiae.NonceLen
+	h.raw = blob[:p]
+	return h, blob[p:], nil
+}
+
+// ProviderID returns the ID of the provider that wrapped a blob's data key
+// It lets callers holding several providers pick the one to pass to Open.
+func ProviderID(blob []byte) (string, error) {
+	h, _, err := parseHeader(blob)
+	if err != nil {
+		return "", err
+	}
+	return h.providerID, nil
+}
+
+// Seal encrypts an object under a fresh data key wrapped by p
+func Seal(ctx context.Context, p KEKProvider, object, ad []byte) ([]byte, error) {
+	id := p.ID()
+	if len(id) > maxIDLen {
+		return nil, errors.New("envelope: provider ID longer than 255 bytes")
+	}
+
+	var dataKey [hiae.KeyLen]byte
+	if _, err := rand.Read(dataKey[:]); err != nil {
+		return nil, err
+	}
+	defer clear(dataKey[:])
+	wrapped, err := p.Wrap(ctx, dataKey[:])
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) > maxWrapLen {
+		return nil, errors.New("envelope: wrapped key longer than 65535 bytes")
+	}
+
+	hdrLen := fixedHeader + len(id) + len(wrapped)
+	out := make([]byte, hdrLen, hdrLen+len(object)+hiae.TagLen)
+	copy(out, magic)
+	out[4] = version
+	out[5] = byte(len(id))
+	n := 6 + copy(out[6:], id)
+	binary.BigEndian.PutUint16(out[n:], uint16(len(wrapped)))
+	n += 2 + copy(out[n+2:], wrapped)
+	nonce := out[n : n+hiae.NonceLen]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out = out[:hdrLen+len(object)+hiae.TagLen]
+	ct := out[hdrLen : hdrLen+len(object)]
+	tag := out[hdrLen+len(object):]
+	if err := hiae.EncryptToFields(object, dataKey[:], nonce, ct, tag, out[:hdrLen], ad); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// Open unwraps the data key of a blob with p and decrypts the object
+func Open(ctx context.Context, p KEKProvider, blob, ad []byte) ([]byte, error) {
+	h, rest, err := parseHeader(blob)
+	if err != nil {
+		return nil, err
+	}
+	if h.providerID != p.ID() {
+		return nil, ErrProviderMismatch
+	}
+
+	dataKey, err := p.Unwrap(ctx, h.wrapped)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(dataKey)
+	if len(dataKey) != hiae.KeyLen {
+		return nil, errors.New("envelope: provider returned a data key of the wrong length")
+	}
+
+	ct := rest[:len(rest)-hiae.TagLen]
+	tag := rest[len(rest)-hiae.TagLen:]
+	object := make([]byte, len(ct))
+	if err := hiae.DecryptToFields(ct, tag, dataKey, h.nonce, object, h.raw, ad); err != nil {
+		return nil, ErrDecrypt
+	}
+
+	return object, nil
+}
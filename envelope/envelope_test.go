@@ -0,0 +1,142 @@
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic("invalid hex string: " + s)
+	}
+	return b
+}
+
+func mustProvider(t *testing.T, id string, b byte) *LocalProvider {
+	t.Helper()
+	p, err := NewLocalProvider(id, bytes.Repeat([]byte{b}, 32))
+	if err != nil {
+		t.Fatalf("NewLocalProvider failed: %v", err)
+	}
+	return p
+}
+
+// reverseProvider stands in for a remote KMS and records the contexts it is called with
+type reverseProvider struct {
+	calls []context.Context
+}
+
+func (r *reverseProvider) ID() string { return "kms:test" }
+
+func (r *reverseProvider) Wrap(ctx context.Context, dataKey []byte) ([]byte, error) {
+	r.calls = append(r.calls, ctx)
+	out := make([]byte, len(dataKey))
+	for i, b := range dataKey {
+		out[len(out)-1-i] = b
+	}
+	return out, nil
+}
+
+func (r *reverseProvider) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	return r.Wrap(ctx, wrapped)
+}
+
+// TestRoundTrip seals and opens objects with the local and a custom provider
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "kek")
+	if err := GenerateLocalKEK(path); err != nil {
+		t.Fatalf("GenerateLocalKEK failed: %v", err)
+	}
+	local, err := LoadLocalProvider("local:test", path)
+	if err != nil {
+		t.Fatalf("LoadLocalProvider failed: %v", err)
+	}
+
+	for _, p := range []KEKProvider{local, &reverseProvider{}} {
+		for _, size := range []int{0, 1, 1000} {
+			object := bytes.Repeat([]byte{0xab}, size)
+			blob, err := Seal(ctx, p, object, []byte("object-7"))
+			if err != nil {
+				t.Fatalf("%s: Seal failed: %v", p.ID(), err)
+			}
+			id, err := ProviderID(blob)
+			if err != nil || id != p.ID() {
+				t.Errorf("%s: ProviderID returned %q, %v", p.ID(), id, err)
+			}
+			got, err := Open(ctx, p, blob, []byte("object-7"))
+			if err != nil || !bytes.Equal(got, object) {
+				t.Fatalf("%s: Open failed: %v", p.ID(), err)
+			}
+			if _, err := Open(ctx, p, blob, []byte("object-8")); !errors.Is(err, ErrDecrypt) {
+				t.Errorf("%s: expected ErrDecrypt for wrong associated data, got %v", p.ID(), err)
+			}
+		}
+	}
+
+	kms := &reverseProvider{}
+	type key struct{}
+	kctx := context.WithValue(ctx, key{}, 1)
+	blob, _ := Seal(kctx, kms, nil, nil)
+	Open(kctx, kms, blob, nil)
+	if len(kms.calls) != 2 || kms.calls[0] != kctx || kms.calls[1] != kctx {
+		t.Error("Provider was not called with the caller's context")
+	}
+}
+
+// TestWrongProvider checks that blobs only open with the provider that wrapped them
+func TestWrongProvider(t *testing.T) {
+	ctx := context.Background()
+	blob, _ := Seal(ctx, mustProvider(t, "a", 1), []byte("secret"), nil)
+
+	if _, err := Open(ctx, mustProvider(t, "b", 1), blob, nil); !errors.Is(err, ErrProviderMismatch) {
+		t.Errorf("Expected ErrProviderMismatch, got %v", err)
+	}
+	if _, err := Open(ctx, mustProvider(t, "a", 2), blob, nil); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Expected ErrDecrypt for wrong KEK, got %v", err)
+	}
+	if _, err := NewLocalProvider("short", make([]byte, 31)); err == nil {
+		t.Error("Expected error for short KEK")
+	}
+}
+
+// TestTamper checks that every part of the blob is authenticated
+func TestTamper(t *testing.T) {
+	ctx := context.Background()
+	p := &reverseProvider{}
+	blob, _ := Seal(ctx, p, []byte("tamper test"), nil)
+
+	// The reversible provider accepts any wrapped key, so only the AEAD catches changes
+	for i := range blob {
+		if i < 4 {
+			continue
+		}
+		bad := append([]byte{}, blob...)
+		bad[i] ^= 1
+		if _, err := Open(ctx, p, bad, nil); err == nil {
+			t.Fatalf("Modification at offset %d was not detected", i)
+		}
+	}
+	for _, n := range []int{0, 4, fixedHeader, len(blob) - 1} {
+		if _, err := Open(ctx, p, blob[:n], nil); err == nil {
+			t.Errorf("Truncation to %d bytes was not detected", n)
+		}
+	}
+	if _, err := ProviderID([]byte("XENV")); !errors.Is(err, ErrBadBlob) {
+		t.Errorf("Expected ErrBadBlob, got %v", err)
+	}
+}
+
+// TestVector opens a pinned blob sealed by the local provider
+func TestVector(t *testing.T) {
+	p := mustProvider(t, "local:vector", 0x5a)
+	got, err := Open(context.Background(), p, mustHex("48454e56010c6c6f63616c3a766563746f72004004b0e69ceb81a5f2072fc6a8ec47f7a431fb7c3b4dc966711d666b1ad379a7706c0304221dab5cc6976d3b618bc3f5e2e1f65100544b40c47d9b2a3ab1a934575fb2e175d849a9c4b96905cad6c6b9e17b84d1c83ea4e4cba8065aced8ee43823c81ad219e0406c20d951eae39"), []byte("ad"))
+	if err != nil || string(got) != "pinned object" {
+		t.Fatalf("Open of pinned blob failed: %q, %v", got, err)
+	}
+}
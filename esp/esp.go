@@ -0,0 +1,219 @@
+// Package esp implements an IPsec ESP transform with HiAE as the combined-mode algorithm, in the spirit of RFC 4106
+//
+// An ESP packet is laid out as
+//
+//	SPI(4) || SeqNum(4) || IV(8) || HiAE(payload || padding || padLen(1) || nextHeader(1)) || ICV(16)
+//
+// The keying material of a security association is a 32-byte HiAE key followed by an
+// 8-byte salt, and the nonce is salt || IV. The explicit IV is the 64-bit sequence
+// number, which is unique per SA. The associated data is SPI || SeqNum, or
+// SPI || SeqNum high || SeqNum low when extended sequence numbers (ESN) are used.
+// Padding follows RFC 4303: bytes 1, 2, 3, ... aligning the trailer to 4 bytes.
+package esp
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	hiae "github.com/hiae-aead/go-hiae"
+	"github.com/hiae-aead/go-hiae/datagram"
+)
+
+// Transform parameters
+const (
+	SaltLen   = 8
+	IVLen     = 8
+	ICVLen    = hiae.TagLen
+	KeyMatLen = hiae.KeyLen + SaltLen
+
+	headerLen  = 8 // SPI || SeqNum
+	trailerLen = 2 // padLen || nextHeader
+)
+
+var (
+	// ErrSequenceExhausted is returned once an outbound SA has used its last sequence number
+	ErrSequenceExhausted = errors.New("esp: sequence number exhausted")
+	// ErrBadPacket is returned for packets that are malformed or fail authentication
+	ErrBadPacket = errors.New("esp: bad packet")
+	// ErrWrongSPI is returned for packets addressed to a different SA
+	ErrWrongSPI = errors.New("esp: SPI does not match SA")
+)
+
+// SA is one direction of an ESP security association
+type SA struct {
+	spi  uint32
+	key  [hiae.KeyLen]byte
+	salt [SaltLen]byte
+	esn  bool
+
+	mu     sync.Mutex
+	seq    uint64 // Last outbound sequence number
+	window *datagram.ReplayWindow
+}
+
+// NewSA returns a security association for the given SPI and keying material
+// keymat is a 32-byte key followed by an 8-byte salt. windowSize sets the inbound
+// anti-replay window; zero selects datagram.DefaultWindowSize.
+func NewSA(spi uint32, keymat []byte, esn bool, windowSize int) (*SA, error) {
+	if len(keymat) != KeyMatLen {
+		return nil, errors.New("esp: keying material must be 40 bytes")
+	}
+	sa := &SA{spi: spi, esn: esn, window: datagram.NewReplayWindow(windowSize)}
+	copy(sa.key[:], keymat[:hiae.KeyLen])
+	copy(sa.salt[:], keymat[hiae.KeyLen:])
+	return sa, nil
+}
+
+// aad builds the associated data for a full sequence number
+func (sa *SA) aad(buf []byte, seq uint64) []byte {
+	binary.BigEndian.PutUint32(buf[0:4], sa.spi)
+	if sa.esn {
+		binary.BigEndian.PutUint32(buf[4:8], uint32(seq>>32))
+		binary.BigEndian.PutUint32(buf[8:12], uint32(seq))
+		return buf[:12]
+	}
+	binary.BigEndian.PutUint32(buf[4:8], uint32(seq))
+	return buf[:8]
+}
+
+// nonce builds salt || IV
+func (sa *SA) nonce(iv []byte) [hiae.NonceLen]byte {
+	var nonce [hiae.NonceLen]byte
+	copy(nonce[:SaltLen], sa.salt[:])
+	copy(nonce[SaltLen:], iv)
+	return nonce
+}
+
+// Encapsulate appends an ESP packet carrying payload to dst, using the next outbound sequence number
+func (sa *SA) Encapsulate(dst, payload []byte, nextHeader byte) ([]byte, error) {
+	sa.mu.Lock()
+	limit := uint64(1<<32 - 1)
+	if sa.esn {
+		limit = ^uint64(0)
+	}
+	if sa.seq == limit {
+		sa.mu.Unlock()
+		return nil, ErrSequenceExhausted
+	}
+	sa.seq++
+	seq := sa.seq
+	sa.mu.Unlock()
+
+	padLen := (4 - (len(payload)+trailerLen)%4) % 4
+	ptLen := len(payload) + padLen + trailerLen
+
+	start := len(dst)
+	dst = append(dst, make([]byte, headerLen+IVLen+ptLen+ICVLen)...)
+	pkt := dst[start:]
+
+	binary.BigEndian.PutUint32(pkt[0:4], sa.spi)
+	binary.BigEndian.PutUint32(pkt[4:8], uint32(seq))
+	iv := pkt[headerLen : headerLen+IVLen]
+	binary.BigEndian.PutUint64(iv, seq)
+
+	pt := pkt[headerLen+IVLen : headerLen+IVLen+ptLen]
+	copy(pt, payload)
+	for i := 0; i < padLen; i++ {
+		pt[len(payload)+i] = byte(i + 1)
+	}
+	pt[ptLen-2] = byte(padLen)
+	pt[ptLen-1] = nextHeader
+
+	var aadBuf [12]byte
+	nonce := sa.nonce(iv)
+	icv := pkt[headerLen+IVLen+ptLen:]
+	if err := hiae.EncryptTo(pt, sa.aad(aadBuf[:], seq), sa.key[:], nonce[:], pt, icv); err != nil {
+		return nil, err
+	}
+
+	return dst, nil
+}
+
+// inferSeq reconstructs the full sequence number from its low 32 bits, following RFC 4303 Appendix A2.2
+func (sa *SA) inferSeq(seqLow uint32) uint64 {
+	if !sa.esn {
+		return uint64(seqLow)
+	}
+	top, ok := sa.window.Top()
+	if !ok {
+		return uint64(seqLow)
+	}
+	w := uint32(sa.window.Size())
+	tl, th := uint32(top), uint32(top>>32)
+	var seqHigh uint32
+	if tl >= w-1 {
+		// The window lies within one sequence number subspace
+		seqHigh = th
+		if seqLow < tl-w+1 {
+			seqHigh = th + 1
+		}
+	} else {
+		// The window spans two subspaces
+		seqHigh = th
+		if seqLow >= tl-w+1 && th > 0 {
+			seqHigh = th - 1
+		}
+	}
+	return uint64(seqHigh)<<32 | uint64(seqLow)
+}
+
+// Decapsulate verifies an ESP packet and appends its payload to dst
+// It returns the extended dst, the next header value and the full sequence number.
+func (sa *SA) Decapsulate(dst, packet []byte) ([]byte, byte, uint64, error) {
+	if len(packet) < headerLen+IVLen+trailerLen+ICVLen {
+		return nil, 0, 0, ErrBadPacket
+	}
+	if binary.BigEndian.Uint32(packet[0:4]) != sa.spi {
+		return nil, 0, 0, ErrWrongSPI
+	}
+
+	sa.mu.Lock()
+	seq := sa.inferSeq(binary.BigEndian.Uint32(packet[4:8]))
+	err := sa.window.Check(seq)
+	sa.mu.Unlock()
+	if seq == 0 {
+		return nil, 0, seq, ErrBadPacket
+	}
+	if err != nil {
+		return nil, 0, seq, err
+	}
+
+	iv := packet[headerLen : headerLen+IVLen]
+	ct := packet[headerLen+IVLen : len(packet)-ICVLen]
+	icv := packet[len(packet)-ICVLen:]
+
+	start := len(dst)
+	dst = append(dst, make([]byte, len(ct))...)
+	pt := dst[start:]
+
+	var aadBuf [12]byte
+	nonce := sa.nonce(iv)
+	if err := hiae.DecryptTo(ct, icv, sa.aad(aadBuf[:], seq), sa.key[:], nonce[:], pt); err != nil {
+		return nil, 0, seq, ErrBadPacket
+	}
+
+	sa.mu.Lock()
+	err = sa.window.Accept(seq)
+	sa.mu.Unlock()
+	if err != nil {
+		clear(pt)
+		return nil, 0, seq, err
+	}
+
+	padLen := int(pt[len(pt)-2])
+	nextHeader := pt[len(pt)-1]
+	payloadLen := len(pt) - trailerLen - padLen
+	if payloadLen < 0 {
+		clear(pt)
+		return nil, 0, seq, ErrBadPacket
+	}
+	for i := 0; i < padLen; i++ {
+		if pt[payloadLen+i] != byte(i+1) {
+			clear(pt)
+			return nil, 0, seq, ErrBadPacket
+		}
+	}
+
+	return dst[:start+payloadLen], nextHeader, seq, nil
+}
@@ -0,0 +1,149 @@
+package esp
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/hiae-aead/go-hiae/datagram"
+)
+
+var testKeyMat = mustHex("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1fcafebabefacedbad")
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic("invalid hex string: " + s)
+	}
+	return b
+}
+
+// Known-answer packets for SPI 0x4321
+var espVectors = []struct {
+	name       string
+	esn        bool
+	seq        uint64 // Sequence number of the packet
+	payload    string
+	nextHeader byte
+	packet     string
+}{
+	{
+		name:       "32-bit sequence, padded",
+		seq:        1,
+		payload:    "45000014HiAE",
+		nextHeader: 4,
+		packet:     "00004321000000010000000000000001a5efd1e8a225642514d766e083d577f890299546813f425a73932bb4192d040e",
+	},
+	{
+		name:       "32-bit sequence, aligned",
+		seq:        2,
+		payload:    "ping",
+		nextHeader: 59,
+		packet:     "00004321000000020000000000000002d9e60d9c5038b7f648ec1e27f185a81397da909c62a4311d",
+	},
+	{
+		name:       "ESN crossing into the second subspace",
+		esn:        true,
+		seq:        1 << 32,
+		payload:    "esn payload",
+		nextHeader: 17,
+		packet:     "000043210000000000000001000000007ee31b0a58cdd7eb93b486209d6e8acb39b0db3f126b9d36ca03949ae454fb6f",
+	},
+}
+
+// TestEncapsulateVectors checks outbound packets against known answers
+func TestEncapsulateVectors(t *testing.T) {
+	for _, tv := range espVectors {
+		sa, err := NewSA(0x4321, testKeyMat, tv.esn, 0)
+		if err != nil {
+			t.Fatalf("%s: NewSA failed: %v", tv.name, err)
+		}
+		sa.seq = tv.seq - 1
+		pkt, err := sa.Encapsulate(nil, []byte(tv.payload), tv.nextHeader)
+		if err != nil {
+			t.Fatalf("%s: Encapsulate failed: %v", tv.name, err)
+		}
+		if hex.EncodeToString(pkt) != tv.packet {
+			t.Errorf("%s: packet mismatch\nExpected: %s\nGot:      %s", tv.name, tv.packet, hex.EncodeToString(pkt))
+		}
+	}
+}
+
+// TestDecapsulateVectors checks inbound processing of the known-answer packets
+func TestDecapsulateVectors(t *testing.T) {
+	for _, tv := range espVectors {
+		sa, _ := NewSA(0x4321, testKeyMat, tv.esn, 0)
+		if tv.esn {
+			// Prime the window just below the subspace boundary so the high bits are inferred
+			sa.window.Accept(tv.seq - 10)
+		}
+
+		payload, nh, seq, err := sa.Decapsulate(nil, mustHex(tv.packet))
+		if err != nil {
+			t.Fatalf("%s: Decapsulate failed: %v", tv.name, err)
+		}
+		if string(payload) != tv.payload || nh != tv.nextHeader || seq != tv.seq {
+			t.Errorf("%s: got payload %q next header %d seq %d", tv.name, payload, nh, seq)
+		}
+		if _, _, _, err := sa.Decapsulate(nil, mustHex(tv.packet)); !errors.Is(err, datagram.ErrReplay) {
+			t.Errorf("%s: expected replay, got %v", tv.name, err)
+		}
+	}
+}
+
+// TestDecapsulateTamper verifies that every byte of a packet is protected
+func TestDecapsulateTamper(t *testing.T) {
+	pkt := mustHex(espVectors[0].packet)
+	for i := range pkt {
+		sa, _ := NewSA(0x4321, testKeyMat, false, 0)
+		bad := append([]byte{}, pkt...)
+		bad[i] ^= 0x01
+		if _, _, _, err := sa.Decapsulate(nil, bad); err == nil {
+			t.Errorf("Byte %d: tampered packet accepted", i)
+		}
+	}
+
+	sa, _ := NewSA(0x4321, testKeyMat, false, 0)
+	if _, _, _, err := sa.Decapsulate(nil, pkt[:20]); !errors.Is(err, ErrBadPacket) {
+		t.Errorf("Expected ErrBadPacket for short packet, got %v", err)
+	}
+}
+
+// TestESNRoundTrip verifies sequence number inference across subspace boundaries
+func TestESNRoundTrip(t *testing.T) {
+	out, _ := NewSA(7, testKeyMat, true, 0)
+	in, _ := NewSA(7, testKeyMat, true, 64)
+	out.seq = 1<<32 - 5
+
+	var pkts [][]byte
+	for i := 0; i < 10; i++ {
+		pkt, err := out.Encapsulate(nil, []byte{byte(i)}, 41)
+		if err != nil {
+			t.Fatalf("Encapsulate failed: %v", err)
+		}
+		pkts = append(pkts, pkt)
+	}
+
+	// Deliver out of order across the boundary
+	for _, i := range []int{0, 1, 5, 2, 9, 3, 4, 8, 6, 7} {
+		payload, _, seq, err := in.Decapsulate(nil, pkts[i])
+		if err != nil {
+			t.Fatalf("Packet %d: Decapsulate failed: %v", i, err)
+		}
+		if seq != 1<<32-4+uint64(i) || payload[0] != byte(i) {
+			t.Fatalf("Packet %d: got seq %d payload %x", i, seq, payload)
+		}
+	}
+}
+
+// TestSequenceExhaustion verifies the 32-bit limit without ESN
+func TestSequenceExhaustion(t *testing.T) {
+	sa, _ := NewSA(1, testKeyMat, false, 0)
+	sa.seq = 1<<32 - 2
+	if _, err := sa.Encapsulate(nil, nil, 59); err != nil {
+		t.Fatalf("Encapsulate of last sequence number failed: %v", err)
+	}
+	if _, err := sa.Encapsulate(nil, nil, 59); !errors.Is(err, ErrSequenceExhausted) {
+		t.Fatalf("Expected ErrSequenceExhausted, got %v", err)
+	}
+}
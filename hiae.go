@@ -316,7 +316,71 @@ func (h *HiAE) finalize(adLenBits, msgLenBits uint64, tag []byte) {
 	}
 }
 
+// absorbPadded absorbs data block by block, zero-padding the final partial block
+func (h *HiAE) absorbPadded(data []byte) {
+	numFullBlocks := len(data) / BlockLen
+	for i := 0; i < numFullBlocks; i++ {
+		start := i * BlockLen
+		h.absorb(data[start : start+BlockLen])
+	}
+
+	remainder := len(data) % BlockLen
+	if remainder > 0 {
+		var paddedBlock [BlockLen]byte
+		copy(paddedBlock[:], data[len(data)-remainder:])
+		h.absorb(paddedBlock[:])
+	}
+}
+
+// encryptBlocks encrypts msg into ctOut, switching to batch processing as soon as the state is aligned
+// Only the last call for a given message may pass a length that is not a multiple of BlockLen
+func (h *HiAE) encryptBlocks(msg, ctOut []byte) {
+	numFullBlocks := len(msg) / BlockLen
+	for i := 0; i < numFullBlocks; {
+		start := i * BlockLen
+		if h.offset == 0 && i+16 <= numFullBlocks {
+			h.batchEncrypt(msg[start:start+16*BlockLen], ctOut[start:start+16*BlockLen])
+			i += 16
+		} else {
+			h.enc(msg[start:start+BlockLen], ctOut[start:start+BlockLen])
+			i++
+		}
+	}
+
+	remainder := len(msg) % BlockLen
+	if remainder > 0 {
+		var paddedBlock [BlockLen]byte
+		copy(paddedBlock[:], msg[len(msg)-remainder:])
+		var ctBlock [BlockLen]byte
+		h.enc(paddedBlock[:], ctBlock[:])
+		copy(ctOut[numFullBlocks*BlockLen:], ctBlock[:remainder])
+	}
+}
+
+// decryptBlocks decrypts ct into msgOut, switching to batch processing as soon as the state is aligned
+// Only the last call for a given ciphertext may pass a length that is not a multiple of BlockLen
+func (h *HiAE) decryptBlocks(ct, msgOut []byte) {
+	numFullBlocks := len(ct) / BlockLen
+	for i := 0; i < numFullBlocks; {
+		start := i * BlockLen
+		if h.offset == 0 && i+16 <= numFullBlocks {
+			h.batchDecrypt(ct[start:start+16*BlockLen], msgOut[start:start+16*BlockLen])
+			i += 16
+		} else {
+			h.dec(ct[start:start+BlockLen], msgOut[start:start+BlockLen])
+			i++
+		}
+	}
+
+	remainder := len(ct) % BlockLen
+	if remainder > 0 {
+		start := numFullBlocks * BlockLen
+		h.decPartial(ct[start:], msgOut[start:start+remainder])
+	}
+}
+
 // EncryptTo encrypts a message with associated data, writing to provided output buffers (zero-allocation)
+// ctOut may alias msg exactly for in-place encryption, but must not otherwise overlap it
 func EncryptTo(msg, ad, key, nonce, ctOut, tagOut []byte) error {
 	if len(key) != KeyLen {
 		return errors.New("key must be 32 bytes")
@@ -465,6 +529,7 @@ func Encrypt(msg, ad, key, nonce []byte) ([]byte, []byte, error) {
 }
 
 // DecryptTo decrypts a ciphertext with associated data and verifies authentication, writing to provided output buffer (zero-allocation)
+// msgOut may alias ct exactly for in-place decryption, but must not otherwise overlap it
 func DecryptTo(ct, tag, ad, key, nonce, msgOut []byte) error {
 	if len(key) != KeyLen {
 		return errors.New("key must be 32 bytes")
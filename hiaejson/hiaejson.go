@@ -0,0 +1,519 @@
+// Package hiaejson encrypts tagged struct fields when encoding to and decoding from JSON
+//
+// Fields tagged hiae:"encrypt" are replaced in the JSON output by a base64 envelope
+//
+//	base64(0x01 || nonce (16) || ciphertext || tag)
+//
+// where the plaintext is the field's own JSON encoding. The associated data is
+// hiae.EncodeADFields("hiaejson/v1", path), path being the RFC 6901 JSON Pointer of the field,
+// such as /users/0/ssn, so an envelope moved to another field, element or map key fails to
+// authenticate. Dropping a field entirely is not detected; authenticate the whole document
+// if that matters.
+//
+// The walk is driven by the static type, the same way for Marshal and Unmarshal. It descends
+// into structs, pointers, slices, arrays and maps, but not into interface values or types with
+// their own JSON methods. An encrypt tag that cannot take effect is an error rather than a
+// silent plaintext fallback: on embedded structs without a json name, on fields excluded,
+// unexported or hidden by another field, inside types with their own JSON methods, and, when
+// marshalling, inside values held in interfaces.
+package hiaejson
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	hiae "github.com/hiae-aead/go-hiae"
+)
+
+const (
+	envelopeVersion = 0x01
+	adLabel         = "hiaejson/v1"
+)
+
+var (
+	// ErrDecrypt is returned, wrapped in a FieldError, for envelopes that fail authentication
+	ErrDecrypt = errors.New("hiaejson: decryption failed")
+	// ErrMalformed is returned, wrapped in a FieldError, for encrypted fields that are not valid envelopes
+	ErrMalformed = errors.New("hiaejson: malformed envelope")
+)
+
+// FieldError reports the encrypted field that could not be processed
+type FieldError struct {
+	Path string // JSON Pointer of the field
+	Err  error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("hiaejson: field %s: %v", e.Path, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// Codec marshals and unmarshals JSON with field encryption under a single key
+type Codec struct {
+	key [hiae.KeyLen]byte
+}
+
+// New returns a Codec using key
+func New(key []byte) (*Codec, error) {
+	if len(key) != hiae.KeyLen {
+		return nil, errors.New("hiaejson: key must be 32 bytes")
+	}
+	c := &Codec{}
+	copy(c.key[:], key)
+	return c, nil
+}
+
+// Marshal returns the JSON encoding of v with tagged fields sealed
+func (c *Codec) Marshal(v any) ([]byte, error) {
+	if err := checkType(reflect.TypeOf(v), map[reflect.Type]bool{}); err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDynamic(reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return c.walk(reflect.TypeOf(v), raw, "", c.seal)
+}
+
+// Unmarshal opens the tagged fields of data and decodes the result into v
+func (c *Codec) Unmarshal(data []byte, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return errors.New("hiaejson: Unmarshal target must be a non-nil pointer")
+	}
+	if err := checkType(rv.Type(), map[reflect.Type]bool{}); err != nil {
+		return err
+	}
+	raw, err := c.walk(rv.Type(), data, "", c.open)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+func ad(path string) []byte {
+	return hiae.EncodeADFields([]byte(adLabel), []byte(path))
+}
+
+// seal replaces the JSON value of an encrypted field with its envelope
+func (c *Codec) seal(path string, raw json.RawMessage) (json.RawMessage, error) {
+	env := make([]byte, 1+hiae.NonceLen+len(raw)+hiae.TagLen)
+	env[0] = envelopeVersion
+	nonce := env[1 : 1+hiae.NonceLen]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	ct := env[1+hiae.NonceLen : len(env)-hiae.TagLen]
+	if err := hiae.EncryptTo(raw, ad(path), c.key[:], nonce, ct, env[len(env)-hiae.TagLen:]); err != nil {
+		return nil, err
+	}
+	return json.Marshal(base64.StdEncoding.EncodeToString(env))
+}
+
+// open replaces an envelope with the JSON value it protects
+func (c *Codec) open(path string, raw json.RawMessage) (json.RawMessage, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, &FieldError{Path: path, Err: ErrMalformed}
+	}
+	env, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(env) < 1+hiae.NonceLen+hiae.TagLen || env[0] != envelopeVersion {
+		return nil, &FieldError{Path: path, Err: ErrMalformed}
+	}
+	ct := env[1+hiae.NonceLen : len(env)-hiae.TagLen]
+	out := make([]byte, len(ct))
+	if err := hiae.DecryptTo(ct, env[len(env)-hiae.TagLen:], ad(path), c.key[:], env[1:1+hiae.NonceLen], out); err != nil {
+		return nil, &FieldError{Path: path, Err: ErrDecrypt}
+	}
+	return out, nil
+}
+
+var (
+	marshalerType       = reflect.TypeFor[json.Marshaler]()
+	unmarshalerType     = reflect.TypeFor[json.Unmarshaler]()
+	textMarshalerType   = reflect.TypeFor[encoding.TextMarshaler]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+)
+
+// opaque reports whether t controls its own JSON encoding
+func opaque(t reflect.Type) bool {
+	for _, it := range []reflect.Type{marshalerType, unmarshalerType, textMarshalerType, textUnmarshalerType} {
+		if t.Implements(it) || reflect.PointerTo(t).Implements(it) {
+			return true
+		}
+	}
+	return false
+}
+
+// pointerToken escapes a JSON Pointer reference token
+func pointerToken(s string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(s)
+}
+
+// walk rewrites the encrypted fields within raw, a JSON value of type t found at path
+func (c *Codec) walk(t reflect.Type, raw json.RawMessage, path string, fn func(string, json.RawMessage) (json.RawMessage, error)) (json.RawMessage, error) {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || opaque(t) || bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		return raw, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		members, err := parseObject(raw)
+		if err != nil {
+			return nil, err
+		}
+		fields, err := structFields(t)
+		if err != nil {
+			return nil, err
+		}
+		for i := range members {
+			f := lookupField(fields, members[i].name)
+			if f == nil {
+				continue
+			}
+			p := path + "/" + pointerToken(f.name)
+			if f.encrypt {
+				members[i].value, err = fn(p, members[i].value)
+			} else {
+				members[i].value, err = c.walk(f.typ, members[i].value, p, fn)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		return encodeObject(members)
+
+	case reflect.Map:
+		members, err := parseObject(raw)
+		if err != nil {
+			return nil, err
+		}
+		for i := range members {
+			p := path + "/" + pointerToken(members[i].name)
+			if members[i].value, err = c.walk(t.Elem(), members[i].value, p, fn); err != nil {
+				return nil, err
+			}
+		}
+		return encodeObject(members)
+
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 && t.Kind() == reflect.Slice {
+			return raw, nil // []byte encodes as a base64 string
+		}
+		var elems []json.RawMessage
+		if err := json.Unmarshal(raw, &elems); err != nil {
+			return nil, err
+		}
+		for i := range elems {
+			var err error
+			if elems[i], err = c.walk(t.Elem(), elems[i], path+"/"+strconv.Itoa(i), fn); err != nil {
+				return nil, err
+			}
+		}
+		return json.Marshal(elems)
+	}
+	return raw, nil
+}
+
+// member is one name/value pair of a JSON object, kept in document order
+type member struct {
+	name  string
+	value json.RawMessage
+}
+
+func parseObject(raw json.RawMessage) ([]member, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, errors.New("hiaejson: expected JSON object")
+	}
+	var members []member
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		m := member{name: tok.(string)}
+		if err := dec.Decode(&m.value); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+func encodeObject(members []member) (json.RawMessage, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, m := range members {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, err := json.Marshal(m.name)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(m.value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// field is a struct field as seen by encoding/json
+type field struct {
+	name    string
+	goName  string
+	typ     reflect.Type
+	encrypt bool
+	tagged  bool
+	depth   int
+}
+
+// structFields lists the JSON fields of t, promoting fields of embedded structs as encoding/json does
+// An encrypt tag on a field encoding/json would not emit is an error, so it never falls back to plaintext.
+func structFields(t reflect.Type) ([]field, error) {
+	var all []field
+	if err := collectFields(t, 0, &all); err != nil {
+		return nil, err
+	}
+
+	// The shallowest field wins; at equal depth a JSON-tagged field wins, otherwise the name is dropped
+	byName := map[string][]field{}
+	var order []string
+	for _, f := range all {
+		if _, ok := byName[f.name]; !ok {
+			order = append(order, f.name)
+		}
+		byName[f.name] = append(byName[f.name], f)
+	}
+	var fields []field
+	for _, name := range order {
+		cands := byName[name]
+		minDepth := cands[0].depth
+		for _, f := range cands {
+			minDepth = min(minDepth, f.depth)
+		}
+		var best []field
+		for _, f := range cands {
+			if f.depth == minDepth {
+				best = append(best, f)
+			}
+		}
+		if len(best) > 1 {
+			var tagged []field
+			for _, f := range best {
+				if f.tagged {
+					tagged = append(tagged, f)
+				}
+			}
+			best = tagged
+		}
+		var chosen *field
+		if len(best) == 1 {
+			chosen = &best[0]
+			fields = append(fields, *chosen)
+		}
+		for _, f := range cands {
+			if f.encrypt && (chosen == nil || f.goName != chosen.goName || f.depth != chosen.depth) {
+				return nil, fmt.Errorf("hiaejson: %s.%s: encrypted field is hidden by another field named %q", t, f.goName, name)
+			}
+		}
+	}
+	return fields, nil
+}
+
+func collectFields(t reflect.Type, depth int, out *[]field) error {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("json")
+		name, _, _ := strings.Cut(tag, ",")
+
+		encrypt := false
+		switch h := sf.Tag.Get("hiae"); h {
+		case "":
+		case "encrypt":
+			encrypt = true
+		default:
+			return fmt.Errorf("hiaejson: %s.%s: unknown hiae tag %q", t, sf.Name, h)
+		}
+
+		if tag == "-" {
+			if encrypt {
+				return fmt.Errorf("hiaejson: %s.%s: encrypted field is excluded from JSON", t, sf.Name)
+			}
+			continue
+		}
+
+		ft := sf.Type
+		if sf.Anonymous && name == "" {
+			et := ft
+			if et.Kind() == reflect.Pointer {
+				et = et.Elem()
+			}
+			if et.Kind() == reflect.Struct {
+				// encoding/json promotes the embedded fields, so there is no key to put an envelope under
+				if encrypt {
+					return fmt.Errorf("hiaejson: %s.%s: encrypted embedded struct needs a json name", t, sf.Name)
+				}
+				if err := collectFields(et, depth+1, out); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if !sf.IsExported() {
+			if encrypt {
+				return fmt.Errorf("hiaejson: %s.%s: encrypted field is unexported", t, sf.Name)
+			}
+			continue
+		}
+		f := field{name: name, goName: sf.Name, typ: ft, encrypt: encrypt, tagged: name != "", depth: depth}
+		if name == "" {
+			f.name = sf.Name
+		}
+		*out = append(*out, f)
+	}
+	return nil
+}
+
+// checkType verifies every struct reachable from t, so misplaced encrypt tags fail regardless of the data
+func checkType(t reflect.Type, seen map[reflect.Type]bool) error {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || seen[t] {
+		return nil
+	}
+	seen[t] = true
+
+	if opaque(t) {
+		if hasEncryptTags(t, map[reflect.Type]bool{}) {
+			return fmt.Errorf("hiaejson: %s has its own JSON methods but contains encrypted fields", t)
+		}
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		fields, err := structFields(t)
+		if err != nil {
+			return err
+		}
+		for _, f := range fields {
+			if f.encrypt {
+				continue // Sealed as a whole
+			}
+			if err := checkType(f.typ, seen); err != nil {
+				return err
+			}
+		}
+	case reflect.Map, reflect.Slice, reflect.Array:
+		return checkType(t.Elem(), seen)
+	}
+	return nil
+}
+
+// hasEncryptTags reports whether any struct reachable from t has a hiae tag
+func hasEncryptTags(t reflect.Type, seen map[reflect.Type]bool) bool {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if seen[t] {
+		return false
+	}
+	seen[t] = true
+
+	switch t.Kind() {
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			if sf.Tag.Get("hiae") != "" || hasEncryptTags(sf.Type, seen) {
+				return true
+			}
+		}
+	case reflect.Map, reflect.Slice, reflect.Array:
+		return hasEncryptTags(t.Elem(), seen)
+	}
+	return false
+}
+
+// checkDynamic rejects interface values holding types with encrypt tags, which the type-driven walk cannot see
+func checkDynamic(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		if hasEncryptTags(v.Elem().Type(), map[reflect.Type]bool{}) {
+			return fmt.Errorf("hiaejson: %s behind an interface contains encrypted fields", v.Elem().Type())
+		}
+		return checkDynamic(v.Elem())
+	case reflect.Pointer:
+		if !v.IsNil() && !opaque(v.Type().Elem()) {
+			return checkDynamic(v.Elem())
+		}
+	case reflect.Struct:
+		if opaque(v.Type()) {
+			return nil
+		}
+		for i := 0; i < v.NumField(); i++ {
+			if err := checkDynamic(v.Field(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if opaque(v.Type().Elem()) {
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := checkDynamic(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if opaque(v.Type().Elem()) {
+			return nil
+		}
+		for it := v.MapRange(); it.Next(); {
+			if err := checkDynamic(it.Value()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// lookupField finds the field for an object member name, preferring an exact match like encoding/json
+func lookupField(fields []field, name string) *field {
+	for i := range fields {
+		if fields[i].name == name {
+			return &fields[i]
+		}
+	}
+	for i := range fields {
+		if strings.EqualFold(fields[i].name, name) {
+			return &fields[i]
+		}
+	}
+	return nil
+}
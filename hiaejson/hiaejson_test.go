@@ -0,0 +1,295 @@
+package hiaejson
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testKey = bytes.Repeat([]byte{0x42}, 32)
+
+type Address struct {
+	Street string `json:"street" hiae:"encrypt"`
+	City   string `json:"city"`
+}
+
+type Audit struct {
+	Note string `json:"note" hiae:"encrypt"`
+}
+
+type Person struct {
+	Audit
+	Name     string             `json:"name"`
+	SSN      string             `json:"ssn" hiae:"encrypt"`
+	Age      int                `json:"age,omitempty" hiae:"encrypt"`
+	Home     *Address           `json:"home,omitempty"`
+	Previous []Address          `json:"previous"`
+	Contacts map[string]Address `json:"contacts"`
+	Secret   *Address           `json:"secret" hiae:"encrypt"`
+	Born     time.Time          `json:"born"`
+	Raw      []byte             `json:"raw"`
+}
+
+func testPerson() Person {
+	return Person{
+		Audit:    Audit{Note: "vip"},
+		Name:     "Ada",
+		SSN:      "078-05-1120",
+		Age:      36,
+		Home:     &Address{Street: "1 Main St", City: "London"},
+		Previous: []Address{{Street: "2 Side St", City: "Paris"}, {Street: "3 Back St", City: "Rome"}},
+		Contacts: map[string]Address{"a/b": {Street: "4 Slash St", City: "Oslo"}},
+		Secret:   &Address{Street: "5 Hidden St", City: "Nowhere"},
+		Born:     time.Date(1815, 12, 10, 0, 0, 0, 0, time.UTC),
+		Raw:      []byte{1, 2, 3},
+	}
+}
+
+func testCodec(t *testing.T) *Codec {
+	t.Helper()
+	c, err := New(testKey)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return c
+}
+
+func TestRoundTrip(t *testing.T) {
+	c := testCodec(t)
+	in := testPerson()
+	data, err := c.Marshal(&in)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	for _, secret := range []string{"078-05-1120", "Main St", "Side St", "Back St", "Slash St", "Hidden", "Nowhere", "vip"} {
+		if bytes.Contains(data, []byte(secret)) {
+			t.Errorf("Output contains %q: %s", secret, data)
+		}
+	}
+	for _, public := range []string{`"name":"Ada"`, `"London"`, `"Paris"`, `"Oslo"`, `"1815-12-10T00:00:00Z"`, `"AQID"`} {
+		if !bytes.Contains(data, []byte(public)) {
+			t.Errorf("Output lacks %s: %s", public, data)
+		}
+	}
+
+	var out Person
+	if err := c.Unmarshal(data, &out); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("Round trip = %+v, want %+v", out, in)
+	}
+}
+
+// TestFieldOrder checks that unencrypted output keeps the encoding/json layout
+func TestFieldOrder(t *testing.T) {
+	type plain struct {
+		Z int `json:"z"`
+		A int `json:"a"`
+	}
+	data, err := testCodec(t).Marshal(plain{1, 2})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if string(data) != `{"z":1,"a":2}` {
+		t.Errorf("Marshal = %s", data)
+	}
+}
+
+func TestTopLevelCollections(t *testing.T) {
+	c := testCodec(t)
+	in := map[string][]Address{"x": {{Street: "6 Top St", City: "Bern"}}}
+	data, err := c.Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if bytes.Contains(data, []byte("Top St")) {
+		t.Errorf("Output contains the street: %s", data)
+	}
+	var out map[string][]Address
+	if err := c.Unmarshal(data, &out); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("Round trip = %+v", out)
+	}
+}
+
+// TestPathBinding checks that envelopes do not authenticate at another path
+func TestPathBinding(t *testing.T) {
+	c := testCodec(t)
+	in := testPerson()
+	data, err := c.Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("json.Unmarshal failed: %v", err)
+	}
+	prev := doc["previous"].([]any)
+	prev[0], prev[1] = prev[1], prev[0]
+	swapped, _ := json.Marshal(doc)
+
+	var out Person
+	err = c.Unmarshal(swapped, &out)
+	var fe *FieldError
+	if !errors.As(err, &fe) || !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("Swapped elements error = %v, want FieldError wrapping ErrDecrypt", err)
+	}
+	if fe.Path != "/previous/0/street" {
+		t.Errorf("FieldError path = %q", fe.Path)
+	}
+	if !strings.Contains(err.Error(), "/previous/0/street") {
+		t.Errorf("Error message %q lacks the path", err)
+	}
+
+	json.Unmarshal(data, &doc)
+	doc["ssn"] = doc["note"]
+	moved, _ := json.Marshal(doc)
+	if err := c.Unmarshal(moved, &out); !errors.As(err, &fe) || fe.Path != "/ssn" || !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Moved envelope error = %v, want ErrDecrypt at /ssn", err)
+	}
+
+	json.Unmarshal(data, &doc)
+	doc["contacts"].(map[string]any)["a~1b"] = doc["contacts"].(map[string]any)["a/b"]
+	delete(doc["contacts"].(map[string]any), "a/b")
+	renamed, _ := json.Marshal(doc)
+	if err := c.Unmarshal(renamed, &out); !errors.As(err, &fe) || fe.Path != "/contacts/a~01b/street" {
+		t.Errorf("Renamed map key error = %v", err)
+	}
+}
+
+func TestMalformedEnvelope(t *testing.T) {
+	c := testCodec(t)
+	var out Person
+	for _, doc := range []string{
+		`{"ssn":null}`,
+		`{"ssn":123}`,
+		`{"ssn":"not base64!"}`,
+		`{"ssn":"AQID"}`,
+	} {
+		var fe *FieldError
+		if err := c.Unmarshal([]byte(doc), &out); !errors.As(err, &fe) || fe.Path != "/ssn" || !errors.Is(err, ErrMalformed) {
+			t.Errorf("Unmarshal(%s) error = %v, want ErrMalformed at /ssn", doc, err)
+		}
+	}
+}
+
+func TestWrongKey(t *testing.T) {
+	data, err := testCodec(t).Marshal(Address{Street: "7 Key St"})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	other, _ := New(bytes.Repeat([]byte{0x43}, 32))
+	var out Address
+	if err := other.Unmarshal(data, &out); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Wrong key error = %v, want ErrDecrypt", err)
+	}
+}
+
+type Secret struct {
+	SSN string
+}
+
+type named struct {
+	Secret `json:"secret" hiae:"encrypt"`
+	Name   string
+}
+
+type opaqueSecret struct {
+	SSN string `hiae:"encrypt"`
+}
+
+func (o opaqueSecret) MarshalJSON() ([]byte, error) { return json.Marshal(o.SSN) }
+
+// TestIneffectiveTags checks that encrypt tags which cannot take effect fail instead of leaking plaintext
+func TestIneffectiveTags(t *testing.T) {
+	c := testCodec(t)
+	type embedded struct {
+		Secret `hiae:"encrypt"`
+		Name   string
+	}
+	type excluded struct {
+		SSN string `json:"-" hiae:"encrypt"`
+	}
+	type unexported struct {
+		ssn string `hiae:"encrypt"`
+	}
+	type Inner struct {
+		SSN string `hiae:"encrypt"`
+	}
+	type shadowed struct {
+		Inner
+		SSN string
+	}
+	type ambiguousEmbed struct {
+		Inner
+		Twin
+	}
+	type hasOpaque struct {
+		Secret []opaqueSecret `json:"secret"`
+	}
+
+	for _, v := range []any{
+		embedded{Secret{"123-45-6789"}, "bob"},
+		excluded{"123-45-6789"},
+		unexported{"123-45-6789"},
+		shadowed{Inner{"1"}, "2"},
+		ambiguousEmbed{Inner{"1"}, Twin{"2"}},
+		hasOpaque{[]opaqueSecret{{"123-45-6789"}}},
+		struct{ Any any }{Any: Address{Street: "8 Any St"}},
+		map[string]any{"a": []any{&Address{Street: "9 Deep St"}}},
+	} {
+		data, err := c.Marshal(v)
+		if err == nil {
+			t.Errorf("Marshal(%T) succeeded: %s", v, data)
+		}
+		switch v.(type) {
+		case struct{ Any any }, map[string]any:
+			continue // Unmarshal decodes interfaces generically, so there is nothing to decrypt
+		}
+		if err := c.Unmarshal([]byte(`{}`), reflect.New(reflect.TypeOf(v)).Interface()); err == nil {
+			t.Errorf("Unmarshal into %T succeeded", v)
+		}
+	}
+
+	// With a json name the embedded struct is a regular field and is sealed as a whole
+	data, err := c.Marshal(named{Secret{"123-45-6789"}, "bob"})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if bytes.Contains(data, []byte("123-45-6789")) {
+		t.Errorf("Output contains the SSN: %s", data)
+	}
+	var out named
+	if err := c.Unmarshal(data, &out); err != nil || out.SSN != "123-45-6789" || out.Name != "bob" {
+		t.Errorf("Unmarshal = %+v, %v", out, err)
+	}
+}
+
+type Twin struct {
+	SSN string
+}
+
+func TestErrors(t *testing.T) {
+	if _, err := New(testKey[:31]); err == nil {
+		t.Error("New accepted a short key")
+	}
+	c := testCodec(t)
+	type badTag struct {
+		X string `hiae:"encrypted"`
+	}
+	if _, err := c.Marshal(badTag{}); err == nil {
+		t.Error("Marshal accepted an unknown hiae tag")
+	}
+	var a Address
+	if err := c.Unmarshal([]byte(`{}`), a); err == nil {
+		t.Error("Unmarshal accepted a non-pointer")
+	}
+}
@@ -0,0 +1,276 @@
+// Package hiaesql provides database/sql column types whose values are sealed with a keyset
+//
+// Every value is bound to its location: the associated data is
+//
+//	hiae.EncodeADFields("hiaesql/v1", table, column, row key)
+//
+// so a ciphertext copied to another row or column fails to authenticate. Because the
+// sql.Scanner interface carries no context, an EncryptedBytes or EncryptedString must be bound
+// to its Column and row key before it is scanned into; Column.EncryptedBytes and
+// Column.EncryptedString return bound values. When the row key is only known after scanning,
+// scan the raw ciphertext into a []byte and call Column.Open instead.
+//
+// Table and column names are interpolated into the SQL issued by Rekey and must be trusted
+// identifiers.
+package hiaesql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strconv"
+
+	hiae "github.com/hiae-aead/go-hiae"
+	"github.com/hiae-aead/go-hiae/keyset"
+)
+
+const adLabel = "hiaesql/v1"
+
+var (
+	// ErrUnbound is returned when a value is stored or scanned without a Column
+	ErrUnbound = errors.New("hiaesql: value is not bound to a column")
+	// ErrDecrypt is returned for ciphertexts that fail authentication, including cells copied from elsewhere
+	ErrDecrypt = errors.New("hiaesql: decryption failed")
+	// ErrRowKey is returned by Rekey for key column values it cannot convert to a row key
+	ErrRowKey = errors.New("hiaesql: unsupported row key type")
+)
+
+// Column identifies an encrypted column and the keyset that protects it
+type Column struct {
+	Keyset *keyset.Keyset
+	Table  string
+	Name   string
+}
+
+// NewColumn returns a Column for table.name protected by ks
+func NewColumn(ks *keyset.Keyset, table, name string) *Column {
+	return &Column{Keyset: ks, Table: table, Name: name}
+}
+
+// ad returns the associated data binding a value to this column and rowKey
+func (c *Column) ad(rowKey string) []byte {
+	return hiae.EncodeADFields([]byte(adLabel), []byte(c.Table), []byte(c.Name), []byte(rowKey))
+}
+
+// Seal encrypts v for the cell at rowKey
+func (c *Column) Seal(rowKey string, v []byte) ([]byte, error) {
+	return c.Keyset.Seal(v, c.ad(rowKey))
+}
+
+// Open decrypts the ciphertext stored in the cell at rowKey
+func (c *Column) Open(rowKey string, sealed []byte) ([]byte, error) {
+	v, err := c.Keyset.Open(sealed, c.ad(rowKey))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return v, nil
+}
+
+// Reseal re-encrypts a cell under the current primary key
+// Cells already sealed with the primary key are returned unchanged and changed is false.
+func (c *Column) Reseal(rowKey string, sealed []byte) (out []byte, changed bool, err error) {
+	if id, ok := keyset.KeyID(sealed); ok && id == c.Keyset.Primary() {
+		// Still authenticate, so Rekey does not silently pass over corrupt cells
+		if _, err := c.Open(rowKey, sealed); err != nil {
+			return nil, false, err
+		}
+		return sealed, false, nil
+	}
+	v, err := c.Open(rowKey, sealed)
+	if err != nil {
+		return nil, false, err
+	}
+	out, err = c.Seal(rowKey, v)
+	if err != nil {
+		return nil, false, err
+	}
+	return out, true, nil
+}
+
+// EncryptedBytes returns a bound EncryptedBytes for the cell at rowKey holding v
+func (c *Column) EncryptedBytes(rowKey string, v []byte) *EncryptedBytes {
+	return &EncryptedBytes{Column: c, RowKey: rowKey, Bytes: v, Valid: v != nil}
+}
+
+// EncryptedString returns a bound EncryptedString for the cell at rowKey holding s
+func (c *Column) EncryptedString(rowKey string, s string) *EncryptedString {
+	return &EncryptedString{Column: c, RowKey: rowKey, String: s, Valid: true}
+}
+
+// EncryptedBytes is a nullable byte slice stored sealed
+type EncryptedBytes struct {
+	Column *Column
+	RowKey string
+	Bytes  []byte
+	Valid  bool // Valid is false for NULL
+}
+
+// Value implements driver.Valuer
+func (b EncryptedBytes) Value() (driver.Value, error) {
+	if !b.Valid {
+		return nil, nil
+	}
+	if b.Column == nil {
+		return nil, ErrUnbound
+	}
+	return b.Column.Seal(b.RowKey, b.Bytes)
+}
+
+// Scan implements sql.Scanner
+func (b *EncryptedBytes) Scan(src any) error {
+	v, valid, err := scan(b.Column, b.RowKey, src)
+	if err != nil {
+		return err
+	}
+	b.Bytes, b.Valid = v, valid
+	return nil
+}
+
+// EncryptedString is a nullable string stored sealed
+type EncryptedString struct {
+	Column *Column
+	RowKey string
+	String string
+	Valid  bool // Valid is false for NULL
+}
+
+// Value implements driver.Valuer
+func (s EncryptedString) Value() (driver.Value, error) {
+	if !s.Valid {
+		return nil, nil
+	}
+	if s.Column == nil {
+		return nil, ErrUnbound
+	}
+	return s.Column.Seal(s.RowKey, []byte(s.String))
+}
+
+// Scan implements sql.Scanner
+func (s *EncryptedString) Scan(src any) error {
+	v, valid, err := scan(s.Column, s.RowKey, src)
+	if err != nil {
+		return err
+	}
+	s.String, s.Valid = string(v), valid
+	return nil
+}
+
+// scan opens a value read from the database
+func scan(c *Column, rowKey string, src any) ([]byte, bool, error) {
+	var sealed []byte
+	switch v := src.(type) {
+	case nil:
+		return nil, false, nil
+	case []byte:
+		sealed = v
+	case string:
+		sealed = []byte(v)
+	default:
+		return nil, false, fmt.Errorf("hiaesql: cannot scan %T", src)
+	}
+	if c == nil {
+		return nil, false, ErrUnbound
+	}
+	v, err := c.Open(rowKey, sealed)
+	if err != nil {
+		return nil, false, err
+	}
+	return v, true, nil
+}
+
+// RowKey converts a key column value, as returned by a driver, to the row key used in the AD
+// Integers are formatted in decimal, so rows keyed by an integer ID should be sealed with
+// strconv.FormatInt(id, 10).
+func RowKey(v any) (string, error) {
+	switch k := v.(type) {
+	case string:
+		return k, nil
+	case []byte:
+		return string(k), nil
+	case int64:
+		return strconv.FormatInt(k, 10), nil
+	}
+	return "", ErrRowKey
+}
+
+// RekeyOptions configures Rekey
+type RekeyOptions struct {
+	// KeyColumn is the column holding the row key
+	KeyColumn string
+	// Placeholder returns the bind parameter for the n-th argument, counting from 1.
+	// It defaults to "?"; PostgreSQL drivers need "$n".
+	Placeholder func(n int) string
+}
+
+// Rekey re-encrypts every cell of the column that is not sealed with the primary key
+// It runs in a single transaction and returns the number of rows updated. Run it after
+// rotating the keyset and before disabling the old key.
+func (c *Column) Rekey(ctx context.Context, db *sql.DB, opts RekeyOptions) (int, error) {
+	if opts.KeyColumn == "" {
+		return 0, errors.New("hiaesql: key column is required")
+	}
+	placeholder := opts.Placeholder
+	if placeholder == nil {
+		placeholder = func(int) string { return "?" }
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	type cell struct {
+		key    any
+		sealed []byte
+	}
+	var cells []cell
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT %s, %s FROM %s", opts.KeyColumn, c.Name, c.Table))
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var ce cell
+		if err := rows.Scan(&ce.key, &ce.sealed); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if ce.sealed != nil {
+			cells = append(cells, ce)
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return 0, err
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	update := fmt.Sprintf("UPDATE %s SET %s = %s WHERE %s = %s",
+		c.Table, c.Name, placeholder(1), opts.KeyColumn, placeholder(2))
+	n := 0
+	for _, ce := range cells {
+		rowKey, err := RowKey(ce.key)
+		if err != nil {
+			return 0, err
+		}
+		out, changed, err := c.Reseal(rowKey, ce.sealed)
+		if err != nil {
+			return 0, fmt.Errorf("hiaesql: row %q: %w", rowKey, err)
+		}
+		if !changed {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, update, out, ce.key); err != nil {
+			return 0, err
+		}
+		n++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,304 @@
+package hiaesql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/hiae-aead/go-hiae/keyset"
+)
+
+// stubDriver is an in-memory database/sql driver understanding just the statements used here:
+//
+//	INSERT INTO t (a, b) VALUES (?, ?)
+//	SELECT a, b FROM t
+//	SELECT b FROM t WHERE a = ?
+//	UPDATE t SET b = ? WHERE a = ?
+type stubDriver struct {
+	mu     sync.Mutex
+	tables map[string][]map[string]driver.Value
+}
+
+var (
+	param    = `(?:\?|\$\d+)`
+	reInsert = regexp.MustCompile(`^INSERT INTO (\w+) \((\w+), (\w+)\) VALUES \(` + param + `, ` + param + `\)$`)
+	reSelect = regexp.MustCompile(`^SELECT (\w+), (\w+) FROM (\w+)$`)
+	reWhere  = regexp.MustCompile(`^SELECT (\w+) FROM (\w+) WHERE (\w+) = ` + param + `$`)
+	reUpdate = regexp.MustCompile(`^UPDATE (\w+) SET (\w+) = ` + param + ` WHERE (\w+) = ` + param + `$`)
+)
+
+func (d *stubDriver) Open(string) (driver.Conn, error) { return &stubConn{d}, nil }
+
+type stubConn struct{ d *stubDriver }
+
+func (c *stubConn) Prepare(query string) (driver.Stmt, error) { return &stubStmt{c.d, query}, nil }
+func (c *stubConn) Close() error                              { return nil }
+func (c *stubConn) Begin() (driver.Tx, error)                 { return c, nil }
+func (c *stubConn) Commit() error                             { return nil }
+func (c *stubConn) Rollback() error                           { return nil }
+
+type stubStmt struct {
+	d     *stubDriver
+	query string
+}
+
+func (s *stubStmt) Close() error  { return nil }
+func (s *stubStmt) NumInput() int { return -1 }
+
+func (s *stubStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	if m := reInsert.FindStringSubmatch(s.query); m != nil {
+		s.d.tables[m[1]] = append(s.d.tables[m[1]], map[string]driver.Value{m[2]: args[0], m[3]: args[1]})
+		return driver.RowsAffected(1), nil
+	}
+	if m := reUpdate.FindStringSubmatch(s.query); m != nil {
+		n := 0
+		for _, row := range s.d.tables[m[1]] {
+			if row[m[3]] == args[1] {
+				row[m[2]] = args[0]
+				n++
+			}
+		}
+		return driver.RowsAffected(n), nil
+	}
+	return nil, fmt.Errorf("stub: unsupported exec %q", s.query)
+}
+
+func (s *stubStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	r := &stubRows{}
+	if m := reSelect.FindStringSubmatch(s.query); m != nil {
+		r.cols = []string{m[1], m[2]}
+		for _, row := range s.d.tables[m[3]] {
+			r.rows = append(r.rows, []driver.Value{row[m[1]], row[m[2]]})
+		}
+		return r, nil
+	}
+	if m := reWhere.FindStringSubmatch(s.query); m != nil {
+		r.cols = []string{m[1]}
+		for _, row := range s.d.tables[m[2]] {
+			if row[m[3]] == args[0] {
+				r.rows = append(r.rows, []driver.Value{row[m[1]]})
+			}
+		}
+		return r, nil
+	}
+	return nil, fmt.Errorf("stub: unsupported query %q", s.query)
+}
+
+type stubRows struct {
+	cols []string
+	rows [][]driver.Value
+}
+
+func (r *stubRows) Columns() []string { return r.cols }
+func (r *stubRows) Close() error      { return nil }
+
+func (r *stubRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func openDB(t *testing.T) *sql.DB {
+	t.Helper()
+	name := "hiaesql-stub-" + t.Name()
+	sql.Register(name, &stubDriver{tables: map[string][]map[string]driver.Value{}})
+	db, err := sql.Open(name, "")
+	if err != nil {
+		t.Fatalf("sql.Open failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func newKeyset(t *testing.T) *keyset.Keyset {
+	t.Helper()
+	ks, err := keyset.New()
+	if err != nil {
+		t.Fatalf("keyset.New failed: %v", err)
+	}
+	if _, err := ks.Generate(); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	return ks
+}
+
+func TestRoundTrip(t *testing.T) {
+	db := openDB(t)
+	col := NewColumn(newKeyset(t), "users", "ssn")
+
+	for i, ssn := range []string{"078-05-1120", ""} {
+		key := strconv.Itoa(i + 1)
+		if _, err := db.Exec("INSERT INTO users (id, ssn) VALUES (?, ?)", key, col.EncryptedString(key, ssn)); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+		got := col.EncryptedString(key, "")
+		if err := db.QueryRow("SELECT ssn FROM users WHERE id = ?", key).Scan(got); err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+		if !got.Valid || got.String != ssn {
+			t.Errorf("Scanned %+v, want %q", got, ssn)
+		}
+	}
+
+	if _, err := db.Exec("INSERT INTO users (id, ssn) VALUES (?, ?)", "3", EncryptedString{}); err != nil {
+		t.Fatalf("Insert NULL failed: %v", err)
+	}
+	got := col.EncryptedString("3", "stale")
+	if err := db.QueryRow("SELECT ssn FROM users WHERE id = ?", "3").Scan(got); err != nil {
+		t.Fatalf("Scan NULL failed: %v", err)
+	}
+	if got.Valid {
+		t.Errorf("NULL scanned as %+v", got)
+	}
+
+	var raw []byte
+	if err := db.QueryRow("SELECT ssn FROM users WHERE id = ?", "1").Scan(&raw); err != nil {
+		t.Fatalf("Scan raw failed: %v", err)
+	}
+	if v, err := col.Open("1", raw); err != nil || string(v) != "078-05-1120" {
+		t.Errorf("Open = %q, %v", v, err)
+	}
+}
+
+func TestBytes(t *testing.T) {
+	col := NewColumn(newKeyset(t), "docs", "body")
+	v, err := col.EncryptedBytes("k", []byte{0, 1, 2}).Value()
+	if err != nil {
+		t.Fatalf("Value failed: %v", err)
+	}
+	got := col.EncryptedBytes("k", nil)
+	if err := got.Scan(v); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if !got.Valid || string(got.Bytes) != "\x00\x01\x02" {
+		t.Errorf("Scanned %+v", got)
+	}
+	if err := got.Scan(string(v.([]byte))); err != nil {
+		t.Errorf("Scan from string failed: %v", err)
+	}
+	if err := got.Scan(int64(1)); err == nil {
+		t.Error("Scan accepted an integer")
+	}
+}
+
+// TestBinding checks that cells do not authenticate in another row, column or table
+func TestBinding(t *testing.T) {
+	ks := newKeyset(t)
+	ssn := NewColumn(ks, "users", "ssn")
+	sealed, err := ssn.Seal("1", []byte("078-05-1120"))
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+
+	for _, tc := range []struct {
+		col *Column
+		key string
+	}{
+		{ssn, "2"},
+		{NewColumn(ks, "users", "phone"), "1"},
+		{NewColumn(ks, "admins", "ssn"), "1"},
+	} {
+		if err := tc.col.EncryptedString(tc.key, "").Scan(sealed); !errors.Is(err, ErrDecrypt) {
+			t.Errorf("Scan into %s.%s row %s: error = %v, want ErrDecrypt", tc.col.Table, tc.col.Name, tc.key, err)
+		}
+	}
+
+	if err := (&EncryptedString{}).Scan(sealed); !errors.Is(err, ErrUnbound) {
+		t.Errorf("Unbound Scan error = %v, want ErrUnbound", err)
+	}
+	if _, err := (EncryptedString{String: "x", Valid: true}).Value(); !errors.Is(err, ErrUnbound) {
+		t.Errorf("Unbound Value error = %v, want ErrUnbound", err)
+	}
+}
+
+func TestCopiedCell(t *testing.T) {
+	db := openDB(t)
+	col := NewColumn(newKeyset(t), "users", "ssn")
+	for _, key := range []string{"1", "2"} {
+		if _, err := db.Exec("INSERT INTO users (id, ssn) VALUES (?, ?)", key, col.EncryptedString(key, "ssn-"+key)); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+
+	var raw []byte
+	if err := db.QueryRow("SELECT ssn FROM users WHERE id = ?", "1").Scan(&raw); err != nil {
+		t.Fatalf("Scan raw failed: %v", err)
+	}
+	if _, err := db.Exec("UPDATE users SET ssn = ? WHERE id = ?", raw, "2"); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	err := db.QueryRow("SELECT ssn FROM users WHERE id = ?", "2").Scan(col.EncryptedString("2", ""))
+	if !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Copied cell error = %v, want ErrDecrypt", err)
+	}
+}
+
+func TestRekey(t *testing.T) {
+	db := openDB(t)
+	ks := newKeyset(t)
+	col := NewColumn(ks, "users", "ssn")
+	for i := int64(1); i <= 3; i++ {
+		key := strconv.FormatInt(i, 10)
+		if _, err := db.Exec("INSERT INTO users (id, ssn) VALUES ($1, $2)", i, col.EncryptedString(key, "ssn-"+key)); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+	if _, err := db.Exec("INSERT INTO users (id, ssn) VALUES ($1, $2)", int64(4), nil); err != nil {
+		t.Fatalf("Insert NULL failed: %v", err)
+	}
+
+	old := ks.Primary()
+	if _, err := ks.Rotate(); err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	opts := RekeyOptions{KeyColumn: "id", Placeholder: func(n int) string { return "$" + strconv.Itoa(n) }}
+	n, err := col.Rekey(context.Background(), db, opts)
+	if err != nil {
+		t.Fatalf("Rekey failed: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("Rekey updated %d rows, want 3", n)
+	}
+	if n, err := col.Rekey(context.Background(), db, opts); err != nil || n != 0 {
+		t.Errorf("Second Rekey = %d, %v, want 0, nil", n, err)
+	}
+
+	if err := ks.Disable(old); err != nil {
+		t.Fatalf("Disable failed: %v", err)
+	}
+	for i := int64(1); i <= 3; i++ {
+		key := strconv.FormatInt(i, 10)
+		got := col.EncryptedString(key, "")
+		if err := db.QueryRow("SELECT ssn FROM users WHERE id = $1", i).Scan(got); err != nil {
+			t.Fatalf("Scan after rekey failed: %v", err)
+		}
+		if got.String != "ssn-"+key {
+			t.Errorf("Row %s = %q", key, got.String)
+		}
+	}
+}
+
+func TestRekeyCorrupt(t *testing.T) {
+	db := openDB(t)
+	col := NewColumn(newKeyset(t), "users", "ssn")
+	if _, err := db.Exec("INSERT INTO users (id, ssn) VALUES (?, ?)", "1", []byte("not a ciphertext")); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	if _, err := col.Rekey(context.Background(), db, RekeyOptions{KeyColumn: "id"}); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Rekey of corrupt cell error = %v, want ErrDecrypt", err)
+	}
+}
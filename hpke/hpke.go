@@ -0,0 +1,384 @@
+// Package hpke implements Hybrid Public Key Encryption (RFC 9180) with HiAE as the AEAD
+//
+// The ciphersuite is fixed to DHKEM(X25519, HKDF-SHA256), HKDF-SHA256 and HiAE. HiAE has no
+// registered HPKE identifier, so AEADHiAE is a private-use value; both parties must agree on it
+// out of band. All four modes are supported, along with multi-message contexts and the
+// secret export interface.
+package hpke
+
+import (
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	hiae "github.com/hiae-aead/go-hiae"
+)
+
+// Algorithm identifiers
+const (
+	KEMX25519HKDFSHA256 uint16 = 0x0020
+	KDFHKDFSHA256       uint16 = 0x0001
+	// AEADHiAE is a private-use AEAD identifier for HiAE, not assigned by IANA
+	AEADHiAE uint16 = 0xfffe
+)
+
+// Mode selects how the sender is authenticated
+type Mode uint8
+
+// HPKE modes
+const (
+	ModeBase    Mode = 0x00
+	ModePSK     Mode = 0x01
+	ModeAuth    Mode = 0x02
+	ModeAuthPSK Mode = 0x03
+)
+
+// Ciphersuite parameters
+const (
+	Nsecret = 32            // Length of the KEM shared secret
+	Nenc    = 32            // Length of an encapsulated key
+	Npk     = 32            // Length of a public key
+	Nsk     = 32            // Length of a private key
+	Nh      = 32            // Output length of the KDF extract step
+	Nk      = hiae.KeyLen   // Length of an AEAD key
+	Nn      = hiae.NonceLen // Length of an AEAD nonce
+	Nt      = hiae.TagLen   // Length of an AEAD tag
+)
+
+var (
+	// ErrOpen is returned for ciphertexts that fail authentication
+	ErrOpen = errors.New("hpke: message authentication failed")
+	// ErrMessageLimit is returned once a context has used every sequence number
+	ErrMessageLimit = errors.New("hpke: message limit reached")
+	// ErrInconsistentPSK is returned when a PSK and its identifier are not both present, or both absent, as the mode requires
+	ErrInconsistentPSK = errors.New("hpke: inconsistent PSK inputs")
+	// ErrExportLength is returned for export lengths above 255*Nh
+	ErrExportLength = errors.New("hpke: export length too large")
+)
+
+var (
+	kemSuiteID  = []byte{'K', 'E', 'M', 0x00, 0x20}
+	hpkeSuiteID = []byte{'H', 'P', 'K', 'E', 0x00, 0x20, 0x00, 0x01, byte(AEADHiAE >> 8), byte(AEADHiAE & 0xff)}
+)
+
+// randReader is the source of ephemeral keys, replaceable in tests
+var randReader io.Reader = rand.Reader
+
+// labeledExtract is LabeledExtract from RFC 9180 Section 4
+func labeledExtract(suiteID, salt []byte, label string, ikm []byte) []byte {
+	labeled := make([]byte, 0, 7+len(suiteID)+len(label)+len(ikm))
+	labeled = append(labeled, "HPKE-v1"...)
+	labeled = append(labeled, suiteID...)
+	labeled = append(labeled, label...)
+	labeled = append(labeled, ikm...)
+	prk, err := hkdf.Extract(sha256.New, labeled, salt)
+	if err != nil {
+		panic("hpke: hkdf extract failed: " + err.Error())
+	}
+	return prk
+}
+
+// labeledExpand is LabeledExpand from RFC 9180 Section 4
+func labeledExpand(suiteID, prk []byte, label string, info []byte, length int) ([]byte, error) {
+	labeled := make([]byte, 2, 9+len(suiteID)+len(label)+len(info))
+	binary.BigEndian.PutUint16(labeled, uint16(length))
+	labeled = append(labeled, "HPKE-v1"...)
+	labeled = append(labeled, suiteID...)
+	labeled = append(labeled, label...)
+	labeled = append(labeled, info...)
+	return hkdf.Expand(sha256.New, prk, string(labeled), length)
+}
+
+// GenerateKeyPair returns a new X25519 key pair
+func GenerateKeyPair(random io.Reader) (*ecdh.PrivateKey, error) {
+	if random == nil {
+		random = rand.Reader
+	}
+	return ecdh.X25519().GenerateKey(random)
+}
+
+// DeriveKeyPair deterministically derives an X25519 key pair from at least Nsk bytes of input keying material
+func DeriveKeyPair(ikm []byte) (*ecdh.PrivateKey, error) {
+	if len(ikm) < Nsk {
+		return nil, errors.New("hpke: input keying material must be at least 32 bytes")
+	}
+	prk := labeledExtract(kemSuiteID, nil, "dkp_prk", ikm)
+	sk, err := labeledExpand(kemSuiteID, prk, "sk", nil, Nsk)
+	if err != nil {
+		return nil, err
+	}
+	return ecdh.X25519().NewPrivateKey(sk)
+}
+
+// extractAndExpand turns DH outputs into the KEM shared secret
+func extractAndExpand(dh, kemContext []byte) ([]byte, error) {
+	prk := labeledExtract(kemSuiteID, nil, "eae_prk", dh)
+	return labeledExpand(kemSuiteID, prk, "shared_secret", kemContext, Nsecret)
+}
+
+// encap runs Encap, or AuthEncap when skS is not nil, with the ephemeral key skE
+func encap(pkR *ecdh.PublicKey, skS, skE *ecdh.PrivateKey) (sharedSecret, enc []byte, err error) {
+	dh, err := skE.ECDH(pkR)
+	if err != nil {
+		return nil, nil, err
+	}
+	enc = skE.PublicKey().Bytes()
+	kemContext := append(append([]byte{}, enc...), pkR.Bytes()...)
+	if skS != nil {
+		dhS, err := skS.ECDH(pkR)
+		if err != nil {
+			return nil, nil, err
+		}
+		dh = append(dh, dhS...)
+		kemContext = append(kemContext, skS.PublicKey().Bytes()...)
+	}
+	sharedSecret, err = extractAndExpand(dh, kemContext)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sharedSecret, enc, nil
+}
+
+// decap runs Decap, or AuthDecap when pkS is not nil
+func decap(enc []byte, skR *ecdh.PrivateKey, pkS *ecdh.PublicKey) ([]byte, error) {
+	pkE, err := ecdh.X25519().NewPublicKey(enc)
+	if err != nil {
+		return nil, err
+	}
+	dh, err := skR.ECDH(pkE)
+	if err != nil {
+		return nil, err
+	}
+	kemContext := append(append([]byte{}, enc...), skR.PublicKey().Bytes()...)
+	if pkS != nil {
+		dhS, err := skR.ECDH(pkS)
+		if err != nil {
+			return nil, err
+		}
+		dh = append(dh, dhS...)
+		kemContext = append(kemContext, pkS.Bytes()...)
+	}
+	return extractAndExpand(dh, kemContext)
+}
+
+// context holds the key schedule output shared by senders and receivers
+type context struct {
+	key            [Nk]byte
+	baseNonce      [Nn]byte
+	exporterSecret []byte
+	seq            uint64
+}
+
+// keySchedule derives a context from the KEM shared secret, following RFC 9180 Section 5.1
+func keySchedule(mode Mode, sharedSecret, info, psk, pskID []byte) (*context, error) {
+	gotPSK, gotID := len(psk) > 0, len(pskID) > 0
+	if gotPSK != gotID {
+		return nil, ErrInconsistentPSK
+	}
+	if needPSK := mode == ModePSK || mode == ModeAuthPSK; gotPSK != needPSK {
+		return nil, ErrInconsistentPSK
+	}
+
+	ksc := []byte{byte(mode)}
+	ksc = append(ksc, labeledExtract(hpkeSuiteID, nil, "psk_id_hash", pskID)...)
+	ksc = append(ksc, labeledExtract(hpkeSuiteID, nil, "info_hash", info)...)
+	secret := labeledExtract(hpkeSuiteID, sharedSecret, "secret", psk)
+
+	c := &context{}
+	key, err := labeledExpand(hpkeSuiteID, secret, "key", ksc, Nk)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := labeledExpand(hpkeSuiteID, secret, "base_nonce", ksc, Nn)
+	if err != nil {
+		return nil, err
+	}
+	if c.exporterSecret, err = labeledExpand(hpkeSuiteID, secret, "exp", ksc, Nh); err != nil {
+		return nil, err
+	}
+	copy(c.key[:], key)
+	copy(c.baseNonce[:], nonce)
+
+	return c, nil
+}
+
+// nextNonce returns the nonce for the current sequence number, base_nonce XOR I2OSP(seq, Nn)
+func (c *context) nextNonce() ([Nn]byte, error) {
+	if c.seq == ^uint64(0) {
+		return [Nn]byte{}, ErrMessageLimit
+	}
+	nonce := c.baseNonce
+	var seq [8]byte
+	binary.BigEndian.PutUint64(seq[:], c.seq)
+	for i := range seq {
+		nonce[Nn-8+i] ^= seq[i]
+	}
+	return nonce, nil
+}
+
+// Export derives length bytes of secret material bound to exporterContext
+func (c *context) Export(exporterContext []byte, length int) ([]byte, error) {
+	if length > 255*Nh {
+		return nil, ErrExportLength
+	}
+	return labeledExpand(hpkeSuiteID, c.exporterSecret, "sec", exporterContext, length)
+}
+
+// Sender encrypts a sequence of messages to one recipient
+type Sender struct {
+	context
+}
+
+// Receiver decrypts a sequence of messages from one sender, in order
+type Receiver struct {
+	context
+}
+
+// Seal encrypts plaintext with the next sequence number and returns ciphertext || tag
+func (s *Sender) Seal(aad, plaintext []byte) ([]byte, error) {
+	nonce, err := s.nextNonce()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(plaintext)+Nt)
+	if err := hiae.EncryptTo(plaintext, aad, s.key[:], nonce[:], out[:len(plaintext)], out[len(plaintext):]); err != nil {
+		return nil, err
+	}
+	s.seq++
+	return out, nil
+}
+
+// Open decrypts ciphertext || tag with the next sequence number
+// The sequence number only advances when authentication succeeds.
+func (r *Receiver) Open(aad, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < Nt {
+		return nil, ErrOpen
+	}
+	nonce, err := r.nextNonce()
+	if err != nil {
+		return nil, err
+	}
+	n := len(ciphertext) - Nt
+	out := make([]byte, n)
+	if err := hiae.DecryptTo(ciphertext[:n], ciphertext[n:], aad, r.key[:], nonce[:], out); err != nil {
+		return nil, ErrOpen
+	}
+	r.seq++
+	return out, nil
+}
+
+// setupSender runs the sender side of any mode with an explicit ephemeral key
+func setupSender(mode Mode, pkR *ecdh.PublicKey, info, psk, pskID []byte, skS, skE *ecdh.PrivateKey) ([]byte, *Sender, error) {
+	if pkR == nil {
+		return nil, nil, errors.New("hpke: missing recipient public key")
+	}
+	if skE == nil {
+		var err error
+		if skE, err = GenerateKeyPair(randReader); err != nil {
+			return nil, nil, err
+		}
+	}
+	sharedSecret, enc, err := encap(pkR, skS, skE)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := keySchedule(mode, sharedSecret, info, psk, pskID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return enc, &Sender{*c}, nil
+}
+
+// setupReceiver runs the receiver side of any mode
+func setupReceiver(mode Mode, enc []byte, skR *ecdh.PrivateKey, info, psk, pskID []byte, pkS *ecdh.PublicKey) (*Receiver, error) {
+	if skR == nil {
+		return nil, errors.New("hpke: missing recipient private key")
+	}
+	sharedSecret, err := decap(enc, skR, pkS)
+	if err != nil {
+		return nil, err
+	}
+	c, err := keySchedule(mode, sharedSecret, info, psk, pskID)
+	if err != nil {
+		return nil, err
+	}
+	return &Receiver{*c}, nil
+}
+
+// SetupBaseSender starts a Base mode context to pkR, returning the encapsulated key to send along
+func SetupBaseSender(pkR *ecdh.PublicKey, info []byte) ([]byte, *Sender, error) {
+	return setupSender(ModeBase, pkR, info, nil, nil, nil, nil)
+}
+
+// SetupBaseReceiver starts a Base mode context from an encapsulated key
+func SetupBaseReceiver(enc []byte, skR *ecdh.PrivateKey, info []byte) (*Receiver, error) {
+	return setupReceiver(ModeBase, enc, skR, info, nil, nil, nil)
+}
+
+// SetupPSKSender starts a PSK mode context, authenticating the sender by knowledge of psk
+func SetupPSKSender(pkR *ecdh.PublicKey, info, psk, pskID []byte) ([]byte, *Sender, error) {
+	return setupSender(ModePSK, pkR, info, psk, pskID, nil, nil)
+}
+
+// SetupPSKReceiver starts a PSK mode context from an encapsulated key
+func SetupPSKReceiver(enc []byte, skR *ecdh.PrivateKey, info, psk, pskID []byte) (*Receiver, error) {
+	return setupReceiver(ModePSK, enc, skR, info, psk, pskID, nil)
+}
+
+// SetupAuthSender starts an Auth mode context, authenticating the sender by its static key skS
+func SetupAuthSender(pkR *ecdh.PublicKey, info []byte, skS *ecdh.PrivateKey) ([]byte, *Sender, error) {
+	if skS == nil {
+		return nil, nil, errors.New("hpke: missing sender private key")
+	}
+	return setupSender(ModeAuth, pkR, info, nil, nil, skS, nil)
+}
+
+// SetupAuthReceiver starts an Auth mode context, verifying the sender's static key pkS
+func SetupAuthReceiver(enc []byte, skR *ecdh.PrivateKey, info []byte, pkS *ecdh.PublicKey) (*Receiver, error) {
+	if pkS == nil {
+		return nil, errors.New("hpke: missing sender public key")
+	}
+	return setupReceiver(ModeAuth, enc, skR, info, nil, nil, pkS)
+}
+
+// SetupAuthPSKSender starts an AuthPSK mode context, combining the Auth and PSK modes
+func SetupAuthPSKSender(pkR *ecdh.PublicKey, info, psk, pskID []byte, skS *ecdh.PrivateKey) ([]byte, *Sender, error) {
+	if skS == nil {
+		return nil, nil, errors.New("hpke: missing sender private key")
+	}
+	return setupSender(ModeAuthPSK, pkR, info, psk, pskID, skS, nil)
+}
+
+// SetupAuthPSKReceiver starts an AuthPSK mode context from an encapsulated key
+func SetupAuthPSKReceiver(enc []byte, skR *ecdh.PrivateKey, info, psk, pskID []byte, pkS *ecdh.PublicKey) (*Receiver, error) {
+	if pkS == nil {
+		return nil, errors.New("hpke: missing sender public key")
+	}
+	return setupReceiver(ModeAuthPSK, enc, skR, info, psk, pskID, pkS)
+}
+
+// Seal encrypts a single message to pkR in Base mode, returning the encapsulated key and ciphertext
+func Seal(pkR *ecdh.PublicKey, info, aad, plaintext []byte) (enc, ciphertext []byte, err error) {
+	enc, s, err := SetupBaseSender(pkR, info)
+	if err != nil {
+		return nil, nil, err
+	}
+	ciphertext, err = s.Seal(aad, plaintext)
+	if err != nil {
+		return nil, nil, err
+	}
+	return enc, ciphertext, nil
+}
+
+// Open decrypts a single Base mode message produced by Seal
+func Open(skR *ecdh.PrivateKey, enc, info, aad, ciphertext []byte) ([]byte, error) {
+	r, err := SetupBaseReceiver(enc, skR, info)
+	if err != nil {
+		return nil, err
+	}
+	return r.Open(aad, ciphertext)
+}
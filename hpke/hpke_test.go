@@ -0,0 +1,270 @@
+package hpke
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic("invalid hex string: " + s)
+	}
+	return b
+}
+
+func mustDerive(ikm string) *ecdh.PrivateKey {
+	k, err := DeriveKeyPair(mustHex(ikm))
+	if err != nil {
+		panic(err)
+	}
+	return k
+}
+
+// TestDHKEMVector checks key derivation and encapsulation against RFC 9180 Appendix A.1.1
+// The KEM is independent of the AEAD, so its outputs match the published Base mode vector.
+func TestDHKEMVector(t *testing.T) {
+	skE := mustDerive("7268600d403fce431561aef583ee1613527cff655c1343f29812e66706df3234")
+	skR := mustDerive("6db9df30aa07dd42ee5e8181afdb977e538f5e1fec8a06223f33f7013e525037")
+
+	if got := hex.EncodeToString(skE.Bytes()); got != "52c4a758a802cd8b936eceea314432798d5baf2d7e9235dc084ab1b9cfa2f736" {
+		t.Errorf("Unexpected skEm %s", got)
+	}
+	if got := hex.EncodeToString(skE.PublicKey().Bytes()); got != "37fda3567bdbd628e88668c3c8d7e97d1d1253b6d4ea6d44c150f741f1bf4431" {
+		t.Errorf("Unexpected pkEm %s", got)
+	}
+	if got := hex.EncodeToString(skR.Bytes()); got != "4612c550263fc8ad58375df3f557aac531d26850903e55a9f23f21d8534e8ac8" {
+		t.Errorf("Unexpected skRm %s", got)
+	}
+
+	ss, enc, err := encap(skR.PublicKey(), nil, skE)
+	if err != nil {
+		t.Fatalf("encap failed: %v", err)
+	}
+	if got := hex.EncodeToString(ss); got != "fe0e18c9f024ce43799ae393c7e8fe8fce9d218875e8227b0187c04e7d2ea1fc" {
+		t.Errorf("Unexpected shared secret %s", got)
+	}
+	ss2, err := decap(enc, skR, nil)
+	if err != nil || !bytes.Equal(ss, ss2) {
+		t.Errorf("decap mismatch: %v", err)
+	}
+}
+
+// TestModes runs every mode with several messages and checks the exporter agrees
+func TestModes(t *testing.T) {
+	skR, _ := GenerateKeyPair(nil)
+	skS, _ := GenerateKeyPair(nil)
+	pkR, pkS := skR.PublicKey(), skS.PublicKey()
+	info := []byte("mode test")
+	psk := bytes.Repeat([]byte{0x5c}, 32)
+	pskID := []byte("psk id")
+
+	type setup func() ([]byte, *Sender, *Receiver, error)
+	modes := []struct {
+		name  string
+		setup setup
+	}{
+		{"Base", func() ([]byte, *Sender, *Receiver, error) {
+			enc, s, err := SetupBaseSender(pkR, info)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			r, err := SetupBaseReceiver(enc, skR, info)
+			return enc, s, r, err
+		}},
+		{"PSK", func() ([]byte, *Sender, *Receiver, error) {
+			enc, s, err := SetupPSKSender(pkR, info, psk, pskID)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			r, err := SetupPSKReceiver(enc, skR, info, psk, pskID)
+			return enc, s, r, err
+		}},
+		{"Auth", func() ([]byte, *Sender, *Receiver, error) {
+			enc, s, err := SetupAuthSender(pkR, info, skS)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			r, err := SetupAuthReceiver(enc, skR, info, pkS)
+			return enc, s, r, err
+		}},
+		{"AuthPSK", func() ([]byte, *Sender, *Receiver, error) {
+			enc, s, err := SetupAuthPSKSender(pkR, info, psk, pskID, skS)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			r, err := SetupAuthPSKReceiver(enc, skR, info, psk, pskID, pkS)
+			return enc, s, r, err
+		}},
+	}
+
+	for _, m := range modes {
+		enc, s, r, err := m.setup()
+		if err != nil {
+			t.Fatalf("%s: setup failed: %v", m.name, err)
+		}
+		if len(enc) != Nenc {
+			t.Errorf("%s: unexpected enc length %d", m.name, len(enc))
+		}
+		for i := 0; i < 4; i++ {
+			msg := bytes.Repeat([]byte{byte(i)}, 100*i)
+			aad := []byte{byte(i)}
+			ct, err := s.Seal(aad, msg)
+			if err != nil {
+				t.Fatalf("%s: Seal failed: %v", m.name, err)
+			}
+			pt, err := r.Open(aad, ct)
+			if err != nil || !bytes.Equal(pt, msg) {
+				t.Fatalf("%s: message %d did not round trip: %v", m.name, i, err)
+			}
+		}
+
+		e1, err := s.Export([]byte("exporter"), 64)
+		if err != nil {
+			t.Fatalf("%s: Export failed: %v", m.name, err)
+		}
+		e2, _ := r.Export([]byte("exporter"), 64)
+		if !bytes.Equal(e1, e2) {
+			t.Errorf("%s: exported secrets differ", m.name)
+		}
+	}
+}
+
+// TestVector pins a Base mode and an AuthPSK mode encryption for fixed keys
+func TestVector(t *testing.T) {
+	skE := mustDerive("7268600d403fce431561aef583ee1613527cff655c1343f29812e66706df3234")
+	skR := mustDerive("6db9df30aa07dd42ee5e8181afdb977e538f5e1fec8a06223f33f7013e525037")
+	skS := mustDerive("94b020ce91d73fca4649006c7e7329a67b40c55e9e93cc907d282bbbff386f58")
+	info := mustHex("4f6465206f6e2061204772656369616e2055726e")
+	psk := mustHex("0247fd33b913760fa1fa51e1892d9f307fbe65eb171e8132c2af18555a738b82")
+	pskID := mustHex("456e6e796e20447572696e206172616e204d6f726961")
+	pt := mustHex("4265617574792069732074727574682c20747275746820626561757479")
+	aad := mustHex("436f756e742d30")
+
+	vectors := []struct {
+		mode     Mode
+		skS      *ecdh.PrivateKey
+		psk      []byte
+		pskID    []byte
+		ct       string
+		exported string
+	}{
+		{ModeBase, nil, nil, nil,
+			"7245dfe665355de1262375f7a0b8111d216dfba003f58aeb0ea19dbb0d24cc81d8bf13b9ef19a320e62ea64e75",
+			"ea768a375f771c30dec952c04ef2fb0fd34e62be3977f901957ad51b9e1ed572"},
+		{ModeAuthPSK, skS, psk, pskID,
+			"cb5425c55897523f60091dc0d5d84f6783977f18a33c5b06c320a088f620007f4ba521ccc0a32c2b60aea62708",
+			"f5b3a4ff795949bbb5e543a66dea3f94c08440ae4236fe0b0d4b282374c967b2"},
+	}
+	for _, tv := range vectors {
+		enc, s, err := setupSender(tv.mode, skR.PublicKey(), info, tv.psk, tv.pskID, tv.skS, skE)
+		if err != nil {
+			t.Fatalf("Mode %d: setupSender failed: %v", tv.mode, err)
+		}
+		ct, _ := s.Seal(aad, pt)
+		if got := hex.EncodeToString(ct); got != tv.ct {
+			t.Errorf("Mode %d: expected ciphertext %s, got %s", tv.mode, tv.ct, got)
+		}
+		exported, _ := s.Export([]byte("TestContext"), 32)
+		if got := hex.EncodeToString(exported); got != tv.exported {
+			t.Errorf("Mode %d: expected exported secret %s, got %s", tv.mode, tv.exported, got)
+		}
+
+		var pkS *ecdh.PublicKey
+		if tv.skS != nil {
+			pkS = tv.skS.PublicKey()
+		}
+		r, err := setupReceiver(tv.mode, enc, skR, info, tv.psk, tv.pskID, pkS)
+		if err != nil {
+			t.Fatalf("Mode %d: setupReceiver failed: %v", tv.mode, err)
+		}
+		if got, err := r.Open(aad, ct); err != nil || !bytes.Equal(got, pt) {
+			t.Errorf("Mode %d: Open failed: %v", tv.mode, err)
+		}
+	}
+}
+
+// TestSingleShot checks Seal and Open and their rejection of modified inputs
+func TestSingleShot(t *testing.T) {
+	skR, _ := GenerateKeyPair(nil)
+	msg := []byte("single shot")
+	enc, ct, err := Seal(skR.PublicKey(), []byte("info"), []byte("aad"), msg)
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+	pt, err := Open(skR, enc, []byte("info"), []byte("aad"), ct)
+	if err != nil || !bytes.Equal(pt, msg) {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	if _, err := Open(skR, enc, []byte("other"), []byte("aad"), ct); !errors.Is(err, ErrOpen) {
+		t.Errorf("Expected ErrOpen for wrong info, got %v", err)
+	}
+	if _, err := Open(skR, enc, []byte("info"), []byte("other"), ct); !errors.Is(err, ErrOpen) {
+		t.Errorf("Expected ErrOpen for wrong aad, got %v", err)
+	}
+	ct[0] ^= 1
+	if _, err := Open(skR, enc, []byte("info"), []byte("aad"), ct); !errors.Is(err, ErrOpen) {
+		t.Errorf("Expected ErrOpen for modified ciphertext, got %v", err)
+	}
+	if _, err := Open(skR, enc, []byte("info"), []byte("aad"), ct[:Nt-1]); !errors.Is(err, ErrOpen) {
+		t.Errorf("Expected ErrOpen for short ciphertext, got %v", err)
+	}
+}
+
+// TestAuthentication checks that wrong sender keys and PSKs are rejected
+func TestAuthentication(t *testing.T) {
+	skR, _ := GenerateKeyPair(nil)
+	skS, _ := GenerateKeyPair(nil)
+	other, _ := GenerateKeyPair(nil)
+	psk := bytes.Repeat([]byte{1}, 32)
+
+	enc, s, _ := SetupAuthSender(skR.PublicKey(), nil, skS)
+	ct, _ := s.Seal(nil, []byte("from S"))
+	r, _ := SetupAuthReceiver(enc, skR, nil, other.PublicKey())
+	if _, err := r.Open(nil, ct); !errors.Is(err, ErrOpen) {
+		t.Errorf("Expected ErrOpen for wrong sender key, got %v", err)
+	}
+
+	enc, s, _ = SetupPSKSender(skR.PublicKey(), nil, psk, []byte("id"))
+	ct, _ = s.Seal(nil, []byte("with psk"))
+	r, _ = SetupPSKReceiver(enc, skR, nil, bytes.Repeat([]byte{2}, 32), []byte("id"))
+	if _, err := r.Open(nil, ct); !errors.Is(err, ErrOpen) {
+		t.Errorf("Expected ErrOpen for wrong PSK, got %v", err)
+	}
+
+	if _, _, err := SetupPSKSender(skR.PublicKey(), nil, psk, nil); !errors.Is(err, ErrInconsistentPSK) {
+		t.Errorf("Expected ErrInconsistentPSK for missing PSK ID, got %v", err)
+	}
+	if _, _, err := SetupPSKSender(skR.PublicKey(), nil, nil, nil); !errors.Is(err, ErrInconsistentPSK) {
+		t.Errorf("Expected ErrInconsistentPSK for PSK mode without PSK, got %v", err)
+	}
+
+	// Messages must be opened in order
+	enc, s, _ = SetupBaseSender(skR.PublicKey(), nil)
+	s.Seal(nil, []byte("first"))
+	second, _ := s.Seal(nil, []byte("second"))
+	r, _ = SetupBaseReceiver(enc, skR, nil)
+	if _, err := r.Open(nil, second); !errors.Is(err, ErrOpen) {
+		t.Errorf("Expected ErrOpen for out of order message, got %v", err)
+	}
+}
+
+// TestLimits checks the sequence number and export length limits
+func TestLimits(t *testing.T) {
+	skR, _ := GenerateKeyPair(nil)
+	_, s, _ := SetupBaseSender(skR.PublicKey(), nil)
+	s.seq = ^uint64(0)
+	if _, err := s.Seal(nil, nil); !errors.Is(err, ErrMessageLimit) {
+		t.Errorf("Expected ErrMessageLimit, got %v", err)
+	}
+	if _, err := s.Export(nil, 255*Nh+1); !errors.Is(err, ErrExportLength) {
+		t.Errorf("Expected ErrExportLength, got %v", err)
+	}
+	if _, err := DeriveKeyPair(make([]byte, Nsk-1)); err == nil {
+		t.Error("Expected error for short input keying material")
+	}
+}
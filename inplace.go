@@ -0,0 +1,54 @@
+package hiae
+
+import "errors"
+
+// SealInPlace encrypts a frame held in a single buffer.
+//
+// The buffer layout is
+//
+//	buf[:hdrLen]                                      header, authenticated as associated data
+//	buf[hdrLen : hdrLen+payloadLen]                   payload, encrypted in place
+//	buf[hdrLen+payloadLen : hdrLen+payloadLen+TagLen] tailroom, receives the tag
+//
+// Headroom reserved for a nonce is part of the header, so a nonce stored there is authenticated too.
+// It returns the sealed frame, buf[:hdrLen+payloadLen+TagLen].
+func SealInPlace(buf []byte, hdrLen, payloadLen int, key, nonce []byte) ([]byte, error) {
+	if hdrLen < 0 || payloadLen < 0 {
+		return nil, errors.New("header and payload lengths must not be negative")
+	}
+	if hdrLen > len(buf) || payloadLen > len(buf)-hdrLen-TagLen {
+		return nil, errors.New("buffer too small for header, payload and tag")
+	}
+
+	hdr := buf[:hdrLen]
+	payload := buf[hdrLen : hdrLen+payloadLen]
+	tag := buf[hdrLen+payloadLen : hdrLen+payloadLen+TagLen]
+	if err := EncryptTo(payload, hdr, key, nonce, payload, tag); err != nil {
+		return nil, err
+	}
+
+	return buf[:hdrLen+payloadLen+TagLen], nil
+}
+
+// OpenInPlace verifies and decrypts a frame sealed by SealInPlace.
+//
+// payloadLen is the length of the encrypted payload, which must be followed by the tag.
+// It returns the decrypted payload, buf[hdrLen:hdrLen+payloadLen].
+// On authentication failure the payload region is wiped.
+func OpenInPlace(buf []byte, hdrLen, payloadLen int, key, nonce []byte) ([]byte, error) {
+	if hdrLen < 0 || payloadLen < 0 {
+		return nil, errors.New("header and payload lengths must not be negative")
+	}
+	if hdrLen > len(buf) || payloadLen > len(buf)-hdrLen-TagLen {
+		return nil, errors.New("buffer too small for header, payload and tag")
+	}
+
+	hdr := buf[:hdrLen]
+	payload := buf[hdrLen : hdrLen+payloadLen]
+	tag := buf[hdrLen+payloadLen : hdrLen+payloadLen+TagLen]
+	if err := DecryptTo(payload, tag, hdr, key, nonce, payload); err != nil {
+		return nil, err
+	}
+
+	return payload, nil
+}
@@ -0,0 +1,101 @@
+package hiae
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+// TestEncryptToAliasing verifies that in-place EncryptTo/DecryptTo match out-of-place operation
+// Sizes of 256 bytes and more exercise the batch functions, including the assembly ones when available
+func TestEncryptToAliasing(t *testing.T) {
+	key := hexDecode("6c8f2d5a9e3b7f4c1d8a5e9f3c7b2d6a4f8e1c9b5d3a7e2f4c8b6d9a1e5f3c7d")
+	nonce := hexDecode("9a5c7e3f1b8d4a6c2e9f5b7d3a8c1e6f")
+	ad := []byte("aliasing")
+
+	for _, size := range []int{0, 1, 16, 17, 255, 256, 257, 512, 1000} {
+		msg := make([]byte, size)
+		for i := range msg {
+			msg[i] = byte(i * 3)
+		}
+
+		expectedCt, expectedTag, err := Encrypt(msg, ad, key, nonce)
+		if err != nil {
+			t.Fatalf("size %d: Encrypt failed: %v", size, err)
+		}
+
+		buf := append([]byte{}, msg...)
+		tag := make([]byte, TagLen)
+		if err := EncryptTo(buf, ad, key, nonce, buf, tag); err != nil {
+			t.Fatalf("size %d: in-place EncryptTo failed: %v", size, err)
+		}
+		if !bytes.Equal(buf, expectedCt) || !bytes.Equal(tag, expectedTag) {
+			t.Fatalf("size %d: in-place encryption differs from out-of-place", size)
+		}
+
+		if err := DecryptTo(buf, tag, ad, key, nonce, buf); err != nil {
+			t.Fatalf("size %d: in-place DecryptTo failed: %v", size, err)
+		}
+		if !bytes.Equal(buf, msg) {
+			t.Fatalf("size %d: in-place decryption mismatch", size)
+		}
+	}
+}
+
+// TestSealOpenInPlace tests frame sealing with headroom and tailroom
+func TestSealOpenInPlace(t *testing.T) {
+	key := make([]byte, KeyLen)
+	nonce := hexDecode("a5b8c2d9e3f4a7b1c8d5e9f2a3b6c7d8")
+	hdr := append(append([]byte{}, nonce...), 0x01, 0x02, 0x03, 0x04)
+	payload := bytes.Repeat([]byte("payload-"), 40)
+
+	buf := make([]byte, len(hdr)+len(payload)+TagLen+8)
+	copy(buf, hdr)
+	copy(buf[len(hdr):], payload)
+
+	frame, err := SealInPlace(buf, len(hdr), len(payload), key, nonce)
+	if err != nil {
+		t.Fatalf("SealInPlace failed: %v", err)
+	}
+	if len(frame) != len(hdr)+len(payload)+TagLen {
+		t.Fatalf("Unexpected frame length %d", len(frame))
+	}
+
+	expectedCt, expectedTag, _ := Encrypt(payload, hdr, key, nonce)
+	if !bytes.Equal(frame[len(hdr):len(hdr)+len(payload)], expectedCt) ||
+		!bytes.Equal(frame[len(hdr)+len(payload):], expectedTag) {
+		t.Fatal("SealInPlace output differs from Encrypt")
+	}
+
+	opened, err := OpenInPlace(frame, len(hdr), len(payload), key, nonce)
+	if err != nil {
+		t.Fatalf("OpenInPlace failed: %v", err)
+	}
+	if !bytes.Equal(opened, payload) {
+		t.Fatal("OpenInPlace payload mismatch")
+	}
+
+	// Tampering with the header must be detected
+	frame, _ = SealInPlace(frame, len(hdr), len(payload), key, nonce)
+	frame[len(hdr)-1] ^= 0x80
+	if _, err := OpenInPlace(frame, len(hdr), len(payload), key, nonce); err == nil {
+		t.Fatal("OpenInPlace should have failed with tampered header")
+	}
+	if !bytes.Equal(frame[len(hdr):len(hdr)+len(payload)], make([]byte, len(payload))) {
+		t.Error("Payload was not wiped after authentication failure")
+	}
+
+	if _, err := SealInPlace(make([]byte, 20), 4, 8, key, nonce); err == nil {
+		t.Error("Expected error for missing tailroom")
+	}
+
+	// Lengths whose sum overflows must be rejected rather than panic
+	for _, l := range [][2]int{{math.MaxInt, 8}, {4, math.MaxInt}, {math.MaxInt - 10, math.MaxInt - 10}, {-1, 8}, {4, -1}, {32, 0}} {
+		if _, err := SealInPlace(make([]byte, 32), l[0], l[1], key, nonce); err == nil {
+			t.Errorf("SealInPlace accepted lengths %d, %d", l[0], l[1])
+		}
+		if _, err := OpenInPlace(make([]byte, 32), l[0], l[1], key, nonce); err == nil {
+			t.Errorf("OpenInPlace accepted lengths %d, %d", l[0], l[1])
+		}
+	}
+}
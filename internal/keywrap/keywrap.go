@@ -0,0 +1,88 @@
+// Package keywrap implements AES Key Wrap (RFC 3394) with a 256-bit key-encryption key
+//
+// It is shared by the JOSE A256KW and COSE A256KW key management algorithms.
+package keywrap
+
+import (
+	"crypto/aes"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+)
+
+// ErrUnwrap is returned when a wrapped key fails the integrity check
+var ErrUnwrap = errors.New("keywrap: integrity check failed")
+
+var keyWrapIV = []byte{0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6}
+
+// Wrap wraps a key whose length is a multiple of 8 bytes and at least 16
+func Wrap(kek, key []byte) ([]byte, error) {
+	if len(kek) != 32 {
+		return nil, errors.New("keywrap: key-encryption key must be 32 bytes")
+	}
+	if len(key) < 16 || len(key)%8 != 0 {
+		return nil, errors.New("keywrap: key must be a multiple of 8 bytes and at least 16")
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(key) / 8
+	out := make([]byte, 8+len(key))
+	copy(out, keyWrapIV)
+	copy(out[8:], key)
+
+	var b [16]byte
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			copy(b[:8], out[:8])
+			copy(b[8:], out[8*i:8*i+8])
+			block.Encrypt(b[:], b[:])
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(out[:8], binary.BigEndian.Uint64(b[:8])^t)
+			copy(out[8*i:], b[8:])
+		}
+	}
+
+	return out, nil
+}
+
+// Unwrap reverses Wrap and checks the integrity value
+func Unwrap(kek, wrapped []byte) ([]byte, error) {
+	if len(kek) != 32 {
+		return nil, errors.New("keywrap: key-encryption key must be 32 bytes")
+	}
+	if len(wrapped) < 24 || len(wrapped)%8 != 0 {
+		return nil, ErrUnwrap
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(wrapped)/8 - 1
+	a := binary.BigEndian.Uint64(wrapped[:8])
+	r := make([]byte, 8*n)
+	copy(r, wrapped[8:])
+
+	var b [16]byte
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(b[:8], a^t)
+			copy(b[8:], r[8*(i-1):8*i])
+			block.Decrypt(b[:], b[:])
+			a = binary.BigEndian.Uint64(b[:8])
+			copy(r[8*(i-1):], b[8:])
+		}
+	}
+
+	var check [8]byte
+	binary.BigEndian.PutUint64(check[:], a)
+	if subtle.ConstantTimeCompare(check[:], keyWrapIV) != 1 {
+		clear(r)
+		return nil, ErrUnwrap
+	}
+	return r, nil
+}
@@ -0,0 +1,39 @@
+package keywrap
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic("invalid hex string: " + s)
+	}
+	return b
+}
+
+// TestKeyWrapVector checks Wrap and Unwrap against RFC 3394 Section 4.6
+func TestKeyWrapVector(t *testing.T) {
+	kek := mustHex("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	key := mustHex("00112233445566778899aabbccddeeff000102030405060708090a0b0c0d0e0f")
+	expected := "28c9f404c4b810f4cbccb35cfb87f8263f5786e2d80ed326cbc7f0e71a99f43bfb988b9b7a02dd21"
+
+	wrapped, err := Wrap(kek, key)
+	if err != nil {
+		t.Fatalf("Wrap failed: %v", err)
+	}
+	if hex.EncodeToString(wrapped) != expected {
+		t.Fatalf("Expected %s, got %x", expected, wrapped)
+	}
+	unwrapped, err := Unwrap(kek, wrapped)
+	if err != nil || !bytes.Equal(unwrapped, key) {
+		t.Fatalf("Unwrap failed: %v", err)
+	}
+	wrapped[3] ^= 1
+	if _, err := Unwrap(kek, wrapped); !errors.Is(err, ErrUnwrap) {
+		t.Errorf("Expected ErrUnwrap for modified wrapped key, got %v", err)
+	}
+}
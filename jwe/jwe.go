@@ -0,0 +1,381 @@
+// Package jwe implements JSON Web Encryption (RFC 7516) with HiAE as the content encryption algorithm
+//
+// HiAE is not registered with IANA, so it is identified by the private-use name "HiAE" in the
+// "enc" header parameter. Content is encrypted with a 32-byte content encryption key (CEK), a
+// 16-byte initialization vector and a 16-byte tag. The CEK is either the shared key itself
+// ("dir") or a random key wrapped with AES Key Wrap ("A256KW").
+//
+// As RFC 7516 Section 5.1 requires, the associated data is the ASCII of the encoded protected
+// header, followed by '.' and the encoded JWE AAD when the JSON serialisation carries one.
+package jwe
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	hiae "github.com/hiae-aead/go-hiae"
+	"github.com/hiae-aead/go-hiae/internal/keywrap"
+)
+
+// Algorithm names
+const (
+	// EncHiAE is the private-use "enc" value for HiAE
+	EncHiAE = "HiAE"
+	// AlgDir uses the shared key directly as the CEK
+	AlgDir = "dir"
+	// AlgA256KW wraps a random CEK with AES-256 Key Wrap
+	AlgA256KW = "A256KW"
+)
+
+var b64 = base64.RawURLEncoding.Strict()
+
+var (
+	// ErrMalformed is returned for input that is not a valid JWE
+	ErrMalformed = errors.New("jwe: malformed input")
+	// ErrUnsupported is returned for algorithms, compression or critical extensions this package does not implement
+	ErrUnsupported = errors.New("jwe: unsupported header parameter")
+	// ErrDecrypt is returned when no recipient could be decrypted with the key
+	ErrDecrypt = errors.New("jwe: decryption failed")
+)
+
+// Header holds the JOSE header parameters understood by this package
+type Header struct {
+	Alg  string   `json:"alg,omitempty"`
+	Enc  string   `json:"enc,omitempty"`
+	Kid  string   `json:"kid,omitempty"`
+	Typ  string   `json:"typ,omitempty"`
+	Cty  string   `json:"cty,omitempty"`
+	Zip  string   `json:"zip,omitempty"`
+	Crit []string `json:"crit,omitempty"`
+}
+
+// Key is a shared key together with the only key management algorithm it may be used with
+type Key struct {
+	Alg   string // AlgDir or AlgA256KW
+	Key   []byte // 32 bytes
+	KeyID string // Optional "kid"
+}
+
+// check validates the key's algorithm and length
+func (k Key) check() error {
+	if k.Alg != AlgDir && k.Alg != AlgA256KW {
+		return ErrUnsupported
+	}
+	if len(k.Key) != hiae.KeyLen {
+		return errors.New("jwe: key must be 32 bytes")
+	}
+	return nil
+}
+
+// wrap returns the encrypted key for cek
+func (k Key) wrap(cek []byte) ([]byte, error) {
+	if k.Alg == AlgDir {
+		return nil, nil
+	}
+	return keywrap.Wrap(k.Key, cek)
+}
+
+// unwrap recovers the CEK from an encrypted key
+func (k Key) unwrap(encryptedKey []byte) ([]byte, error) {
+	if k.Alg == AlgDir {
+		if len(encryptedKey) != 0 {
+			return nil, ErrMalformed
+		}
+		return k.Key, nil
+	}
+	cek, err := keywrap.Unwrap(k.Key, encryptedKey)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	if len(cek) != hiae.KeyLen {
+		return nil, ErrDecrypt
+	}
+	return cek, nil
+}
+
+// newCEK returns the CEK for a set of keys: the shared key for dir, a random key otherwise
+func newCEK(keys []Key) ([]byte, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("jwe: no recipients")
+	}
+	for _, k := range keys {
+		if err := k.check(); err != nil {
+			return nil, err
+		}
+		if k.Alg == AlgDir && len(keys) > 1 {
+			return nil, errors.New("jwe: dir cannot be combined with other recipients")
+		}
+	}
+	if keys[0].Alg == AlgDir {
+		return keys[0].Key, nil
+	}
+	cek := make([]byte, hiae.KeyLen)
+	if _, err := rand.Read(cek); err != nil {
+		return nil, err
+	}
+	return cek, nil
+}
+
+// encryptContent seals plaintext under cek with a random IV
+func encryptContent(cek, plaintext []byte, aad string) (iv, ct, tag []byte, err error) {
+	iv = make([]byte, hiae.NonceLen)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, nil, nil, err
+	}
+	ct = make([]byte, len(plaintext))
+	tag = make([]byte, hiae.TagLen)
+	if err := hiae.EncryptTo(plaintext, []byte(aad), cek, iv, ct, tag); err != nil {
+		return nil, nil, nil, err
+	}
+	return iv, ct, tag, nil
+}
+
+// decryptContent opens ciphertext under cek
+func decryptContent(cek, iv, ct, tag []byte, aad string) ([]byte, error) {
+	if len(iv) != hiae.NonceLen || len(tag) != hiae.TagLen {
+		return nil, ErrMalformed
+	}
+	pt := make([]byte, len(ct))
+	if err := hiae.DecryptTo(ct, tag, []byte(aad), cek, iv, pt); err != nil {
+		return nil, ErrDecrypt
+	}
+	return pt, nil
+}
+
+// mergeHeaders combines header objects, which must not share any parameter, and validates the result
+func mergeHeaders(parts ...[]byte) (*Header, error) {
+	merged := make(map[string]json.RawMessage)
+	for _, p := range parts {
+		if len(p) == 0 {
+			continue
+		}
+		var m map[string]json.RawMessage
+		if err := json.Unmarshal(p, &m); err != nil {
+			return nil, ErrMalformed
+		}
+		for k, v := range m {
+			if _, dup := merged[k]; dup {
+				return nil, ErrMalformed
+			}
+			merged[k] = v
+		}
+	}
+	raw, _ := json.Marshal(merged)
+	var h Header
+	if err := json.Unmarshal(raw, &h); err != nil {
+		return nil, ErrMalformed
+	}
+	if h.Enc != EncHiAE || h.Zip != "" || len(h.Crit) > 0 {
+		return nil, ErrUnsupported
+	}
+	return &h, nil
+}
+
+// decodeSegments base64url-decodes each string, failing on the first invalid one
+func decodeSegments(segments ...string) ([][]byte, error) {
+	out := make([][]byte, len(segments))
+	for i, s := range segments {
+		b, err := b64.DecodeString(s)
+		if err != nil {
+			return nil, ErrMalformed
+		}
+		out[i] = b
+	}
+	return out, nil
+}
+
+// EncryptCompact encrypts plaintext for key in the compact serialisation
+// Alg, Enc and Kid are filled in from key; other fields of h, such as Typ and Cty, are kept.
+func EncryptCompact(plaintext []byte, key Key, h Header) (string, error) {
+	cek, err := newCEK([]Key{key})
+	if err != nil {
+		return "", err
+	}
+	encryptedKey, err := key.wrap(cek)
+	if err != nil {
+		return "", err
+	}
+
+	h.Alg, h.Enc, h.Kid = key.Alg, EncHiAE, key.KeyID
+	if h.Zip != "" || len(h.Crit) > 0 {
+		return "", ErrUnsupported
+	}
+	hj, err := json.Marshal(h)
+	if err != nil {
+		return "", err
+	}
+	protected := b64.EncodeToString(hj)
+
+	iv, ct, tag, err := encryptContent(cek, plaintext, protected)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join([]string{
+		protected,
+		b64.EncodeToString(encryptedKey),
+		b64.EncodeToString(iv),
+		b64.EncodeToString(ct),
+		b64.EncodeToString(tag),
+	}, "."), nil
+}
+
+// DecryptCompact decrypts a compact serialisation with key
+// The header's "alg" must match key.Alg, and "kid" must match key.KeyID when that is set.
+func DecryptCompact(token string, key Key) ([]byte, *Header, error) {
+	if err := key.check(); err != nil {
+		return nil, nil, err
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 5 {
+		return nil, nil, ErrMalformed
+	}
+	seg, err := decodeSegments(parts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	h, err := mergeHeaders(seg[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	if h.Alg != key.Alg || (key.KeyID != "" && h.Kid != key.KeyID) {
+		return nil, nil, ErrDecrypt
+	}
+
+	cek, err := key.unwrap(seg[1])
+	if err != nil {
+		return nil, nil, err
+	}
+	pt, err := decryptContent(cek, seg[2], seg[3], seg[4], parts[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	return pt, h, nil
+}
+
+// jsonRecipient is one entry of the "recipients" array
+type jsonRecipient struct {
+	Header       json.RawMessage `json:"header,omitempty"`
+	EncryptedKey string          `json:"encrypted_key,omitempty"`
+}
+
+// jsonJWE covers both the general and the flattened JSON serialisation
+type jsonJWE struct {
+	Protected    string          `json:"protected,omitempty"`
+	Unprotected  json.RawMessage `json:"unprotected,omitempty"`
+	Recipients   []jsonRecipient `json:"recipients,omitempty"`
+	Header       json.RawMessage `json:"header,omitempty"`
+	EncryptedKey string          `json:"encrypted_key,omitempty"`
+	AAD          string          `json:"aad,omitempty"`
+	IV           string          `json:"iv"`
+	Ciphertext   string          `json:"ciphertext"`
+	Tag          string          `json:"tag"`
+}
+
+// jsonAAD returns the associated data for a JSON serialisation
+func jsonAAD(protected, aad string) string {
+	if aad == "" {
+		return protected
+	}
+	return protected + "." + aad
+}
+
+// EncryptJSON encrypts plaintext for one or more keys in the general JSON serialisation
+// Enc and the fields of h go into the protected header; each recipient's alg and kid go into
+// its unprotected per-recipient header. aad is optional extra authenticated data.
+func EncryptJSON(plaintext, aad []byte, h Header, keys ...Key) ([]byte, error) {
+	cek, err := newCEK(keys)
+	if err != nil {
+		return nil, err
+	}
+	if h.Alg != "" || h.Kid != "" || h.Zip != "" || len(h.Crit) > 0 {
+		return nil, ErrUnsupported
+	}
+	h.Enc = EncHiAE
+	hj, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+
+	out := jsonJWE{Protected: b64.EncodeToString(hj)}
+	for _, k := range keys {
+		encryptedKey, err := k.wrap(cek)
+		if err != nil {
+			return nil, err
+		}
+		rh, err := json.Marshal(Header{Alg: k.Alg, Kid: k.KeyID})
+		if err != nil {
+			return nil, err
+		}
+		out.Recipients = append(out.Recipients, jsonRecipient{Header: rh, EncryptedKey: b64.EncodeToString(encryptedKey)})
+	}
+	if aad != nil {
+		out.AAD = b64.EncodeToString(aad)
+	}
+
+	iv, ct, tag, err := encryptContent(cek, plaintext, jsonAAD(out.Protected, out.AAD))
+	if err != nil {
+		return nil, err
+	}
+	out.IV, out.Ciphertext, out.Tag = b64.EncodeToString(iv), b64.EncodeToString(ct), b64.EncodeToString(tag)
+
+	return json.Marshal(out)
+}
+
+// DecryptJSON decrypts a general or flattened JSON serialisation with key
+// It tries each recipient whose alg, and kid if key.KeyID is set, match the key. It returns
+// the plaintext, the merged header of the recipient that decrypted, and the JWE AAD.
+func DecryptJSON(data []byte, key Key) ([]byte, *Header, []byte, error) {
+	if err := key.check(); err != nil {
+		return nil, nil, nil, err
+	}
+	var j jsonJWE
+	if err := json.Unmarshal(data, &j); err != nil {
+		return nil, nil, nil, ErrMalformed
+	}
+	if len(j.Recipients) == 0 {
+		j.Recipients = []jsonRecipient{{Header: j.Header, EncryptedKey: j.EncryptedKey}}
+	} else if j.Header != nil || j.EncryptedKey != "" {
+		return nil, nil, nil, ErrMalformed
+	}
+
+	seg, err := decodeSegments(j.Protected, j.AAD, j.IV, j.Ciphertext, j.Tag)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	protected, aad, iv, ct, tag := seg[0], seg[1], seg[2], seg[3], seg[4]
+	if j.AAD == "" {
+		aad = nil
+	}
+
+	for _, r := range j.Recipients {
+		h, err := mergeHeaders(protected, j.Unprotected, r.Header)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if h.Alg != key.Alg || (key.KeyID != "" && h.Kid != key.KeyID) {
+			continue
+		}
+		encryptedKey, err := b64.DecodeString(r.EncryptedKey)
+		if err != nil {
+			return nil, nil, nil, ErrMalformed
+		}
+		cek, err := key.unwrap(encryptedKey)
+		if err != nil {
+			continue
+		}
+		pt, err := decryptContent(cek, iv, ct, tag, jsonAAD(j.Protected, j.AAD))
+		if err != nil {
+			if errors.Is(err, ErrMalformed) {
+				return nil, nil, nil, err
+			}
+			continue
+		}
+		return pt, h, aad, nil
+	}
+
+	return nil, nil, nil, ErrDecrypt
+}
@@ -0,0 +1,165 @@
+package jwe
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+var (
+	dirKey = Key{Alg: AlgDir, Key: bytes.Repeat([]byte{0x01}, 32), KeyID: "dir-1"}
+	kwKey  = Key{Alg: AlgA256KW, Key: bytes.Repeat([]byte{0x02}, 32), KeyID: "kw-1"}
+)
+
+// TestCompact round trips the compact serialisation with both key management algorithms
+func TestCompact(t *testing.T) {
+	for _, key := range []Key{dirKey, kwKey} {
+		token, err := EncryptCompact([]byte(`{"sub":"alice"}`), key, Header{Typ: "JWT"})
+		if err != nil {
+			t.Fatalf("%s: EncryptCompact failed: %v", key.Alg, err)
+		}
+		parts := strings.Split(token, ".")
+		if len(parts) != 5 || (key.Alg == AlgDir) != (parts[1] == "") {
+			t.Fatalf("%s: unexpected token layout %s", key.Alg, token)
+		}
+
+		pt, h, err := DecryptCompact(token, key)
+		if err != nil || string(pt) != `{"sub":"alice"}` {
+			t.Fatalf("%s: DecryptCompact failed: %v", key.Alg, err)
+		}
+		if h.Alg != key.Alg || h.Enc != EncHiAE || h.Kid != key.KeyID || h.Typ != "JWT" {
+			t.Errorf("%s: unexpected header %+v", key.Alg, h)
+		}
+	}
+
+	// A token for one algorithm must not be accepted with a key for another
+	token, _ := EncryptCompact([]byte("x"), dirKey, Header{})
+	confused := Key{Alg: AlgA256KW, Key: dirKey.Key}
+	if _, _, err := DecryptCompact(token, confused); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Expected ErrDecrypt for algorithm mismatch, got %v", err)
+	}
+	if _, _, err := DecryptCompact(token, Key{Alg: AlgDir, Key: dirKey.Key, KeyID: "other"}); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Expected ErrDecrypt for kid mismatch, got %v", err)
+	}
+}
+
+// TestCompactVector decrypts a pinned A256KW token
+func TestCompactVector(t *testing.T) {
+	token := "eyJhbGciOiJBMjU2S1ciLCJlbmMiOiJIaUFFIiwia2lkIjoia3ctMSJ9.-Jjg7luakszzew6QVM3T3asG3zzyr1ITLLl3eU4zJqiuGkrqhYw4Sw.gK6fhzn6IwLPvbCPPrE2fQ.qn40OdraY-HfRs0F1A.jbSZqwriofMa_fszR0u9Rw"
+	pt, h, err := DecryptCompact(token, kwKey)
+	if err != nil || string(pt) != "pinned claims" {
+		t.Fatalf("DecryptCompact failed: %q, %v", pt, err)
+	}
+	if h.Kid != "kw-1" {
+		t.Errorf("Unexpected kid %q", h.Kid)
+	}
+}
+
+// flipSegment decodes a base64url segment, flips one bit and re-encodes it
+func flipSegment(s string) string {
+	b, _ := b64.DecodeString(s)
+	if len(b) == 0 {
+		return b64.EncodeToString([]byte{0})
+	}
+	b[len(b)/2] ^= 1
+	return b64.EncodeToString(b)
+}
+
+// TestCompactTamper modifies every segment of a token
+func TestCompactTamper(t *testing.T) {
+	for _, key := range []Key{dirKey, kwKey} {
+		token, _ := EncryptCompact([]byte("tamper me"), key, Header{})
+		parts := strings.Split(token, ".")
+		for i := range parts {
+			bad := append([]string{}, parts...)
+			bad[i] = flipSegment(bad[i])
+			if _, _, err := DecryptCompact(strings.Join(bad, "."), key); err == nil {
+				t.Errorf("%s: modification of segment %d was not detected", key.Alg, i)
+			}
+		}
+		for _, bad := range []string{parts[0] + ".", token + ".", token + "=", strings.Replace(token, ".", "..", 1)} {
+			if _, _, err := DecryptCompact(bad, key); err == nil {
+				t.Errorf("%s: malformed token %q was accepted", key.Alg, bad)
+			}
+		}
+	}
+
+	// Critical extensions and compression are refused even when the tag is valid
+	for _, h := range []string{`{"alg":"dir","enc":"HiAE","crit":["exp"],"exp":1}`, `{"alg":"dir","enc":"HiAE","zip":"DEF"}`, `{"alg":"dir","enc":"A256GCM"}`} {
+		protected := b64.EncodeToString([]byte(h))
+		iv, ct, tag, _ := encryptContent(dirKey.Key, []byte("x"), protected)
+		token := strings.Join([]string{protected, "", b64.EncodeToString(iv), b64.EncodeToString(ct), b64.EncodeToString(tag)}, ".")
+		if _, _, err := DecryptCompact(token, Key{Alg: AlgDir, Key: dirKey.Key}); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("%s: expected ErrUnsupported, got %v", h, err)
+		}
+	}
+}
+
+// TestJSON round trips the general serialisation with several recipients and parses the flattened form
+func TestJSON(t *testing.T) {
+	other := Key{Alg: AlgA256KW, Key: bytes.Repeat([]byte{0x03}, 32), KeyID: "kw-2"}
+	data, err := EncryptJSON([]byte("for both"), []byte("extra"), Header{Cty: "text/plain"}, kwKey, other)
+	if err != nil {
+		t.Fatalf("EncryptJSON failed: %v", err)
+	}
+	for _, key := range []Key{kwKey, other} {
+		pt, h, aad, err := DecryptJSON(data, key)
+		if err != nil || string(pt) != "for both" || string(aad) != "extra" {
+			t.Fatalf("%s: DecryptJSON failed: %v", key.KeyID, err)
+		}
+		if h.Kid != key.KeyID || h.Cty != "text/plain" || h.Enc != EncHiAE {
+			t.Errorf("%s: unexpected header %+v", key.KeyID, h)
+		}
+	}
+	stranger := Key{Alg: AlgA256KW, Key: bytes.Repeat([]byte{0x04}, 32)}
+	if _, _, _, err := DecryptJSON(data, stranger); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Expected ErrDecrypt for unknown key, got %v", err)
+	}
+	if _, err := EncryptJSON(nil, nil, Header{}, dirKey, kwKey); err == nil {
+		t.Error("Expected error for dir with several recipients")
+	}
+
+	// The flattened form moves the single recipient to the top level
+	data, _ = EncryptJSON([]byte("flat"), nil, Header{}, dirKey)
+	var j map[string]any
+	json.Unmarshal(data, &j)
+	r := j["recipients"].([]any)[0].(map[string]any)
+	delete(j, "recipients")
+	j["header"] = r["header"]
+	flat, _ := json.Marshal(j)
+	pt, _, aad, err := DecryptJSON(flat, dirKey)
+	if err != nil || string(pt) != "flat" || aad != nil {
+		t.Fatalf("DecryptJSON of flattened form failed: %v", err)
+	}
+}
+
+// TestJSONTamper modifies every member of a JSON serialisation
+func TestJSONTamper(t *testing.T) {
+	data, _ := EncryptJSON([]byte("tamper me"), []byte("aad"), Header{Typ: "x"}, kwKey)
+
+	mutate := func(f func(j *jsonJWE)) []byte {
+		var j jsonJWE
+		json.Unmarshal(data, &j)
+		f(&j)
+		out, _ := json.Marshal(j)
+		return out
+	}
+	cases := map[string][]byte{
+		"protected":         mutate(func(j *jsonJWE) { j.Protected = flipSegment(j.Protected) }),
+		"aad":               mutate(func(j *jsonJWE) { j.AAD = flipSegment(j.AAD) }),
+		"aad removed":       mutate(func(j *jsonJWE) { j.AAD = "" }),
+		"iv":                mutate(func(j *jsonJWE) { j.IV = flipSegment(j.IV) }),
+		"ciphertext":        mutate(func(j *jsonJWE) { j.Ciphertext = flipSegment(j.Ciphertext) }),
+		"tag":               mutate(func(j *jsonJWE) { j.Tag = flipSegment(j.Tag) }),
+		"encrypted_key":     mutate(func(j *jsonJWE) { j.Recipients[0].EncryptedKey = flipSegment(j.Recipients[0].EncryptedKey) }),
+		"duplicate enc":     mutate(func(j *jsonJWE) { j.Unprotected = json.RawMessage(`{"enc":"HiAE"}`) }),
+		"recipient and top": mutate(func(j *jsonJWE) { j.EncryptedKey = "AA" }),
+	}
+	for name, bad := range cases {
+		if _, _, _, err := DecryptJSON(bad, kwKey); err == nil {
+			t.Errorf("%s: modification was not detected", name)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+// Package keys derives independent HiAE keys and nonce material from a root secret
+//
+// All derivations use HKDF-SHA256 from crypto/hkdf with an empty salt. The HKDF info is the
+// length-prefixed encoding of hiae.EncodeADFields over
+//
+//	"hiae-keys/v1", output kind, label, context...
+//
+// so that no two distinct (kind, label, context) inputs share an info string, whatever bytes
+// they contain. The output kind separates keys from nonce material derived under the same label.
+package keys
+
+import (
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	hiae "github.com/hiae-aead/go-hiae"
+)
+
+const version = "hiae-keys/v1"
+
+// Output kinds bound into the HKDF info
+const (
+	kindKey         = "key"
+	kindKeyNonce    = "key+nonce"
+	kindNoncePrefix = "nonce-prefix"
+)
+
+// Key is a derived HiAE key
+type Key [hiae.KeyLen]byte
+
+// expand runs HKDF over root with the encoded info and returns length bytes
+func expand(root []byte, kind, label string, context [][]byte, length int) []byte {
+	fields := make([][]byte, 0, 3+len(context))
+	fields = append(fields, []byte(version), []byte(kind), []byte(label))
+	fields = append(fields, context...)
+	out, err := hkdf.Key(sha256.New, root, nil, string(hiae.EncodeADFields(fields...)), length)
+	if err != nil {
+		panic("keys: hkdf failed: " + err.Error())
+	}
+	return out
+}
+
+// Derive returns the key for label and context under root
+// The root should be at least 32 bytes of secret, uniformly random material.
+func Derive(root []byte, label string, context ...[]byte) Key {
+	var k Key
+	copy(k[:], expand(root, kindKey, label, context, hiae.KeyLen))
+	return k
+}
+
+// Derive returns a child key of k, so that keys can be derived level by level
+func (k Key) Derive(label string, context ...[]byte) Key {
+	return Derive(k[:], label, context...)
+}
+
+// DerivePath derives one level per path element, e.g. DerivePath(root, "tenant-a", "billing")
+// Each element is used as the label of its level, so the result depends on the whole path.
+func DerivePath(root []byte, path ...string) Key {
+	k := Derive(root, "root")
+	for _, p := range path {
+		k = k.Derive(p)
+	}
+	return k
+}
+
+// Material is a key together with a base nonce derived alongside it
+// The base nonce is meant to be combined with a per-message counter, for example by XOR.
+type Material struct {
+	Key       Key
+	BaseNonce [hiae.NonceLen]byte
+}
+
+// DeriveMaterial returns a key and base nonce for label and context under root
+func DeriveMaterial(root []byte, label string, context ...[]byte) Material {
+	out := expand(root, kindKeyNonce, label, context, hiae.KeyLen+hiae.NonceLen)
+	var m Material
+	copy(m.Key[:], out)
+	copy(m.BaseNonce[:], out[hiae.KeyLen:])
+	return m
+}
+
+// DeriveNoncePrefix returns an n-byte nonce prefix for label and context under root
+// The prefix is suitable for hiae.NewPrefixCounterNonce, and must leave room for a counter.
+func DeriveNoncePrefix(root []byte, label string, n int, context ...[]byte) ([]byte, error) {
+	if n <= 0 || n >= hiae.NonceLen {
+		return nil, errors.New("keys: nonce prefix must be between 1 and 15 bytes")
+	}
+	return expand(root, kindNoncePrefix, label, context, n), nil
+}
+
+// Epoch returns the index of the period containing t, counting from the Unix epoch
+func Epoch(t time.Time, period time.Duration) uint64 {
+	if period <= 0 {
+		panic("keys: epoch period must be positive")
+	}
+	ns := t.UnixNano()
+	if ns < 0 {
+		return 0
+	}
+	return uint64(ns / int64(period))
+}
+
+// DeriveEpoch returns the key for label in the given epoch
+// The epoch is encoded as the first context field, as a big-endian 64-bit integer.
+func DeriveEpoch(root []byte, label string, epoch uint64, context ...[]byte) Key {
+	var e [8]byte
+	binary.BigEndian.PutUint64(e[:], epoch)
+	return Derive(root, label, append([][]byte{e[:]}, context...)...)
+}
+
+// Rotation derives keys that change every Period
+type Rotation struct {
+	Root   []byte
+	Label  string
+	Period time.Duration
+
+	// Now returns the current time. It defaults to time.Now.
+	Now func() time.Time
+}
+
+// EpochKey is a key together with the epoch it belongs to
+type EpochKey struct {
+	Epoch uint64
+	Key   Key
+}
+
+// now returns the current epoch
+func (r *Rotation) now() uint64 {
+	now := time.Now
+	if r.Now != nil {
+		now = r.Now
+	}
+	return Epoch(now(), r.Period)
+}
+
+// Current returns the key of the current epoch, for encrypting new data
+func (r *Rotation) Current(context ...[]byte) EpochKey {
+	e := r.now()
+	return EpochKey{Epoch: e, Key: DeriveEpoch(r.Root, r.Label, e, context...)}
+}
+
+// Key returns the key of a given epoch, typically one recorded alongside a ciphertext
+func (r *Rotation) Key(epoch uint64, context ...[]byte) Key {
+	return DeriveEpoch(r.Root, r.Label, epoch, context...)
+}
+
+// Window returns the keys of the current epoch and up to previous earlier ones, newest first
+// Receivers use it to accept data sealed shortly before a rotation.
+func (r *Rotation) Window(previous int, context ...[]byte) []EpochKey {
+	e := r.now()
+	out := make([]EpochKey, 0, previous+1)
+	for i := 0; i <= previous && uint64(i) <= e; i++ {
+		out = append(out, EpochKey{Epoch: e - uint64(i), Key: DeriveEpoch(r.Root, r.Label, e-uint64(i), context...)})
+	}
+	return out
+}
@@ -0,0 +1,86 @@
+package keys
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+var testRoot = bytes.Repeat([]byte{0x0b}, 32)
+
+// TestVectors pins the exact outputs of every derivation
+func TestVectors(t *testing.T) {
+	vectors := []struct {
+		name     string
+		got      func() []byte
+		expected string
+	}{
+		{"Derive", func() []byte { k := Derive(testRoot, "db"); return k[:] }, "f1a2ed30ec58622d7b5431fd1a9f19bc05df84e7e943df3bdd19069da4f3cebd"},
+		{"Derive context", func() []byte { k := Derive(testRoot, "db", []byte("tenant-a"), []byte("users")); return k[:] }, "e4237198e967e86ddf36c8439157f1cd0bf022b7f071e2d4222c3da3e0e9f362"},
+		{"DerivePath", func() []byte { k := DerivePath(testRoot, "tenant-a", "db"); return k[:] }, "e4bfa6672612c3d72339f8e10797adebb9072f4555fb8c68f57c65ec3b2dc0a7"},
+		{"DeriveEpoch", func() []byte { k := DeriveEpoch(testRoot, "session", 42); return k[:] }, "f506c99ec5d1ea22c041711cf7f83d96ea09030cf56d7ae36e4bcbba485edec9"},
+		{"Material key", func() []byte { m := DeriveMaterial(testRoot, "stream"); return m.Key[:] }, "1bfe090568a62f8c4c7da7c6dcc2a56046cf8357dc44a974c9bd482b11738d7a"},
+		{"Material nonce", func() []byte { m := DeriveMaterial(testRoot, "stream"); return m.BaseNonce[:] }, "844d70935d4ea7a3913833609bf7cec2"},
+		{"NoncePrefix", func() []byte { p, _ := DeriveNoncePrefix(testRoot, "writer", 4, []byte("host-1")); return p }, "37479ad4"},
+	}
+	for _, tv := range vectors {
+		if got := hex.EncodeToString(tv.got()); got != tv.expected {
+			t.Errorf("%s: expected %s, got %s", tv.name, tv.expected, got)
+		}
+	}
+}
+
+// TestSeparation checks that ambiguous-looking inputs derive different keys
+func TestSeparation(t *testing.T) {
+	seen := make(map[Key]string)
+	add := func(name string, k Key) {
+		if prev, ok := seen[k]; ok {
+			t.Errorf("%s and %s derived the same key", name, prev)
+		}
+		seen[k] = name
+	}
+	add("ab|c", Derive(testRoot, "ab", []byte("c")))
+	add("a|bc", Derive(testRoot, "a", []byte("bc")))
+	add("a|b|c", Derive(testRoot, "a", []byte("b"), []byte("c")))
+	add("abc", Derive(testRoot, "abc"))
+	add("abc|empty", Derive(testRoot, "abc", nil))
+	add("material", DeriveMaterial(testRoot, "abc").Key)
+	add("path", DerivePath(testRoot, "abc"))
+
+	if DerivePath(testRoot, "abc") != Derive(testRoot, "root").Derive("abc") {
+		t.Error("DerivePath does not match chained Derive calls")
+	}
+	seen = make(map[Key]string)
+	add("path a/b", DerivePath(testRoot, "a", "b"))
+	add("path b/a", DerivePath(testRoot, "b", "a"))
+	add("path ab", DerivePath(testRoot, "ab"))
+
+	if _, err := DeriveNoncePrefix(testRoot, "x", 16); err == nil {
+		t.Error("Expected error for prefix without room for a counter")
+	}
+}
+
+// TestRotation checks epoch boundaries and the decryption window
+func TestRotation(t *testing.T) {
+	now := time.Unix(86400*10+5, 0)
+	r := &Rotation{Root: testRoot, Label: "tokens", Period: 24 * time.Hour, Now: func() time.Time { return now }}
+
+	cur := r.Current()
+	if cur.Epoch != 10 || cur.Key != r.Key(10) {
+		t.Fatalf("Unexpected current epoch %d", cur.Epoch)
+	}
+	if Epoch(now.Add(-6*time.Second), r.Period) != 9 {
+		t.Error("Expected previous epoch just before the boundary")
+	}
+
+	w := r.Window(2)
+	if len(w) != 3 || w[0].Epoch != 10 || w[2].Epoch != 8 || w[1].Key != r.Key(9) {
+		t.Errorf("Unexpected window %v", w)
+	}
+
+	now = time.Unix(0, 0)
+	if w := r.Window(3); len(w) != 1 || w[0].Epoch != 0 {
+		t.Errorf("Window must not go below epoch 0, got %d entries", len(w))
+	}
+}
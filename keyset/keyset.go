@@ -0,0 +1,354 @@
+// Package keyset manages a set of HiAE keys with identifiers, a primary key and rotation
+//
+// Ciphertexts produced by a Keyset carry the ID of the key that sealed them:
+//
+//	0x01 || key ID (4, big-endian) || nonce (16) || ciphertext || tag
+//
+// The prefix is authenticated as associated data, ahead of the caller's own. Opening looks up
+// the key by ID, so keys can be rotated without re-encrypting existing data. Ciphertexts
+// written before keysets were introduced, laid out as nonce || ciphertext || tag, can be opened
+// by enabling try-all mode, which attempts every enabled key in turn.
+package keyset
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	hiae "github.com/hiae-aead/go-hiae"
+)
+
+// Status is the lifecycle state of a key
+type Status uint8
+
+// Key statuses
+const (
+	// StatusPrimary marks the one key used for sealing; it also opens
+	StatusPrimary Status = 1
+	// StatusActive keys open existing ciphertexts but are not used for sealing
+	StatusActive Status = 2
+	// StatusDisabled keys are kept but neither seal nor open
+	StatusDisabled Status = 3
+)
+
+// String returns the status name
+func (s Status) String() string {
+	switch s {
+	case StatusPrimary:
+		return "primary"
+	case StatusActive:
+		return "active"
+	case StatusDisabled:
+		return "disabled"
+	}
+	return "unknown"
+}
+
+const (
+	prefixVersion = 0x01
+	// PrefixLen is the length of the key ID prefix of a sealed message
+	PrefixLen = 1 + 4
+	// Overhead is the number of bytes Seal adds to a message
+	Overhead = PrefixLen + hiae.NonceLen + hiae.TagLen
+)
+
+var (
+	// ErrKeyNotFound is returned for key IDs that are not in the keyset
+	ErrKeyNotFound = errors.New("keyset: key not found")
+	// ErrNoPrimary is returned when sealing with a keyset that has no primary key
+	ErrNoPrimary = errors.New("keyset: no primary key")
+	// ErrPrimaryKey is returned when trying to disable or remove the primary key
+	ErrPrimaryKey = errors.New("keyset: operation not allowed on the primary key")
+	// ErrDecrypt is returned when no enabled key opens a ciphertext
+	ErrDecrypt = errors.New("keyset: decryption failed")
+)
+
+// KeyInfo describes a key without revealing it
+type KeyInfo struct {
+	ID     uint32
+	Status Status
+}
+
+type entry struct {
+	id     uint32
+	status Status
+	key    [hiae.KeyLen]byte
+}
+
+// Keyset is a set of HiAE keys, safe for concurrent use
+type Keyset struct {
+	mu      sync.RWMutex
+	entries []*entry
+	primary *entry
+	tryAll  bool
+}
+
+// New returns a keyset holding one freshly generated primary key
+func New() (*Keyset, error) {
+	ks := &Keyset{}
+	if _, err := ks.Rotate(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// find returns the entry with the given ID, or nil
+func (ks *Keyset) find(id uint32) *entry {
+	for _, e := range ks.entries {
+		if e.id == id {
+			return e
+		}
+	}
+	return nil
+}
+
+// newID picks a random key ID not yet used in the keyset
+func (ks *Keyset) newID() (uint32, error) {
+	var b [4]byte
+	for {
+		if _, err := rand.Read(b[:]); err != nil {
+			return 0, err
+		}
+		id := binary.BigEndian.Uint32(b[:])
+		if id != 0 && ks.find(id) == nil {
+			return id, nil
+		}
+	}
+}
+
+// add inserts a key under a fresh ID with the given status
+func (ks *Keyset) add(key []byte, status Status) (uint32, error) {
+	if len(key) != hiae.KeyLen {
+		return 0, errors.New("keyset: key must be 32 bytes")
+	}
+	id, err := ks.newID()
+	if err != nil {
+		return 0, err
+	}
+	e := &entry{id: id, status: StatusActive}
+	copy(e.key[:], key)
+	ks.entries = append(ks.entries, e)
+	if status == StatusPrimary {
+		ks.setPrimary(e)
+	} else {
+		e.status = status
+	}
+	return id, nil
+}
+
+// setPrimary makes e the primary key, demoting the previous primary to active
+func (ks *Keyset) setPrimary(e *entry) {
+	if ks.primary != nil && ks.primary != e {
+		ks.primary.status = StatusActive
+	}
+	e.status = StatusPrimary
+	ks.primary = e
+}
+
+// Add imports an existing 32-byte key with the given status and returns its new ID
+// Adding a key as StatusPrimary demotes the current primary to active.
+func (ks *Keyset) Add(key []byte, status Status) (uint32, error) {
+	if status != StatusPrimary && status != StatusActive && status != StatusDisabled {
+		return 0, errors.New("keyset: invalid status")
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	return ks.add(key, status)
+}
+
+// Generate adds a new random key as active and returns its ID
+// Distributing the keyset before promoting the key lets every reader open its ciphertexts
+// by the time writers start using it.
+func (ks *Keyset) Generate() (uint32, error) {
+	var key [hiae.KeyLen]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return 0, err
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	return ks.add(key[:], StatusActive)
+}
+
+// Rotate adds a new random key and makes it primary in one step, returning its ID
+func (ks *Keyset) Rotate() (uint32, error) {
+	var key [hiae.KeyLen]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return 0, err
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	return ks.add(key[:], StatusPrimary)
+}
+
+// Promote makes an enabled key the primary key
+func (ks *Keyset) Promote(id uint32) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	e := ks.find(id)
+	if e == nil {
+		return ErrKeyNotFound
+	}
+	if e.status == StatusDisabled {
+		return errors.New("keyset: cannot promote a disabled key")
+	}
+	ks.setPrimary(e)
+	return nil
+}
+
+// Disable stops a key from opening ciphertexts, without deleting it
+func (ks *Keyset) Disable(id uint32) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	e := ks.find(id)
+	if e == nil {
+		return ErrKeyNotFound
+	}
+	if e == ks.primary {
+		return ErrPrimaryKey
+	}
+	e.status = StatusDisabled
+	return nil
+}
+
+// Enable makes a disabled key active again
+func (ks *Keyset) Enable(id uint32) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	e := ks.find(id)
+	if e == nil {
+		return ErrKeyNotFound
+	}
+	if e.status == StatusDisabled {
+		e.status = StatusActive
+	}
+	return nil
+}
+
+// Remove deletes a non-primary key; ciphertexts sealed with it can no longer be opened
+func (ks *Keyset) Remove(id uint32) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	for i, e := range ks.entries {
+		if e.id != id {
+			continue
+		}
+		if e == ks.primary {
+			return ErrPrimaryKey
+		}
+		clear(e.key[:])
+		ks.entries = append(ks.entries[:i], ks.entries[i+1:]...)
+		return nil
+	}
+	return ErrKeyNotFound
+}
+
+// Primary returns the ID of the primary key, or 0 if there is none
+func (ks *Keyset) Primary() uint32 {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	if ks.primary == nil {
+		return 0
+	}
+	return ks.primary.id
+}
+
+// Keys lists the IDs and statuses of all keys, in the order they were added
+func (ks *Keyset) Keys() []KeyInfo {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	out := make([]KeyInfo, len(ks.entries))
+	for i, e := range ks.entries {
+		out[i] = KeyInfo{ID: e.id, Status: e.status}
+	}
+	return out
+}
+
+// SetTryAll enables or disables try-all mode for unprefixed legacy ciphertexts
+func (ks *Keyset) SetTryAll(enabled bool) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.tryAll = enabled
+}
+
+// prefixAD returns the associated data authenticated for a prefixed ciphertext
+func prefixAD(prefix, ad []byte) []byte {
+	return append(append(make([]byte, 0, len(prefix)+len(ad)), prefix...), ad...)
+}
+
+// Seal encrypts msg with the primary key under a random nonce
+func (ks *Keyset) Seal(msg, ad []byte) ([]byte, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	if ks.primary == nil {
+		return nil, ErrNoPrimary
+	}
+
+	out := make([]byte, Overhead+len(msg))
+	out[0] = prefixVersion
+	binary.BigEndian.PutUint32(out[1:PrefixLen], ks.primary.id)
+	nonce := out[PrefixLen : PrefixLen+hiae.NonceLen]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	ct := out[PrefixLen+hiae.NonceLen : len(out)-hiae.TagLen]
+	tag := out[len(out)-hiae.TagLen:]
+	if err := hiae.EncryptTo(msg, prefixAD(out[:PrefixLen], ad), ks.primary.key[:], nonce, ct, tag); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// KeyID returns the ID of the key that sealed a ciphertext produced by Seal
+// It only parses the prefix; ok is false for ciphertexts without one, such as legacy ones.
+func KeyID(sealed []byte) (id uint32, ok bool) {
+	if len(sealed) < Overhead || sealed[0] != prefixVersion {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(sealed[1:PrefixLen]), true
+}
+
+// open decrypts nonce || ciphertext || tag with one key
+func open(e *entry, sealed, ad []byte) ([]byte, error) {
+	if len(sealed) < hiae.NonceLen+hiae.TagLen {
+		return nil, ErrDecrypt
+	}
+	nonce := sealed[:hiae.NonceLen]
+	ct := sealed[hiae.NonceLen : len(sealed)-hiae.TagLen]
+	tag := sealed[len(sealed)-hiae.TagLen:]
+	msg := make([]byte, len(ct))
+	if err := hiae.DecryptTo(ct, tag, ad, e.key[:], nonce, msg); err != nil {
+		return nil, ErrDecrypt
+	}
+	return msg, nil
+}
+
+// Open decrypts a ciphertext produced by Seal, selecting the key by its ID
+// In try-all mode, a ciphertext that does not open as prefixed is tried as legacy
+// nonce || ciphertext || tag against every enabled key.
+func (ks *Keyset) Open(sealed, ad []byte) ([]byte, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	if len(sealed) >= Overhead && sealed[0] == prefixVersion {
+		if e := ks.find(binary.BigEndian.Uint32(sealed[1:PrefixLen])); e != nil && e.status != StatusDisabled {
+			if msg, err := open(e, sealed[PrefixLen:], prefixAD(sealed[:PrefixLen], ad)); err == nil {
+				return msg, nil
+			}
+		}
+	}
+
+	if ks.tryAll {
+		for _, e := range ks.entries {
+			if e.status == StatusDisabled {
+				continue
+			}
+			if msg, err := open(e, sealed, ad); err == nil {
+				return msg, nil
+			}
+		}
+	}
+
+	return nil, ErrDecrypt
+}
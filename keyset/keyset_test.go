@@ -0,0 +1,202 @@
+package keyset
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	hiae "github.com/hiae-aead/go-hiae"
+)
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic("invalid hex string: " + s)
+	}
+	return b
+}
+
+func mustNew(t *testing.T) *Keyset {
+	t.Helper()
+	ks, err := New()
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return ks
+}
+
+// TestRotation checks that old ciphertexts stay readable across rotation until their key is disabled
+func TestRotation(t *testing.T) {
+	ks := mustNew(t)
+	first := ks.Primary()
+	old, err := ks.Seal([]byte("old"), []byte("ad"))
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+	if len(old) != Overhead+3 || binaryID(old) != first {
+		t.Fatalf("Unexpected prefix on %x", old)
+	}
+
+	second, err := ks.Rotate()
+	if err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	if ks.Primary() != second || second == first {
+		t.Fatal("Rotate did not install a new primary key")
+	}
+	fresh, _ := ks.Seal([]byte("new"), nil)
+	if binaryID(fresh) != second {
+		t.Error("Seal did not use the new primary key")
+	}
+
+	for _, c := range []struct {
+		sealed []byte
+		ad     []byte
+		msg    string
+	}{{old, []byte("ad"), "old"}, {fresh, nil, "new"}} {
+		pt, err := ks.Open(c.sealed, c.ad)
+		if err != nil || string(pt) != c.msg {
+			t.Fatalf("Open failed: %v", err)
+		}
+	}
+	if _, err := ks.Open(old, []byte("other")); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Expected ErrDecrypt for wrong associated data, got %v", err)
+	}
+
+	if err := ks.Disable(second); !errors.Is(err, ErrPrimaryKey) {
+		t.Errorf("Expected ErrPrimaryKey when disabling the primary, got %v", err)
+	}
+	if err := ks.Disable(first); err != nil {
+		t.Fatalf("Disable failed: %v", err)
+	}
+	if _, err := ks.Open(old, []byte("ad")); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Expected ErrDecrypt with disabled key, got %v", err)
+	}
+	if err := ks.Promote(first); err == nil {
+		t.Error("Expected error promoting a disabled key")
+	}
+	ks.Enable(first)
+	if err := ks.Promote(first); err != nil || ks.Primary() != first {
+		t.Fatalf("Promote failed: %v", err)
+	}
+	if _, err := ks.Open(old, []byte("ad")); err != nil {
+		t.Errorf("Open after re-enabling failed: %v", err)
+	}
+
+	if err := ks.Remove(second); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if _, err := ks.Open(fresh, nil); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Expected ErrDecrypt with removed key, got %v", err)
+	}
+	if err := ks.Remove(second); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+	if len(ks.Keys()) != 1 || ks.Keys()[0].Status != StatusPrimary {
+		t.Errorf("Unexpected keys %v", ks.Keys())
+	}
+}
+
+func binaryID(sealed []byte) uint32 {
+	return binary.BigEndian.Uint32(sealed[1:PrefixLen])
+}
+
+// TestPrefixTamper checks that the key ID prefix is authenticated
+func TestPrefixTamper(t *testing.T) {
+	ks := &Keyset{}
+	key := bytes.Repeat([]byte{1}, hiae.KeyLen)
+	a, _ := ks.Add(key, StatusPrimary)
+	b, _ := ks.Add(key, StatusActive)
+	sealed, _ := ks.Seal([]byte("msg"), nil)
+
+	// Both keys are identical, so only the authenticated prefix tells them apart
+	bad := append([]byte{}, sealed...)
+	binary.BigEndian.PutUint32(bad[1:PrefixLen], b)
+	if _, err := ks.Open(bad, nil); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Expected ErrDecrypt for swapped key ID, got %v", err)
+	}
+	if _, err := ks.Open(sealed[:Overhead-1], nil); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Expected ErrDecrypt for short ciphertext, got %v", err)
+	}
+	if ks.Primary() != a {
+		t.Error("Unexpected primary key")
+	}
+	if id, ok := KeyID(sealed); !ok || id != a {
+		t.Errorf("KeyID = %d, %v, want %d", id, ok, a)
+	}
+	if _, ok := KeyID(sealed[:Overhead-1]); ok {
+		t.Error("KeyID accepted a short ciphertext")
+	}
+	if _, ok := KeyID(append([]byte{0x02}, sealed[1:]...)); ok {
+		t.Error("KeyID accepted an unknown prefix version")
+	}
+	if _, err := (&Keyset{}).Seal(nil, nil); !errors.Is(err, ErrNoPrimary) {
+		t.Errorf("Expected ErrNoPrimary, got %v", err)
+	}
+}
+
+// TestTryAll checks that legacy nonce || ciphertext || tag data opens only in try-all mode
+func TestTryAll(t *testing.T) {
+	legacyKey := bytes.Repeat([]byte{0x42}, hiae.KeyLen)
+	nonce := bytes.Repeat([]byte{0x07}, hiae.NonceLen)
+	ct, tag, err := hiae.Encrypt([]byte("legacy"), []byte("ad"), legacyKey, nonce)
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	legacy := append(append(append([]byte{}, nonce...), ct...), tag...)
+
+	ks := mustNew(t)
+	ks.Add(legacyKey, StatusActive)
+	if _, err := ks.Open(legacy, []byte("ad")); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Expected ErrDecrypt without try-all, got %v", err)
+	}
+	ks.SetTryAll(true)
+	pt, err := ks.Open(legacy, []byte("ad"))
+	if err != nil || string(pt) != "legacy" {
+		t.Fatalf("Open in try-all mode failed: %v", err)
+	}
+}
+
+// TestSerialization checks Marshal and Unmarshal, including a pinned keyset and ciphertext
+func TestSerialization(t *testing.T) {
+	kek := bytes.Repeat([]byte{0x99}, hiae.KeyLen)
+	ks := mustNew(t)
+	ks.Generate()
+	id, _ := ks.Generate()
+	ks.Disable(id)
+	sealed, _ := ks.Seal([]byte("persisted"), nil)
+
+	data, err := ks.Marshal(kek)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	loaded, err := Unmarshal(data, kek)
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if loaded.Primary() != ks.Primary() || len(loaded.Keys()) != 3 || loaded.Keys()[2].Status != StatusDisabled {
+		t.Fatalf("Unexpected loaded keys %v", loaded.Keys())
+	}
+	if pt, err := loaded.Open(sealed, nil); err != nil || string(pt) != "persisted" {
+		t.Fatalf("Open with loaded keyset failed: %v", err)
+	}
+
+	if _, err := Unmarshal(data, bytes.Repeat([]byte{0x98}, hiae.KeyLen)); !errors.Is(err, ErrBadKeyset) {
+		t.Errorf("Expected ErrBadKeyset for wrong KEK, got %v", err)
+	}
+	data[len(data)-20] ^= 1
+	if _, err := Unmarshal(data, kek); !errors.Is(err, ErrBadKeyset) {
+		t.Errorf("Expected ErrBadKeyset for modified keyset, got %v", err)
+	}
+
+	pinned, err := Unmarshal(mustHex("484b53312bf880ec66415c011ad34ca90116f4ebb2a5d480deeb68882b0f7076aa994d0a641a081a95b976b3b4f37225c948d74f7aa1891775834bcd98a198010367e0180dafd19ff391678f777dd416501824ee123d2f9ca2b8832f359e01a27b1101d0cf091bbf88a7b6250a550440556d"), kek)
+	if err != nil {
+		t.Fatalf("Unmarshal of pinned keyset failed: %v", err)
+	}
+	pt, err := pinned.Open(mustHex("01f372085c609e2a47a8cfc627b65f9f4446403aab1470a3a4717b0e5440a664a7e6ea0686c3659a74788a"), []byte("ad"))
+	if err != nil || string(pt) != "pinned" {
+		t.Fatalf("Open of pinned ciphertext failed: %v", err)
+	}
+}
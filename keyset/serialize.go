@@ -0,0 +1,99 @@
+package keyset
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+
+	hiae "github.com/hiae-aead/go-hiae"
+)
+
+// Serialised keysets are always encrypted under a key-encryption key (KEK):
+//
+//	magic "HKS1" || nonce (16) || HiAE(kek, nonce, ad = magic, body) || tag
+//	body = count (4) || count * (id (4) || status (1) || key (32))
+
+var magic = []byte("HKS1")
+
+const entryLen = 4 + 1 + hiae.KeyLen
+
+// ErrBadKeyset is returned for serialised keysets that are malformed or fail authentication
+var ErrBadKeyset = errors.New("keyset: bad serialised keyset")
+
+// Marshal serialises the keyset, encrypted and authenticated under kek
+// The try-all setting is not part of the serialised form.
+func (ks *Keyset) Marshal(kek []byte) ([]byte, error) {
+	if len(kek) != hiae.KeyLen {
+		return nil, errors.New("keyset: key-encryption key must be 32 bytes")
+	}
+
+	ks.mu.RLock()
+	body := make([]byte, 4, 4+len(ks.entries)*entryLen)
+	binary.BigEndian.PutUint32(body, uint32(len(ks.entries)))
+	for _, e := range ks.entries {
+		body = binary.BigEndian.AppendUint32(body, e.id)
+		body = append(body, byte(e.status))
+		body = append(body, e.key[:]...)
+	}
+	ks.mu.RUnlock()
+	defer clear(body)
+
+	out := make([]byte, len(magic)+hiae.NonceLen+len(body)+hiae.TagLen)
+	copy(out, magic)
+	nonce := out[len(magic) : len(magic)+hiae.NonceLen]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	ct := out[len(magic)+hiae.NonceLen : len(out)-hiae.TagLen]
+	if err := hiae.EncryptTo(body, magic, kek, nonce, ct, out[len(out)-hiae.TagLen:]); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// Unmarshal decrypts and parses a keyset produced by Marshal
+func Unmarshal(data, kek []byte) (*Keyset, error) {
+	if len(kek) != hiae.KeyLen {
+		return nil, errors.New("keyset: key-encryption key must be 32 bytes")
+	}
+	headerLen := len(magic) + hiae.NonceLen
+	if len(data) < headerLen+4+hiae.TagLen || string(data[:len(magic)]) != string(magic) {
+		return nil, ErrBadKeyset
+	}
+
+	nonce := data[len(magic):headerLen]
+	ct := data[headerLen : len(data)-hiae.TagLen]
+	body := make([]byte, len(ct))
+	defer clear(body)
+	if err := hiae.DecryptTo(ct, data[len(data)-hiae.TagLen:], magic, kek, nonce, body); err != nil {
+		return nil, ErrBadKeyset
+	}
+
+	count := binary.BigEndian.Uint32(body)
+	if uint64(len(body)-4) != uint64(count)*entryLen {
+		return nil, ErrBadKeyset
+	}
+
+	ks := &Keyset{}
+	for p := body[4:]; len(p) > 0; p = p[entryLen:] {
+		e := &entry{id: binary.BigEndian.Uint32(p), status: Status(p[4])}
+		copy(e.key[:], p[5:entryLen])
+		if e.id == 0 || ks.find(e.id) != nil {
+			return nil, ErrBadKeyset
+		}
+		switch e.status {
+		case StatusPrimary:
+			if ks.primary != nil {
+				return nil, ErrBadKeyset
+			}
+			ks.primary = e
+		case StatusActive, StatusDisabled:
+		default:
+			return nil, ErrBadKeyset
+		}
+		ks.entries = append(ks.entries, e)
+	}
+
+	return ks, nil
+}
@@ -0,0 +1,135 @@
+// Package noise implements the Noise Protocol Framework with HiAE as the cipher
+//
+// The supported protocols are Noise_<pattern>_25519_HiAE_SHA256, using X25519 from
+// crypto/ecdh and SHA-256 for hashing and HKDF. The 64-bit Noise nonce n is mapped to
+// HiAE's 16-byte nonce as eight zero bytes followed by n in big-endian order.
+package noise
+
+import (
+	"encoding/binary"
+	"errors"
+
+	hiae "github.com/hiae-aead/go-hiae"
+)
+
+// Noise parameters
+const (
+	DHLen   = 32
+	HashLen = 32
+	KeyLen  = hiae.KeyLen
+	TagLen  = hiae.TagLen
+
+	// MaxMessageLen is the largest Noise message, including all tokens and the payload
+	MaxMessageLen = 65535
+
+	// maxNonce is reserved for rekeying and never used to encrypt messages
+	maxNonce = ^uint64(0)
+)
+
+var (
+	// ErrNonceExhausted is returned once a CipherState has used all 2^64-1 nonces
+	ErrNonceExhausted = errors.New("noise: nonce exhausted")
+	// ErrDecrypt is returned for messages that fail authentication
+	ErrDecrypt = errors.New("noise: decryption failed")
+	// ErrMessageTooLarge is returned for messages above MaxMessageLen
+	ErrMessageTooLarge = errors.New("noise: message too large")
+)
+
+// CipherState encrypts and decrypts transport or handshake messages under one key
+type CipherState struct {
+	k      [KeyLen]byte
+	hasKey bool
+	n      uint64
+}
+
+// nonce maps a Noise nonce into a HiAE nonce
+func nonce(n uint64) [hiae.NonceLen]byte {
+	var out [hiae.NonceLen]byte
+	binary.BigEndian.PutUint64(out[hiae.NonceLen-8:], n)
+	return out
+}
+
+// InitializeKey sets the key and resets the nonce to zero
+func (c *CipherState) InitializeKey(k []byte) {
+	copy(c.k[:], k)
+	c.hasKey = true
+	c.n = 0
+}
+
+// HasKey reports whether a key has been set
+func (c *CipherState) HasKey() bool {
+	return c.hasKey
+}
+
+// SetNonce sets the next nonce, for protocols that manage nonces explicitly
+func (c *CipherState) SetNonce(n uint64) {
+	c.n = n
+}
+
+// Nonce returns the next nonce to be used
+func (c *CipherState) Nonce() uint64 {
+	return c.n
+}
+
+// EncryptWithAd appends the encryption of plaintext to out, or plaintext itself if no key is set
+func (c *CipherState) EncryptWithAd(out, ad, plaintext []byte) ([]byte, error) {
+	if !c.hasKey {
+		return append(out, plaintext...), nil
+	}
+	if len(plaintext)+TagLen > MaxMessageLen {
+		return nil, ErrMessageTooLarge
+	}
+	if c.n == maxNonce {
+		return nil, ErrNonceExhausted
+	}
+
+	start := len(out)
+	out = append(out, make([]byte, len(plaintext)+TagLen)...)
+	ct := out[start : start+len(plaintext)]
+	tag := out[start+len(plaintext):]
+	nn := nonce(c.n)
+	if err := hiae.EncryptTo(plaintext, ad, c.k[:], nn[:], ct, tag); err != nil {
+		return nil, err
+	}
+	c.n++
+
+	return out, nil
+}
+
+// DecryptWithAd appends the decryption of ciphertext to out, or ciphertext itself if no key is set
+// The nonce only advances when authentication succeeds.
+func (c *CipherState) DecryptWithAd(out, ad, ciphertext []byte) ([]byte, error) {
+	if !c.hasKey {
+		return append(out, ciphertext...), nil
+	}
+	if len(ciphertext) > MaxMessageLen {
+		return nil, ErrMessageTooLarge
+	}
+	if c.n == maxNonce {
+		return nil, ErrNonceExhausted
+	}
+	if len(ciphertext) < TagLen {
+		return nil, ErrDecrypt
+	}
+
+	ct := ciphertext[:len(ciphertext)-TagLen]
+	tag := ciphertext[len(ciphertext)-TagLen:]
+	start := len(out)
+	out = append(out, make([]byte, len(ct))...)
+	nn := nonce(c.n)
+	if err := hiae.DecryptTo(ct, tag, ad, c.k[:], nn[:], out[start:]); err != nil {
+		return nil, ErrDecrypt
+	}
+	c.n++
+
+	return out, nil
+}
+
+// Rekey replaces the key with the first 32 bytes of the encryption of zeros under the reserved nonce
+func (c *CipherState) Rekey() {
+	var zeros, k [KeyLen]byte
+	var tag [TagLen]byte
+	nn := nonce(maxNonce)
+	hiae.EncryptTo(zeros[:], nil, c.k[:], nn[:], k[:], tag[:])
+	c.k = k
+}
@@ -0,0 +1,276 @@
+package noise
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic("invalid hex string: " + s)
+	}
+	return b
+}
+
+// fixedKey returns a deterministic X25519 key whose scalar bytes are all b
+func fixedKey(b byte) *ecdh.PrivateKey {
+	k, err := ecdh.X25519().NewPrivateKey(bytes.Repeat([]byte{b}, DHLen))
+	if err != nil {
+		panic(err)
+	}
+	return k
+}
+
+// mustPSK is WithPSK for placements known to be valid
+func mustPSK(p HandshakePattern, placements ...int) HandshakePattern {
+	out, err := WithPSK(p, placements...)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+// handshake runs pattern between two parties and returns both handshake states and transport ciphers
+func handshake(t *testing.T, init, resp Config) (hsI, hsR *HandshakeState, send, recv [2]*CipherState) {
+	t.Helper()
+	init.Initiator = true
+	var err error
+	if hsI, err = NewHandshakeState(init); err != nil {
+		t.Fatalf("NewHandshakeState (initiator) failed: %v", err)
+	}
+	if hsR, err = NewHandshakeState(resp); err != nil {
+		t.Fatalf("NewHandshakeState (responder) failed: %v", err)
+	}
+
+	writer, reader := hsI, hsR
+	for i := range init.Pattern.Messages {
+		payload := []byte{'m', byte('0' + i)}
+		msg, wc1, wc2, err := writer.WriteMessage(nil, payload)
+		if err != nil {
+			t.Fatalf("Message %d: WriteMessage failed: %v", i, err)
+		}
+		got, rc1, rc2, err := reader.ReadMessage(nil, msg)
+		if err != nil {
+			t.Fatalf("Message %d: ReadMessage failed: %v", i, err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Fatalf("Message %d: payload mismatch", i)
+		}
+		if wc1 != nil {
+			if writer == hsI {
+				send, recv = [2]*CipherState{wc1, rc2}, [2]*CipherState{rc1, wc2}
+			} else {
+				send, recv = [2]*CipherState{rc1, wc2}, [2]*CipherState{wc1, rc2}
+			}
+		}
+		writer, reader = reader, writer
+	}
+	if send[0] == nil {
+		t.Fatal("Handshake did not complete")
+	}
+	return hsI, hsR, send, recv
+}
+
+// TestHandshakes runs every supported pattern and checks the transport phase
+func TestHandshakes(t *testing.T) {
+	psk := bytes.Repeat([]byte{0x42}, KeyLen)
+	iStatic, rStatic := fixedKey(0x01), fixedKey(0x02)
+
+	vectors := []struct {
+		pattern      HandshakePattern
+		iHasStatic   bool
+		iKnowsRemote bool
+		expected     string
+	}{
+		{PatternNN, false, false, "NN"},
+		{PatternNK, false, true, "NK"},
+		{PatternXX, true, false, "XX"},
+		{PatternIK, true, true, "IK"},
+		{mustPSK(PatternNN, 0), false, false, "NNpsk0"},
+		{mustPSK(PatternXX, 3), true, false, "XXpsk3"},
+		{mustPSK(PatternIK, 2), true, true, "IKpsk2"},
+	}
+	for _, tv := range vectors {
+		if tv.pattern.Name != tv.expected {
+			t.Fatalf("Expected pattern name %s, got %s", tv.expected, tv.pattern.Name)
+		}
+		init := Config{Pattern: tv.pattern, Prologue: []byte("prologue")}
+		resp := Config{Pattern: tv.pattern, Prologue: []byte("prologue"), StaticKeypair: rStatic}
+		if tv.iHasStatic {
+			init.StaticKeypair = iStatic
+		}
+		if tv.iKnowsRemote {
+			init.PeerStatic = rStatic.PublicKey().Bytes()
+		}
+		if tv.pattern.usesPSK() {
+			init.PresharedKey, resp.PresharedKey = psk, psk
+		}
+
+		hsI, hsR, send, recv := handshake(t, init, resp)
+		if !bytes.Equal(hsI.HandshakeHash(), hsR.HandshakeHash()) {
+			t.Errorf("%s: handshake hashes differ", tv.expected)
+		}
+		if !bytes.Equal(hsI.PeerStatic(), rStatic.PublicKey().Bytes()) && tv.pattern.Name != "NN" && tv.pattern.Name != "NNpsk0" {
+			t.Errorf("%s: initiator did not learn responder static key", tv.expected)
+		}
+		if tv.iHasStatic && !bytes.Equal(hsR.PeerStatic(), iStatic.PublicKey().Bytes()) {
+			t.Errorf("%s: responder did not learn initiator static key", tv.expected)
+		}
+
+		for dir := 0; dir < 2; dir++ {
+			for i := 0; i < 3; i++ {
+				msg := []byte("transport message")
+				ct, err := send[dir].EncryptWithAd(nil, nil, msg)
+				if err != nil {
+					t.Fatalf("%s: EncryptWithAd failed: %v", tv.expected, err)
+				}
+				pt, err := recv[dir].DecryptWithAd(nil, nil, ct)
+				if err != nil || !bytes.Equal(pt, msg) {
+					t.Fatalf("%s: transport round trip failed: %v", tv.expected, err)
+				}
+			}
+		}
+	}
+}
+
+// TestHandshakeVectors pins the handshake hash and first transport message for fixed keys
+func TestHandshakeVectors(t *testing.T) {
+	vectors := []struct {
+		pattern   HandshakePattern
+		handshake string
+		transport string
+	}{
+		{PatternNN, "8165e02b7b1c0b6ed38ba2a47c33a6b6e1e9d4422367b1c7973c9bd5ea22a221", "b5728d24ef78e94c616422fde3a76117cb60e70bf8"},
+		{PatternXX, "9cccaa3d6157e6af9b39e1795b1a268b5786ddde860abf6523ed533852dc5485", "0bc690714632bee686bf259d46300e3b244925c091"},
+		{mustPSK(PatternIK, 2), "7dbe4b8f5931bf757c0129fdf8a79895fe1d27ed4bb8f20e7b34b955bd97aa72", "44e35eb0de019a1b855ff7e39c9a3a12df0382bd0b"},
+	}
+	for _, tv := range vectors {
+		init := Config{
+			Pattern:          tv.pattern,
+			Prologue:         []byte("vector"),
+			StaticKeypair:    fixedKey(0x11),
+			EphemeralKeypair: fixedKey(0x12),
+		}
+		resp := Config{
+			Pattern:          tv.pattern,
+			Prologue:         []byte("vector"),
+			StaticKeypair:    fixedKey(0x21),
+			EphemeralKeypair: fixedKey(0x22),
+		}
+		if tv.pattern.usesPSK() {
+			init.PresharedKey = bytes.Repeat([]byte{0x33}, KeyLen)
+			resp.PresharedKey = init.PresharedKey
+		}
+		if len(tv.pattern.ResponderPreMessages) > 0 {
+			init.PeerStatic = fixedKey(0x21).PublicKey().Bytes()
+		}
+
+		hsI, _, send, _ := handshake(t, init, resp)
+		if h := hex.EncodeToString(hsI.HandshakeHash()); h != tv.handshake {
+			t.Errorf("%s: expected handshake hash %s, got %s", tv.pattern.Name, tv.handshake, h)
+		}
+		ct, _ := send[0].EncryptWithAd(nil, nil, []byte("hello"))
+		if c := hex.EncodeToString(ct); c != tv.transport {
+			t.Errorf("%s: expected transport message %s, got %s", tv.pattern.Name, tv.transport, c)
+		}
+	}
+}
+
+// TestHandshakeTamper checks that modified messages and mismatched keys are rejected
+func TestHandshakeTamper(t *testing.T) {
+	rStatic := fixedKey(0x02)
+
+	init, _ := NewHandshakeState(Config{Pattern: PatternNK, Initiator: true, PeerStatic: rStatic.PublicKey().Bytes()})
+	resp, _ := NewHandshakeState(Config{Pattern: PatternNK, StaticKeypair: rStatic})
+	msg, _, _, err := init.WriteMessage(nil, []byte("payload"))
+	if err != nil {
+		t.Fatalf("WriteMessage failed: %v", err)
+	}
+	msg[len(msg)-1] ^= 1
+	if _, _, _, err := resp.ReadMessage(nil, msg); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Expected ErrDecrypt for tampered message, got %v", err)
+	}
+
+	// A wrong responder key is detected by the first payload tag
+	init, _ = NewHandshakeState(Config{Pattern: PatternNK, Initiator: true, PeerStatic: fixedKey(0x03).PublicKey().Bytes()})
+	resp, _ = NewHandshakeState(Config{Pattern: PatternNK, StaticKeypair: rStatic})
+	msg, _, _, _ = init.WriteMessage(nil, nil)
+	if _, _, _, err := resp.ReadMessage(nil, msg); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Expected ErrDecrypt for wrong static key, got %v", err)
+	}
+
+	// Mismatched pre-shared keys fail at the first message carrying a tag after the psk token
+	p := mustPSK(PatternNN, 0)
+	init, _ = NewHandshakeState(Config{Pattern: p, Initiator: true, PresharedKey: bytes.Repeat([]byte{1}, KeyLen)})
+	resp, _ = NewHandshakeState(Config{Pattern: p, PresharedKey: bytes.Repeat([]byte{2}, KeyLen)})
+	msg, _, _, _ = init.WriteMessage(nil, nil)
+	if _, _, _, err := resp.ReadMessage(nil, msg); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Expected ErrDecrypt for wrong pre-shared key, got %v", err)
+	}
+
+	if _, err := NewHandshakeState(Config{Pattern: p, Initiator: true}); err == nil {
+		t.Error("Expected error for missing pre-shared key")
+	}
+	if _, err := NewHandshakeState(Config{Pattern: PatternIK, Initiator: true, StaticKeypair: fixedKey(1)}); err == nil {
+		t.Error("Expected error for missing pre-message key")
+	}
+
+	// Messages must alternate
+	init, _ = NewHandshakeState(Config{Pattern: PatternNN, Initiator: true})
+	if _, _, _, err := init.ReadMessage(nil, make([]byte, 64)); err == nil {
+		t.Error("Expected error when reading out of turn")
+	}
+}
+
+// TestWithPSKPlacement verifies that out-of-range psk placements are rejected
+func TestWithPSKPlacement(t *testing.T) {
+	for _, n := range []int{-1, 4, 100} {
+		if _, err := WithPSK(PatternXX, n); err == nil {
+			t.Errorf("WithPSK(XX, %d) succeeded", n)
+		}
+	}
+	if _, err := WithPSK(HandshakePattern{Name: "empty"}, 0); err == nil {
+		t.Error("WithPSK accepted a pattern without messages")
+	}
+	p, err := WithPSK(PatternXX, 0, 3)
+	if err != nil || p.Name != "XXpsk0+psk3" {
+		t.Errorf("WithPSK(XX, 0, 3) = %q, %v", p.Name, err)
+	}
+}
+
+// TestCipherState checks nonce exhaustion and rekeying
+func TestCipherState(t *testing.T) {
+	var a, b CipherState
+	key := bytes.Repeat([]byte{7}, KeyLen)
+	a.InitializeKey(key)
+	b.InitializeKey(key)
+
+	a.Rekey()
+	b.Rekey()
+	ct, _ := a.EncryptWithAd(nil, []byte("ad"), []byte("after rekey"))
+	if _, err := b.DecryptWithAd(nil, []byte("ad"), ct); err != nil {
+		t.Fatalf("DecryptWithAd after rekey failed: %v", err)
+	}
+	if a.Nonce() != 1 || b.Nonce() != 1 {
+		t.Errorf("Expected nonce 1 after one message, got %d and %d", a.Nonce(), b.Nonce())
+	}
+
+	a.SetNonce(maxNonce)
+	if _, err := a.EncryptWithAd(nil, nil, nil); !errors.Is(err, ErrNonceExhausted) {
+		t.Errorf("Expected ErrNonceExhausted, got %v", err)
+	}
+
+	var empty CipherState
+	out, err := empty.EncryptWithAd(nil, nil, []byte("plain"))
+	if err != nil || string(out) != "plain" {
+		t.Errorf("Expected pass-through without a key, got %q, %v", out, err)
+	}
+
+	if _, err := a.EncryptWithAd(nil, nil, make([]byte, MaxMessageLen)); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("Expected ErrMessageTooLarge, got %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package noise
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Token is one step of a handshake message pattern
+type Token int
+
+// Message pattern tokens
+const (
+	TokenE Token = iota
+	TokenS
+	TokenEE
+	TokenES
+	TokenSE
+	TokenSS
+	TokenPSK
+)
+
+// HandshakePattern describes the pre-messages and messages of a Noise handshake
+type HandshakePattern struct {
+	Name                 string
+	InitiatorPreMessages []Token
+	ResponderPreMessages []Token
+	Messages             [][]Token
+}
+
+// Supported interactive patterns
+var (
+	PatternNN = HandshakePattern{
+		Name: "NN",
+		Messages: [][]Token{
+			{TokenE},
+			{TokenE, TokenEE},
+		},
+	}
+
+	PatternNK = HandshakePattern{
+		Name:                 "NK",
+		ResponderPreMessages: []Token{TokenS},
+		Messages: [][]Token{
+			{TokenE, TokenES},
+			{TokenE, TokenEE},
+		},
+	}
+
+	PatternXX = HandshakePattern{
+		Name: "XX",
+		Messages: [][]Token{
+			{TokenE},
+			{TokenE, TokenEE, TokenS, TokenES},
+			{TokenS, TokenSE},
+		},
+	}
+
+	PatternIK = HandshakePattern{
+		Name:                 "IK",
+		ResponderPreMessages: []Token{TokenS},
+		Messages: [][]Token{
+			{TokenE, TokenES, TokenS, TokenSS},
+			{TokenE, TokenEE, TokenSE},
+		},
+	}
+)
+
+// WithPSK returns p with psk modifiers applied, e.g. WithPSK(PatternXX, 3) is XXpsk3
+// Placement 0 puts a psk token at the start of the first message, and placement i puts
+// one at the end of message i. Placements outside 0..len(p.Messages) are an error.
+func WithPSK(p HandshakePattern, placements ...int) (HandshakePattern, error) {
+	out := HandshakePattern{
+		Name:                 p.Name,
+		InitiatorPreMessages: p.InitiatorPreMessages,
+		ResponderPreMessages: p.ResponderPreMessages,
+		Messages:             make([][]Token, len(p.Messages)),
+	}
+	for i, m := range p.Messages {
+		out.Messages[i] = append([]Token{}, m...)
+	}
+
+	mods := make([]string, 0, len(placements))
+	for _, n := range placements {
+		switch {
+		case n < 0 || n > len(out.Messages) || len(out.Messages) == 0:
+			return HandshakePattern{}, errors.New("noise: psk placement outside the pattern")
+		case n == 0:
+			out.Messages[0] = append([]Token{TokenPSK}, out.Messages[0]...)
+		default:
+			out.Messages[n-1] = append(out.Messages[n-1], TokenPSK)
+		}
+		mods = append(mods, "psk"+strconv.Itoa(n))
+	}
+	out.Name += strings.Join(mods, "+")
+
+	return out, nil
+}
+
+// usesPSK reports whether any message contains a psk token
+func (p *HandshakePattern) usesPSK() bool {
+	for _, m := range p.Messages {
+		for _, t := range m {
+			if t == TokenPSK {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,397 @@
+package noise
+
+import (
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+)
+
+// SymmetricState holds the chaining key and handshake hash of a handshake in progress
+type SymmetricState struct {
+	cs CipherState
+	ck [HashLen]byte
+	h  [HashLen]byte
+}
+
+// InitializeSymmetric starts a symmetric state for the given full protocol name
+func (s *SymmetricState) InitializeSymmetric(protocolName string) {
+	if len(protocolName) <= HashLen {
+		s.h = [HashLen]byte{}
+		copy(s.h[:], protocolName)
+	} else {
+		s.h = sha256.Sum256([]byte(protocolName))
+	}
+	s.ck = s.h
+	s.cs = CipherState{}
+}
+
+// hkdf derives two 32-byte outputs from the chaining key and input keying material
+func (s *SymmetricState) hkdf(ikm []byte, n int) [][HashLen]byte {
+	okm, err := hkdf.Key(sha256.New, ikm, s.ck[:], "", n*HashLen)
+	if err != nil {
+		panic("noise: hkdf failed: " + err.Error())
+	}
+	out := make([][HashLen]byte, n)
+	for i := range out {
+		copy(out[i][:], okm[i*HashLen:])
+	}
+	return out
+}
+
+// MixKey mixes input keying material into the chaining key and sets a new cipher key
+func (s *SymmetricState) MixKey(ikm []byte) {
+	out := s.hkdf(ikm, 2)
+	s.ck = out[0]
+	s.cs.InitializeKey(out[1][:])
+}
+
+// MixHash mixes data into the handshake hash
+func (s *SymmetricState) MixHash(data []byte) {
+	h := sha256.New()
+	h.Write(s.h[:])
+	h.Write(data)
+	h.Sum(s.h[:0])
+}
+
+// MixKeyAndHash mixes a pre-shared key into both the chaining key and the handshake hash
+func (s *SymmetricState) MixKeyAndHash(ikm []byte) {
+	out := s.hkdf(ikm, 3)
+	s.ck = out[0]
+	s.MixHash(out[1][:])
+	s.cs.InitializeKey(out[2][:])
+}
+
+// HandshakeHash returns the current handshake hash
+func (s *SymmetricState) HandshakeHash() []byte {
+	return append([]byte{}, s.h[:]...)
+}
+
+// EncryptAndHash encrypts plaintext with the handshake hash as associated data and mixes the result into the hash
+func (s *SymmetricState) EncryptAndHash(out, plaintext []byte) ([]byte, error) {
+	start := len(out)
+	out, err := s.cs.EncryptWithAd(out, s.h[:], plaintext)
+	if err != nil {
+		return nil, err
+	}
+	s.MixHash(out[start:])
+	return out, nil
+}
+
+// DecryptAndHash decrypts ciphertext with the handshake hash as associated data and mixes the ciphertext into the hash
+func (s *SymmetricState) DecryptAndHash(out, ciphertext []byte) ([]byte, error) {
+	out, err := s.cs.DecryptWithAd(out, s.h[:], ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	s.MixHash(ciphertext)
+	return out, nil
+}
+
+// Split returns the two transport CipherStates, for initiator-to-responder and responder-to-initiator traffic
+func (s *SymmetricState) Split() (*CipherState, *CipherState) {
+	out := s.hkdf(nil, 2)
+	c1, c2 := &CipherState{}, &CipherState{}
+	c1.InitializeKey(out[0][:])
+	c2.InitializeKey(out[1][:])
+	return c1, c2
+}
+
+// Config describes one party of a handshake
+type Config struct {
+	Pattern   HandshakePattern
+	Initiator bool
+	Prologue  []byte
+
+	// StaticKeypair is the local static key, required by patterns that send or pre-share it
+	StaticKeypair *ecdh.PrivateKey
+	// EphemeralKeypair fixes the local ephemeral key; it is normally left nil and generated
+	EphemeralKeypair *ecdh.PrivateKey
+	// PeerStatic is the remote static public key known before the handshake, if any
+	PeerStatic []byte
+	// PresharedKey is the 32-byte key mixed in at every psk token
+	PresharedKey []byte
+
+	// Random is the source for ephemeral keys. It defaults to crypto/rand.Reader.
+	Random io.Reader
+}
+
+// HandshakeState runs a Noise handshake
+type HandshakeState struct {
+	ss        SymmetricState
+	pattern   HandshakePattern
+	initiator bool
+	psk       []byte
+	random    io.Reader
+
+	s  *ecdh.PrivateKey
+	e  *ecdh.PrivateKey
+	rs *ecdh.PublicKey
+	re *ecdh.PublicKey
+
+	msgIdx int
+	done   bool
+}
+
+// ProtocolName returns the full Noise protocol name of a pattern
+func ProtocolName(p HandshakePattern) string {
+	return "Noise_" + p.Name + "_25519_HiAE_SHA256"
+}
+
+// GenerateKeypair returns a new X25519 key pair read from random
+func GenerateKeypair(random io.Reader) (*ecdh.PrivateKey, error) {
+	if random == nil {
+		random = rand.Reader
+	}
+	var seed [DHLen]byte
+	if _, err := io.ReadFull(random, seed[:]); err != nil {
+		return nil, err
+	}
+	return ecdh.X25519().NewPrivateKey(seed[:])
+}
+
+// NewHandshakeState initialises a handshake, mixing in the prologue and pre-message keys
+func NewHandshakeState(cfg Config) (*HandshakeState, error) {
+	hs := &HandshakeState{
+		pattern:   cfg.Pattern,
+		initiator: cfg.Initiator,
+		s:         cfg.StaticKeypair,
+		e:         cfg.EphemeralKeypair,
+		random:    cfg.Random,
+	}
+	if len(cfg.Pattern.Messages) == 0 {
+		return nil, errors.New("noise: pattern has no messages")
+	}
+	if cfg.PeerStatic != nil {
+		rs, err := ecdh.X25519().NewPublicKey(cfg.PeerStatic)
+		if err != nil {
+			return nil, err
+		}
+		hs.rs = rs
+	}
+	if cfg.Pattern.usesPSK() {
+		if len(cfg.PresharedKey) != KeyLen {
+			return nil, errors.New("noise: pre-shared key must be 32 bytes")
+		}
+		hs.psk = append([]byte{}, cfg.PresharedKey...)
+	}
+
+	hs.ss.InitializeSymmetric(ProtocolName(cfg.Pattern))
+	hs.ss.MixHash(cfg.Prologue)
+
+	// Pre-messages are always processed initiator first
+	for _, pre := range []struct {
+		tokens []Token
+		local  bool
+	}{
+		{cfg.Pattern.InitiatorPreMessages, cfg.Initiator},
+		{cfg.Pattern.ResponderPreMessages, !cfg.Initiator},
+	} {
+		for _, t := range pre.tokens {
+			var pub *ecdh.PublicKey
+			switch {
+			case t == TokenS && pre.local && hs.s != nil:
+				pub = hs.s.PublicKey()
+			case t == TokenS && !pre.local:
+				pub = hs.rs
+			case t == TokenE && pre.local && hs.e != nil:
+				pub = hs.e.PublicKey()
+			case t == TokenE && !pre.local:
+				pub = hs.re
+			}
+			if pub == nil {
+				return nil, errors.New("noise: missing pre-message key")
+			}
+			hs.ss.MixHash(pub.Bytes())
+			if t == TokenE && hs.psk != nil {
+				hs.ss.MixKey(pub.Bytes())
+			}
+		}
+	}
+
+	return hs, nil
+}
+
+// dh performs X25519 between a local private key and a remote public key
+func dh(priv *ecdh.PrivateKey, pub *ecdh.PublicKey) ([]byte, error) {
+	if priv == nil || pub == nil {
+		return nil, errors.New("noise: missing key for DH")
+	}
+	return priv.ECDH(pub)
+}
+
+// mixDH mixes the DH result for a two-letter token into the chaining key
+func (hs *HandshakeState) mixDH(t Token) error {
+	var local *ecdh.PrivateKey
+	var remote *ecdh.PublicKey
+	switch t {
+	case TokenEE:
+		local, remote = hs.e, hs.re
+	case TokenSS:
+		local, remote = hs.s, hs.rs
+	case TokenES:
+		if hs.initiator {
+			local, remote = hs.e, hs.rs
+		} else {
+			local, remote = hs.s, hs.re
+		}
+	case TokenSE:
+		if hs.initiator {
+			local, remote = hs.s, hs.re
+		} else {
+			local, remote = hs.e, hs.rs
+		}
+	}
+	shared, err := dh(local, remote)
+	if err != nil {
+		return err
+	}
+	hs.ss.MixKey(shared)
+	return nil
+}
+
+// myTurn reports whether the local party writes the next message
+func (hs *HandshakeState) myTurn() bool {
+	return (hs.msgIdx%2 == 0) == hs.initiator
+}
+
+// finish advances to the next message and splits once the handshake is complete
+func (hs *HandshakeState) finish() (*CipherState, *CipherState) {
+	hs.msgIdx++
+	if hs.msgIdx < len(hs.pattern.Messages) {
+		return nil, nil
+	}
+	hs.done = true
+	return hs.ss.Split()
+}
+
+// WriteMessage appends the next handshake message carrying payload to out
+// When the handshake completes it also returns the initiator-to-responder and
+// responder-to-initiator CipherStates; otherwise both are nil.
+func (hs *HandshakeState) WriteMessage(out, payload []byte) ([]byte, *CipherState, *CipherState, error) {
+	if hs.done {
+		return nil, nil, nil, errors.New("noise: handshake already complete")
+	}
+	if !hs.myTurn() {
+		return nil, nil, nil, errors.New("noise: not our turn to write")
+	}
+
+	start := len(out)
+	var err error
+	for _, t := range hs.pattern.Messages[hs.msgIdx] {
+		switch t {
+		case TokenE:
+			if hs.e == nil {
+				if hs.e, err = GenerateKeypair(hs.random); err != nil {
+					return nil, nil, nil, err
+				}
+			}
+			pub := hs.e.PublicKey().Bytes()
+			out = append(out, pub...)
+			hs.ss.MixHash(pub)
+			if hs.psk != nil {
+				hs.ss.MixKey(pub)
+			}
+		case TokenS:
+			if hs.s == nil {
+				return nil, nil, nil, errors.New("noise: missing static key")
+			}
+			if out, err = hs.ss.EncryptAndHash(out, hs.s.PublicKey().Bytes()); err != nil {
+				return nil, nil, nil, err
+			}
+		case TokenPSK:
+			hs.ss.MixKeyAndHash(hs.psk)
+		default:
+			if err := hs.mixDH(t); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+	}
+
+	if out, err = hs.ss.EncryptAndHash(out, payload); err != nil {
+		return nil, nil, nil, err
+	}
+	if len(out)-start > MaxMessageLen {
+		return nil, nil, nil, ErrMessageTooLarge
+	}
+
+	c1, c2 := hs.finish()
+	return out, c1, c2, nil
+}
+
+// ReadMessage processes the next handshake message and appends its payload to out
+// When the handshake completes it also returns the initiator-to-responder and
+// responder-to-initiator CipherStates; otherwise both are nil.
+func (hs *HandshakeState) ReadMessage(out, message []byte) ([]byte, *CipherState, *CipherState, error) {
+	if hs.done {
+		return nil, nil, nil, errors.New("noise: handshake already complete")
+	}
+	if hs.myTurn() {
+		return nil, nil, nil, errors.New("noise: not our turn to read")
+	}
+	if len(message) > MaxMessageLen {
+		return nil, nil, nil, ErrMessageTooLarge
+	}
+
+	var err error
+	for _, t := range hs.pattern.Messages[hs.msgIdx] {
+		switch t {
+		case TokenE:
+			if len(message) < DHLen {
+				return nil, nil, nil, errors.New("noise: message too short")
+			}
+			if hs.re, err = ecdh.X25519().NewPublicKey(message[:DHLen]); err != nil {
+				return nil, nil, nil, err
+			}
+			hs.ss.MixHash(message[:DHLen])
+			if hs.psk != nil {
+				hs.ss.MixKey(message[:DHLen])
+			}
+			message = message[DHLen:]
+		case TokenS:
+			n := DHLen
+			if hs.ss.cs.HasKey() {
+				n += TagLen
+			}
+			if len(message) < n {
+				return nil, nil, nil, errors.New("noise: message too short")
+			}
+			pub, err := hs.ss.DecryptAndHash(nil, message[:n])
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			if hs.rs, err = ecdh.X25519().NewPublicKey(pub); err != nil {
+				return nil, nil, nil, err
+			}
+			message = message[n:]
+		case TokenPSK:
+			hs.ss.MixKeyAndHash(hs.psk)
+		default:
+			if err := hs.mixDH(t); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+	}
+
+	if out, err = hs.ss.DecryptAndHash(out, message); err != nil {
+		return nil, nil, nil, err
+	}
+
+	c1, c2 := hs.finish()
+	return out, c1, c2, nil
+}
+
+// HandshakeHash returns the handshake hash, usable for channel binding once the handshake is complete
+func (hs *HandshakeState) HandshakeHash() []byte {
+	return hs.ss.HandshakeHash()
+}
+
+// PeerStatic returns the remote static public key, if known
+func (hs *HandshakeState) PeerStatic() []byte {
+	if hs.rs == nil {
+		return nil
+	}
+	return hs.rs.Bytes()
+}
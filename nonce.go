@@ -0,0 +1,296 @@
+package hiae
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// NonceSource generates unique nonces for use with a single key
+// Implementations are safe for concurrent use and never return the same nonce twice.
+type NonceSource interface {
+	// Next writes the next nonce into dst, which must be at least NonceLen bytes.
+	// Once the source is exhausted it returns a *NonceExhaustedError.
+	Next(dst []byte) error
+}
+
+// NonceExhaustedError is returned by a NonceSource that cannot produce another unique nonce
+type NonceExhaustedError struct {
+	Source string // Kind of source that ran out, e.g. "counter"
+}
+
+func (e *NonceExhaustedError) Error() string {
+	return "hiae: " + e.Source + " nonce source exhausted"
+}
+
+// MaxRandomNonces is the number of nonces a RandomNonceSource issues before reporting exhaustion
+// It keeps the probability of a collision among random 128-bit nonces below 2^-32.
+const MaxRandomNonces = 1 << 48
+
+// DefaultNonceReserve is the number of counter values reserved by each write to a NonceStore
+const DefaultNonceReserve = 1 << 16
+
+// NonceStore persists the high-water mark of a CounterNonceSource
+type NonceStore interface {
+	// Load returns the last saved high-water mark, or nil if none was saved yet.
+	Load() ([]byte, error)
+	// Save durably records a new high-water mark of NonceLen bytes.
+	Save(hw []byte) error
+}
+
+// CounterNonceSource issues nonces made of a fixed prefix followed by a big-endian counter
+type CounterNonceSource struct {
+	mu        sync.Mutex
+	prefixLen int
+	next      [NonceLen]byte // Next nonce to issue
+	exhausted bool
+
+	store   NonceStore
+	reserve uint64
+	limit   [NonceLen]byte // Nonces below limit are covered by the saved high-water mark
+	atEnd   bool           // The saved high-water mark covers the whole counter space
+}
+
+// NewCounterNonce returns a source that counts through the full 128-bit nonce space starting at zero
+// If store is not nil, the counter resumes from the stored high-water mark.
+func NewCounterNonce(store NonceStore) (*CounterNonceSource, error) {
+	return NewPrefixCounterNonce(nil, store)
+}
+
+// NewPrefixCounterNonce returns a source whose nonces start with prefix and end with a counter
+// Writers sharing a key can use distinct prefixes to split the nonce space between them.
+// The prefix must leave at least one byte for the counter.
+// If store is not nil, the counter resumes from the stored high-water mark.
+func NewPrefixCounterNonce(prefix []byte, store NonceStore) (*CounterNonceSource, error) {
+	if len(prefix) >= NonceLen {
+		return nil, errors.New("nonce prefix must be shorter than 16 bytes")
+	}
+
+	s := &CounterNonceSource{
+		prefixLen: len(prefix),
+		store:     store,
+		reserve:   DefaultNonceReserve,
+	}
+	copy(s.next[:], prefix)
+
+	if store != nil {
+		hw, err := store.Load()
+		if err != nil {
+			return nil, err
+		}
+		if hw != nil {
+			if len(hw) != NonceLen || !bytes.Equal(hw[:len(prefix)], prefix) {
+				return nil, errors.New("stored nonce high-water mark does not match prefix")
+			}
+			copy(s.next[:], hw)
+			// An all-ones counter is only saved once the whole space has been reserved
+			if isAllOnes(hw[len(prefix):]) {
+				s.exhausted = true
+			}
+		}
+		s.limit = s.next
+	}
+
+	return s, nil
+}
+
+// SetReserve sets how many counter values each store write reserves ahead
+// Larger values mean fewer writes, at the cost of skipping up to that many nonces after a restart.
+func (s *CounterNonceSource) SetReserve(n uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n == 0 {
+		n = 1
+	}
+	s.reserve = n
+}
+
+// Next writes the next counter nonce into dst
+func (s *CounterNonceSource) Next(dst []byte) error {
+	if len(dst) < NonceLen {
+		return errors.New("nonce output buffer too small")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.exhausted {
+		return &NonceExhaustedError{Source: "counter"}
+	}
+
+	if s.store != nil && !s.atEnd && bytes.Compare(s.next[:], s.limit[:]) >= 0 {
+		limit := s.next
+		if addCounter(limit[s.prefixLen:], s.reserve) {
+			// Reserve the rest of the space; the all-ones value marks it as used up on reload
+			for i := s.prefixLen; i < NonceLen; i++ {
+				limit[i] = 0xff
+			}
+			s.atEnd = true
+		}
+		if err := s.store.Save(limit[:]); err != nil {
+			s.atEnd = false
+			return err
+		}
+		s.limit = limit
+	}
+
+	copy(dst, s.next[:])
+	if isAllOnes(s.next[s.prefixLen:]) {
+		s.exhausted = true
+	} else {
+		addCounter(s.next[s.prefixLen:], 1)
+	}
+
+	return nil
+}
+
+// addCounter adds n to a big-endian counter in place and reports whether it overflowed
+func addCounter(ctr []byte, n uint64) bool {
+	carry := n
+	for i := len(ctr) - 1; i >= 0 && carry > 0; i-- {
+		sum := uint64(ctr[i]) + (carry & 0xff)
+		ctr[i] = byte(sum)
+		carry = (carry >> 8) + (sum >> 8)
+	}
+	return carry > 0
+}
+
+// isAllOnes reports whether every byte of b is 0xff
+func isAllOnes(b []byte) bool {
+	for _, x := range b {
+		if x != 0xff {
+			return false
+		}
+	}
+	return true
+}
+
+// TimestampNonceSource issues nonces made of a 64-bit big-endian timestamp in nanoseconds followed by 64 random bits
+// Timestamps are strictly increasing even if the clock goes backwards.
+type TimestampNonceSource struct {
+	mu        sync.Mutex
+	last      uint64
+	exhausted bool
+
+	// Now returns the current time. It defaults to time.Now.
+	Now func() time.Time
+	// Rand is the source of the random half. It defaults to crypto/rand.Reader.
+	Rand io.Reader
+}
+
+// NewTimestampNonce returns a timestamp-plus-random nonce source using the system clock
+func NewTimestampNonce() *TimestampNonceSource {
+	return &TimestampNonceSource{}
+}
+
+// Next writes the next timestamp nonce into dst
+func (s *TimestampNonceSource) Next(dst []byte) error {
+	if len(dst) < NonceLen {
+		return errors.New("nonce output buffer too small")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.exhausted {
+		return &NonceExhaustedError{Source: "timestamp"}
+	}
+
+	now := time.Now
+	if s.Now != nil {
+		now = s.Now
+	}
+	ts := uint64(now().UnixNano())
+	if ts <= s.last {
+		if s.last == ^uint64(0) {
+			s.exhausted = true
+			return &NonceExhaustedError{Source: "timestamp"}
+		}
+		ts = s.last + 1
+	}
+
+	r := s.Rand
+	if r == nil {
+		r = rand.Reader
+	}
+	if _, err := io.ReadFull(r, dst[8:NonceLen]); err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint64(dst[:8], ts)
+	s.last = ts
+
+	return nil
+}
+
+// RandomNonceSource issues uniformly random 128-bit nonces, up to MaxRandomNonces of them
+type RandomNonceSource struct {
+	mu    sync.Mutex
+	count uint64
+
+	// Rand is the source of randomness. It defaults to crypto/rand.Reader.
+	Rand io.Reader
+	// Limit overrides MaxRandomNonces when non-zero.
+	Limit uint64
+}
+
+// NewRandomNonce returns a random nonce source backed by crypto/rand
+func NewRandomNonce() *RandomNonceSource {
+	return &RandomNonceSource{}
+}
+
+// Next writes a fresh random nonce into dst
+func (s *RandomNonceSource) Next(dst []byte) error {
+	if len(dst) < NonceLen {
+		return errors.New("nonce output buffer too small")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	limit := uint64(MaxRandomNonces)
+	if s.Limit != 0 {
+		limit = s.Limit
+	}
+	if s.count >= limit {
+		return &NonceExhaustedError{Source: "random"}
+	}
+
+	r := s.Rand
+	if r == nil {
+		r = rand.Reader
+	}
+	if _, err := io.ReadFull(r, dst[:NonceLen]); err != nil {
+		return err
+	}
+	s.count++
+
+	return nil
+}
+
+// EncryptToWithSource draws a nonce from src into nonceOut and encrypts like EncryptTo
+func EncryptToWithSource(src NonceSource, msg, ad, key, nonceOut, ctOut, tagOut []byte) error {
+	if len(nonceOut) < NonceLen {
+		return errors.New("nonce output buffer too small")
+	}
+	if err := src.Next(nonceOut); err != nil {
+		return err
+	}
+	return EncryptTo(msg, ad, key, nonceOut[:NonceLen], ctOut, tagOut)
+}
+
+// EncryptWithSource draws a nonce from src and encrypts like Encrypt, returning the nonce alongside the output
+func EncryptWithSource(src NonceSource, msg, ad, key []byte) (nonce, ct, tag []byte, err error) {
+	nonce = make([]byte, NonceLen)
+	if err := src.Next(nonce); err != nil {
+		return nil, nil, nil, err
+	}
+	ct, tag, err = Encrypt(msg, ad, key, nonce)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return nonce, ct, tag, nil
+}
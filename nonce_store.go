@@ -0,0 +1,74 @@
+package hiae
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// MemoryNonceStore keeps the high-water mark in memory, mainly for tests
+type MemoryNonceStore struct {
+	mu sync.Mutex
+	hw []byte
+}
+
+// Load returns the saved high-water mark, or nil if none was saved
+func (m *MemoryNonceStore) Load() ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.hw == nil {
+		return nil, nil
+	}
+	return append([]byte{}, m.hw...), nil
+}
+
+// Save records a new high-water mark
+func (m *MemoryNonceStore) Save(hw []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hw = append(m.hw[:0], hw...)
+	return nil
+}
+
+// FileNonceStore keeps the high-water mark in a file, replaced atomically on every save
+type FileNonceStore struct {
+	Path string
+}
+
+// Load reads the high-water mark, returning nil if the file does not exist yet
+func (f *FileNonceStore) Load() ([]byte, error) {
+	hw, err := os.ReadFile(f.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(hw) != NonceLen {
+		return nil, errors.New("nonce store file is corrupt")
+	}
+	return hw, nil
+}
+
+// Save writes the high-water mark to a temporary file, syncs it and renames it over Path
+func (f *FileNonceStore) Save(hw []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(f.Path), filepath.Base(f.Path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(hw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.Path)
+}
@@ -0,0 +1,163 @@
+package hiae
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestCounterNonce verifies counter sequencing and exhaustion of a one-byte counter
+func TestCounterNonce(t *testing.T) {
+	src, err := NewCounterNonce(nil)
+	if err != nil {
+		t.Fatalf("NewCounterNonce failed: %v", err)
+	}
+	nonce := make([]byte, NonceLen)
+	for i := 0; i < 3; i++ {
+		if err := src.Next(nonce); err != nil {
+			t.Fatalf("Next failed: %v", err)
+		}
+	}
+	if hexEncode(nonce) != "00000000000000000000000000000002" {
+		t.Errorf("Unexpected third nonce %s", hexEncode(nonce))
+	}
+
+	prefix := hexDecode("0102030405060708090a0b0c0d0e0f")
+	src, err = NewPrefixCounterNonce(prefix, nil)
+	if err != nil {
+		t.Fatalf("NewPrefixCounterNonce failed: %v", err)
+	}
+	for i := 0; i < 256; i++ {
+		if err := src.Next(nonce); err != nil {
+			t.Fatalf("Next %d failed: %v", i, err)
+		}
+		if !bytes.Equal(nonce[:15], prefix) || nonce[15] != byte(i) {
+			t.Fatalf("Unexpected nonce %s", hexEncode(nonce))
+		}
+	}
+
+	var exhausted *NonceExhaustedError
+	if err := src.Next(nonce); !errors.As(err, &exhausted) {
+		t.Fatalf("Expected NonceExhaustedError, got %v", err)
+	}
+
+	if _, err := NewPrefixCounterNonce(make([]byte, NonceLen), nil); err == nil {
+		t.Error("Expected error for prefix without room for a counter")
+	}
+}
+
+// TestCounterNoncePersistence verifies that a restarted source never reuses a nonce
+func TestCounterNoncePersistence(t *testing.T) {
+	store := &FileNonceStore{Path: filepath.Join(t.TempDir(), "nonce.hw")}
+	prefix := []byte{0xaa, 0xbb}
+
+	issued := make(map[string]bool)
+	for run := 0; run < 3; run++ {
+		src, err := NewPrefixCounterNonce(prefix, store)
+		if err != nil {
+			t.Fatalf("Run %d: NewPrefixCounterNonce failed: %v", run, err)
+		}
+		src.SetReserve(4)
+		nonce := make([]byte, NonceLen)
+		for i := 0; i < 10; i++ {
+			if err := src.Next(nonce); err != nil {
+				t.Fatalf("Run %d: Next failed: %v", run, err)
+			}
+			if issued[string(nonce)] {
+				t.Fatalf("Run %d: nonce %s reused", run, hexEncode(nonce))
+			}
+			issued[string(nonce)] = true
+		}
+	}
+
+	if _, err := NewPrefixCounterNonce([]byte{0xcc}, store); err == nil {
+		t.Error("Expected error for stored mark with a different prefix")
+	}
+
+	// A store that has reserved the whole space reports exhaustion after restart
+	mem := &MemoryNonceStore{}
+	shortPrefix := make([]byte, NonceLen-1)
+	src, _ := NewPrefixCounterNonce(shortPrefix, mem)
+	src.SetReserve(1000)
+	nonce := make([]byte, NonceLen)
+	if err := src.Next(nonce); err != nil {
+		t.Fatalf("Next failed: %v", err)
+	}
+	src, _ = NewPrefixCounterNonce(shortPrefix, mem)
+	var exhausted *NonceExhaustedError
+	if err := src.Next(nonce); !errors.As(err, &exhausted) {
+		t.Fatalf("Expected NonceExhaustedError after restart, got %v", err)
+	}
+}
+
+// TestTimestampNonce verifies that timestamps stay strictly increasing
+func TestTimestampNonce(t *testing.T) {
+	fixed := time.Unix(1700000000, 0)
+	src := &TimestampNonceSource{
+		Now:  func() time.Time { return fixed },
+		Rand: bytes.NewReader(bytes.Repeat([]byte{0x5a}, 64)),
+	}
+
+	var prev []byte
+	for i := 0; i < 3; i++ {
+		nonce := make([]byte, NonceLen)
+		if err := src.Next(nonce); err != nil {
+			t.Fatalf("Next failed: %v", err)
+		}
+		if prev != nil && bytes.Compare(nonce[:8], prev[:8]) <= 0 {
+			t.Fatalf("Timestamp did not increase: %s after %s", hexEncode(nonce), hexEncode(prev))
+		}
+		if !bytes.Equal(nonce[8:], bytes.Repeat([]byte{0x5a}, 8)) {
+			t.Fatalf("Unexpected random half %s", hexEncode(nonce[8:]))
+		}
+		prev = nonce
+	}
+
+	src.last = ^uint64(0)
+	var exhausted *NonceExhaustedError
+	if err := src.Next(make([]byte, NonceLen)); !errors.As(err, &exhausted) {
+		t.Fatalf("Expected NonceExhaustedError, got %v", err)
+	}
+}
+
+// TestRandomNonceLimit verifies that the random source stops at its limit
+func TestRandomNonceLimit(t *testing.T) {
+	src := &RandomNonceSource{Limit: 2}
+	nonce := make([]byte, NonceLen)
+	for i := 0; i < 2; i++ {
+		if err := src.Next(nonce); err != nil {
+			t.Fatalf("Next failed: %v", err)
+		}
+	}
+	var exhausted *NonceExhaustedError
+	if err := src.Next(nonce); !errors.As(err, &exhausted) {
+		t.Fatalf("Expected NonceExhaustedError, got %v", err)
+	}
+}
+
+// TestEncryptWithSource verifies that sourced nonces round-trip through Decrypt
+func TestEncryptWithSource(t *testing.T) {
+	key := make([]byte, KeyLen)
+	src, _ := NewCounterNonce(&MemoryNonceStore{})
+	msg := []byte("nonce source")
+
+	nonce, ct, tag, err := EncryptWithSource(src, msg, nil, key)
+	if err != nil {
+		t.Fatalf("EncryptWithSource failed: %v", err)
+	}
+	pt, err := Decrypt(ct, tag, nil, key, nonce)
+	if err != nil || !bytes.Equal(pt, msg) {
+		t.Fatalf("Decrypt failed: %v", err)
+	}
+
+	nonce2 := make([]byte, NonceLen)
+	ct2 := make([]byte, len(msg))
+	if err := EncryptToWithSource(src, msg, nil, key, nonce2, ct2, tag); err != nil {
+		t.Fatalf("EncryptToWithSource failed: %v", err)
+	}
+	if bytes.Equal(nonce, nonce2) {
+		t.Fatal("Nonce reused")
+	}
+}
@@ -0,0 +1,403 @@
+// Package oscore implements the OSCORE security context (RFC 8613) with HiAE as the AEAD
+//
+// The context derives sender and recipient keys and the Common IV with HKDF-SHA256 from a
+// master secret, as in RFC 8613 Section 3.2, using the COSE algorithm identifier
+// cose.AlgHiAE. Nonces follow Section 5.2 scaled to HiAE's 16-byte nonce, which leaves room
+// for Sender IDs of up to 10 bytes. The plaintext given to Protect is the OSCORE plaintext,
+// that is the CoAP code, Class E options, payload marker and payload, already serialised by
+// the caller; this package only handles the OSCORE option value and the COSE ciphertext.
+package oscore
+
+import (
+	"bytes"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"errors"
+	"sync"
+
+	hiae "github.com/hiae-aead/go-hiae"
+	"github.com/hiae-aead/go-hiae/cbor"
+	"github.com/hiae-aead/go-hiae/cose"
+	"github.com/hiae-aead/go-hiae/datagram"
+)
+
+const (
+	// MaxIDLen is the longest Sender or Recipient ID, nonce length minus 6
+	MaxIDLen = hiae.NonceLen - 6
+	// MaxSequenceNumber is the largest sender sequence number, limited by the 5-byte Partial IV
+	MaxSequenceNumber = 1<<40 - 1
+	// DefaultReplayWindow is the replay window size used when Config leaves it zero
+	DefaultReplayWindow = 64
+
+	oscoreVersion = 1
+	maxPIVLen     = 5
+)
+
+var (
+	// ErrSequenceExhausted is returned once the sender sequence number reaches MaxSequenceNumber
+	ErrSequenceExhausted = errors.New("oscore: sender sequence number exhausted")
+	// ErrBadOption is returned for malformed OSCORE option values
+	ErrBadOption = errors.New("oscore: malformed option")
+	// ErrUnknownContext is returned for requests whose kid or kid context does not match this context
+	ErrUnknownContext = errors.New("oscore: security context not found")
+	// ErrDecrypt is returned for messages that fail authentication
+	ErrDecrypt = errors.New("oscore: decryption failed")
+	// ErrReplay is returned for requests with a Partial IV that was already accepted or is too old
+	ErrReplay = errors.New("oscore: replayed message")
+)
+
+// Config holds the input parameters of a security context
+type Config struct {
+	MasterSecret []byte
+	MasterSalt   []byte // Optional
+	SenderID     []byte // At most MaxIDLen bytes; may be empty
+	RecipientID  []byte // At most MaxIDLen bytes; may be empty
+	IDContext    []byte // Optional
+
+	// ReplayWindow is the size of the recipient replay window. Zero selects DefaultReplayWindow.
+	ReplayWindow int
+}
+
+// Context is an OSCORE security context, safe for concurrent use
+type Context struct {
+	senderID    []byte
+	recipientID []byte
+	idContext   []byte
+	senderKey   [hiae.KeyLen]byte
+	recipKey    [hiae.KeyLen]byte
+	commonIV    [hiae.NonceLen]byte
+
+	mu     sync.Mutex
+	ssn    uint64
+	window *datagram.ReplayWindow
+}
+
+// derive runs the HKDF of RFC 8613 Section 3.2.1 for one output
+func derive(cfg *Config, id []byte, alg int64, typ string, length int) ([]byte, error) {
+	var idContext any
+	if cfg.IDContext != nil {
+		idContext = cfg.IDContext
+	}
+	info, err := cbor.Marshal([]any{id, idContext, alg, typ, int64(length)})
+	if err != nil {
+		return nil, err
+	}
+	return hkdf.Key(sha256.New, cfg.MasterSecret, cfg.MasterSalt, string(info), length)
+}
+
+// NewContext derives a security context from cfg
+func NewContext(cfg Config) (*Context, error) {
+	if len(cfg.MasterSecret) == 0 {
+		return nil, errors.New("oscore: master secret is required")
+	}
+	if len(cfg.SenderID) > MaxIDLen || len(cfg.RecipientID) > MaxIDLen {
+		return nil, errors.New("oscore: sender and recipient IDs must be at most 10 bytes")
+	}
+	if bytes.Equal(cfg.SenderID, cfg.RecipientID) {
+		return nil, errors.New("oscore: sender and recipient IDs must differ")
+	}
+
+	c := &Context{
+		senderID:    append([]byte{}, cfg.SenderID...),
+		recipientID: append([]byte{}, cfg.RecipientID...),
+		idContext:   append([]byte(nil), cfg.IDContext...),
+	}
+	for _, out := range []struct {
+		dst    []byte
+		id     []byte
+		typ    string
+		length int
+	}{
+		{c.senderKey[:], cfg.SenderID, "Key", hiae.KeyLen},
+		{c.recipKey[:], cfg.RecipientID, "Key", hiae.KeyLen},
+		{c.commonIV[:], []byte{}, "IV", hiae.NonceLen},
+	} {
+		b, err := derive(&cfg, out.id, cose.AlgHiAE, out.typ, out.length)
+		if err != nil {
+			return nil, err
+		}
+		copy(out.dst, b)
+	}
+
+	size := cfg.ReplayWindow
+	if size == 0 {
+		size = DefaultReplayWindow
+	}
+	c.window = datagram.NewReplayWindow(size)
+
+	return c, nil
+}
+
+// nonce builds the AEAD nonce from the ID of the endpoint that generated piv, as in RFC 8613 Section 5.2
+func (c *Context) nonce(id, piv []byte) [hiae.NonceLen]byte {
+	var n [hiae.NonceLen]byte
+	n[0] = byte(len(id))
+	copy(n[1+MaxIDLen-len(id):1+MaxIDLen], id)
+	copy(n[hiae.NonceLen-len(piv):], piv)
+	for i := range n {
+		n[i] ^= c.commonIV[i]
+	}
+	return n
+}
+
+// aad builds the Enc_structure with the external AAD of RFC 8613 Section 5.4
+func aad(requestKID, requestPIV []byte) ([]byte, error) {
+	external, err := cbor.Marshal([]any{
+		int64(oscoreVersion),
+		[]any{cose.AlgHiAE},
+		requestKID,
+		requestPIV,
+		[]byte{}, // No Class I options
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cbor.Marshal([]any{"Encrypt0", []byte{}, external})
+}
+
+// encodePIV returns the shortest big-endian encoding of a sequence number, at least one byte
+func encodePIV(seq uint64) []byte {
+	var b [8]byte
+	for i := 0; i < 8; i++ {
+		b[7-i] = byte(seq >> (8 * i))
+	}
+	i := 0
+	for i < 7 && b[i] == 0 {
+		i++
+	}
+	return append([]byte{}, b[i:]...)
+}
+
+// decodePIV returns the sequence number of a Partial IV
+func decodePIV(piv []byte) uint64 {
+	var seq uint64
+	for _, b := range piv {
+		seq = seq<<8 | uint64(b)
+	}
+	return seq
+}
+
+// option is a parsed OSCORE option value
+type option struct {
+	piv        []byte
+	kid        []byte
+	hasKID     bool
+	kidContext []byte
+	hasContext bool
+}
+
+// encodeOption encodes an OSCORE option value as in RFC 8613 Section 6.1
+func encodeOption(o option) []byte {
+	if len(o.piv) == 0 && !o.hasKID && !o.hasContext {
+		return []byte{}
+	}
+	flags := byte(len(o.piv))
+	if o.hasKID {
+		flags |= 0x08
+	}
+	if o.hasContext {
+		flags |= 0x10
+	}
+	out := append([]byte{flags}, o.piv...)
+	if o.hasContext {
+		out = append(append(out, byte(len(o.kidContext))), o.kidContext...)
+	}
+	return append(out, o.kid...)
+}
+
+// parseOption decodes an OSCORE option value
+func parseOption(b []byte) (*option, error) {
+	o := &option{}
+	if len(b) == 0 {
+		return o, nil
+	}
+	flags := b[0]
+	n := int(flags & 0x07)
+	if flags&0xe0 != 0 || n > maxPIVLen || len(b) < 1+n {
+		return nil, ErrBadOption
+	}
+	o.piv = b[1 : 1+n]
+	rest := b[1+n:]
+	if flags&0x10 != 0 {
+		if len(rest) < 1 || len(rest) < 1+int(rest[0]) {
+			return nil, ErrBadOption
+		}
+		o.kidContext, o.hasContext = rest[1:1+int(rest[0])], true
+		rest = rest[1+int(rest[0]):]
+	}
+	if flags&0x08 != 0 {
+		o.kid, o.hasKID = rest, true
+	} else if len(rest) > 0 {
+		return nil, ErrBadOption
+	}
+	return o, nil
+}
+
+// Request identifies a protected request, binding the response to it
+type Request struct {
+	KID []byte // Sender ID of the client
+	PIV []byte // Partial IV of the request
+}
+
+// seal encrypts plaintext with key under nonce and the request-bound AAD
+func seal(key []byte, nonce [hiae.NonceLen]byte, req *Request, plaintext []byte) ([]byte, error) {
+	ad, err := aad(req.KID, req.PIV)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(plaintext)+hiae.TagLen)
+	if err := hiae.EncryptTo(plaintext, ad, key, nonce[:], out[:len(plaintext)], out[len(plaintext):]); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// open decrypts ciphertext || tag with key under nonce and the request-bound AAD
+func open(key []byte, nonce [hiae.NonceLen]byte, req *Request, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < hiae.TagLen {
+		return nil, ErrDecrypt
+	}
+	ad, err := aad(req.KID, req.PIV)
+	if err != nil {
+		return nil, err
+	}
+	n := len(ciphertext) - hiae.TagLen
+	out := make([]byte, n)
+	if err := hiae.DecryptTo(ciphertext[:n], ciphertext[n:], ad, key, nonce[:], out); err != nil {
+		return nil, ErrDecrypt
+	}
+	return out, nil
+}
+
+// nextPIV allocates the next sender sequence number
+func (c *Context) nextPIV() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ssn > MaxSequenceNumber {
+		return nil, ErrSequenceExhausted
+	}
+	piv := encodePIV(c.ssn)
+	c.ssn++
+	return piv, nil
+}
+
+// SetSequenceNumber sets the next sender sequence number, e.g. after restoring persisted state
+func (c *Context) SetSequenceNumber(ssn uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ssn = ssn
+}
+
+// ProtectRequest encrypts a request plaintext, returning the OSCORE option value and ciphertext
+// The returned Request must be kept to unprotect the response.
+func (c *Context) ProtectRequest(plaintext []byte) (opt, ciphertext []byte, req *Request, err error) {
+	piv, err := c.nextPIV()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	req = &Request{KID: c.senderID, PIV: piv}
+	ciphertext, err = seal(c.senderKey[:], c.nonce(c.senderID, piv), req, plaintext)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	o := option{piv: piv, kid: c.senderID, hasKID: true}
+	if c.idContext != nil {
+		o.kidContext, o.hasContext = c.idContext, true
+	}
+	return encodeOption(o), ciphertext, req, nil
+}
+
+// checkReplay verifies a Partial IV against the replay window without recording it
+func (c *Context) checkReplay(piv []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.window.Check(decodePIV(piv)) != nil {
+		return ErrReplay
+	}
+	return nil
+}
+
+// acceptReplay records an authenticated Partial IV in the replay window
+func (c *Context) acceptReplay(piv []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.window.Accept(decodePIV(piv)) != nil {
+		return ErrReplay
+	}
+	return nil
+}
+
+// UnprotectRequest verifies and decrypts a request, rejecting replays
+func (c *Context) UnprotectRequest(opt, ciphertext []byte) ([]byte, *Request, error) {
+	o, err := parseOption(opt)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(o.piv) == 0 || !o.hasKID {
+		return nil, nil, ErrBadOption
+	}
+	if !bytes.Equal(o.kid, c.recipientID) || (o.hasContext && !bytes.Equal(o.kidContext, c.idContext)) {
+		return nil, nil, ErrUnknownContext
+	}
+	if err := c.checkReplay(o.piv); err != nil {
+		return nil, nil, err
+	}
+
+	req := &Request{KID: c.recipientID, PIV: append([]byte{}, o.piv...)}
+	plaintext, err := open(c.recipKey[:], c.nonce(c.recipientID, o.piv), req, ciphertext)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := c.acceptReplay(o.piv); err != nil {
+		return nil, nil, err
+	}
+	return plaintext, req, nil
+}
+
+// ProtectResponse encrypts a response to req
+// Without a fresh Partial IV the response reuses the request nonce, which is only safe for a
+// single response per request; notifications and other repeated responses need withPIV.
+func (c *Context) ProtectResponse(req *Request, plaintext []byte, withPIV bool) (opt, ciphertext []byte, err error) {
+	o := option{}
+	nonce := c.nonce(req.KID, req.PIV)
+	if withPIV {
+		if o.piv, err = c.nextPIV(); err != nil {
+			return nil, nil, err
+		}
+		nonce = c.nonce(c.senderID, o.piv)
+	}
+	ciphertext, err = seal(c.senderKey[:], nonce, req, plaintext)
+	if err != nil {
+		return nil, nil, err
+	}
+	return encodeOption(o), ciphertext, nil
+}
+
+// UnprotectResponse verifies and decrypts the response to a request made with ProtectRequest
+func (c *Context) UnprotectResponse(req *Request, opt, ciphertext []byte) ([]byte, error) {
+	o, err := parseOption(opt)
+	if err != nil {
+		return nil, err
+	}
+	if o.hasKID || o.hasContext {
+		return nil, ErrBadOption
+	}
+
+	nonce := c.nonce(req.KID, req.PIV)
+	if len(o.piv) > 0 {
+		if err := c.checkReplay(o.piv); err != nil {
+			return nil, err
+		}
+		nonce = c.nonce(c.recipientID, o.piv)
+	}
+	plaintext, err := open(c.recipKey[:], nonce, req, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	if len(o.piv) > 0 {
+		if err := c.acceptReplay(o.piv); err != nil {
+			return nil, err
+		}
+	}
+	return plaintext, nil
+}
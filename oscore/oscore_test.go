@@ -0,0 +1,251 @@
+package oscore
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic("invalid hex string: " + s)
+	}
+	return b
+}
+
+func testPair(t *testing.T) (client, server *Context) {
+	t.Helper()
+	secret := mustHex("0102030405060708090a0b0c0d0e0f10")
+	salt := mustHex("9e7ca92223786340")
+	client, err := NewContext(Config{MasterSecret: secret, MasterSalt: salt, SenderID: []byte{}, RecipientID: []byte{0x01}})
+	if err != nil {
+		t.Fatalf("NewContext failed: %v", err)
+	}
+	server, err = NewContext(Config{MasterSecret: secret, MasterSalt: salt, SenderID: []byte{0x01}, RecipientID: []byte{}})
+	if err != nil {
+		t.Fatalf("NewContext failed: %v", err)
+	}
+	return client, server
+}
+
+// TestDeriveRFC8613 checks the derivation against RFC 8613 Appendix C.1.1, which uses AES-CCM-16-64-128
+func TestDeriveRFC8613(t *testing.T) {
+	cfg := &Config{
+		MasterSecret: mustHex("0102030405060708090a0b0c0d0e0f10"),
+		MasterSalt:   mustHex("9e7ca92223786340"),
+	}
+	for _, tc := range []struct {
+		id     []byte
+		typ    string
+		length int
+		want   string
+	}{
+		{[]byte{}, "Key", 16, "f0910ed7295e6ad4b54fc793154302ff"},
+		{[]byte{0x01}, "Key", 16, "ffb14e093c94c9cac9471648b4f98710"},
+		{[]byte{}, "IV", 13, "4622d4dd6d944168eefb54987c"},
+	} {
+		got, err := derive(cfg, tc.id, 10, tc.typ, tc.length)
+		if err != nil {
+			t.Fatalf("derive failed: %v", err)
+		}
+		if hex.EncodeToString(got) != tc.want {
+			t.Errorf("derive(%x, %s) = %x, want %s", tc.id, tc.typ, got, tc.want)
+		}
+	}
+}
+
+// TestContextKeys checks that both endpoints derive mirrored keys and the same Common IV
+func TestContextKeys(t *testing.T) {
+	client, server := testPair(t)
+	if client.senderKey != server.recipKey || client.recipKey != server.senderKey {
+		t.Error("Sender and recipient keys are not mirrored")
+	}
+	if client.senderKey == client.recipKey {
+		t.Error("Sender and recipient keys are equal")
+	}
+	if client.commonIV != server.commonIV {
+		t.Error("Common IV differs between endpoints")
+	}
+}
+
+// TestNonce checks the nonce layout: ID length, left-padded ID, left-padded Partial IV
+func TestNonce(t *testing.T) {
+	c := &Context{}
+	n := c.nonce([]byte{0xaa, 0xbb}, []byte{0x01, 0x02})
+	want := "02" + "0000000000000000aabb" + "0000000102"
+	if hex.EncodeToString(n[:]) != want {
+		t.Errorf("nonce = %x, want %s", n, want)
+	}
+	if a, b := c.nonce([]byte{}, []byte{0x01}), c.nonce([]byte{0x00}, []byte{0x01}); a == b {
+		t.Error("Empty and zero Sender IDs produce the same nonce")
+	}
+}
+
+func TestOptionEncoding(t *testing.T) {
+	for _, tc := range []struct {
+		o    option
+		want string
+	}{
+		{option{}, ""},
+		{option{piv: []byte{0x14}, kid: []byte{}, hasKID: true}, "0914"},
+		{option{piv: []byte{0x14}, kid: []byte{0x01}, hasKID: true, kidContext: []byte{0x37, 0xcb}, hasContext: true}, "191402" + "37cb" + "01"},
+		{option{piv: []byte{0x07}}, "0107"},
+	} {
+		enc := encodeOption(tc.o)
+		if hex.EncodeToString(enc) != tc.want {
+			t.Errorf("encodeOption = %x, want %s", enc, tc.want)
+		}
+		dec, err := parseOption(enc)
+		if err != nil {
+			t.Fatalf("parseOption(%x) failed: %v", enc, err)
+		}
+		if !bytes.Equal(dec.piv, tc.o.piv) || !bytes.Equal(dec.kid, tc.o.kid) || dec.hasKID != tc.o.hasKID ||
+			!bytes.Equal(dec.kidContext, tc.o.kidContext) || dec.hasContext != tc.o.hasContext {
+			t.Errorf("parseOption(%x) = %+v, want %+v", enc, dec, tc.o)
+		}
+	}
+
+	for _, bad := range []string{"20", "06", "0201", "1001", "1003aa", "0107ff"} {
+		if _, err := parseOption(mustHex(bad)); !errors.Is(err, ErrBadOption) {
+			t.Errorf("parseOption(%s) error = %v, want ErrBadOption", bad, err)
+		}
+	}
+}
+
+func TestEncodePIV(t *testing.T) {
+	for seq, want := range map[uint64]string{0: "00", 20: "14", 256: "0100", MaxSequenceNumber: "ffffffffff"} {
+		piv := encodePIV(seq)
+		if hex.EncodeToString(piv) != want {
+			t.Errorf("encodePIV(%d) = %x, want %s", seq, piv, want)
+		}
+		if decodePIV(piv) != seq {
+			t.Errorf("decodePIV(%x) = %d, want %d", piv, decodePIV(piv), seq)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	client, server := testPair(t)
+	for _, withPIV := range []bool{false, true} {
+		opt, ct, req, err := client.ProtectRequest([]byte("\x01\xb4test"))
+		if err != nil {
+			t.Fatalf("ProtectRequest failed: %v", err)
+		}
+		pt, sreq, err := server.UnprotectRequest(opt, ct)
+		if err != nil {
+			t.Fatalf("UnprotectRequest failed: %v", err)
+		}
+		if string(pt) != "\x01\xb4test" {
+			t.Errorf("Request plaintext = %q", pt)
+		}
+
+		ropt, rct, err := server.ProtectResponse(sreq, []byte("\x45\xffHello World!"), withPIV)
+		if err != nil {
+			t.Fatalf("ProtectResponse failed: %v", err)
+		}
+		if !withPIV && len(ropt) != 0 {
+			t.Errorf("Response option = %x, want empty", ropt)
+		}
+		pt, err = client.UnprotectResponse(req, ropt, rct)
+		if err != nil {
+			t.Fatalf("UnprotectResponse(withPIV=%v) failed: %v", withPIV, err)
+		}
+		if string(pt) != "\x45\xffHello World!" {
+			t.Errorf("Response plaintext = %q", pt)
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	client, server := testPair(t)
+	opt, ct, _, err := client.ProtectRequest([]byte("GET"))
+	if err != nil {
+		t.Fatalf("ProtectRequest failed: %v", err)
+	}
+	if _, _, err := server.UnprotectRequest(opt, ct); err != nil {
+		t.Fatalf("UnprotectRequest failed: %v", err)
+	}
+	if _, _, err := server.UnprotectRequest(opt, ct); !errors.Is(err, ErrReplay) {
+		t.Errorf("Replayed request error = %v, want ErrReplay", err)
+	}
+
+	// A forged request must not advance the window
+	client.SetSequenceNumber(1000)
+	opt, ct, _, err = client.ProtectRequest([]byte("GET"))
+	if err != nil {
+		t.Fatalf("ProtectRequest failed: %v", err)
+	}
+	ct[0] ^= 1
+	if _, _, err := server.UnprotectRequest(opt, ct); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Forged request error = %v, want ErrDecrypt", err)
+	}
+	client.SetSequenceNumber(1)
+	opt, ct, _, err = client.ProtectRequest([]byte("GET"))
+	if err != nil {
+		t.Fatalf("ProtectRequest failed: %v", err)
+	}
+	if _, _, err := server.UnprotectRequest(opt, ct); err != nil {
+		t.Errorf("Request after forgery failed: %v", err)
+	}
+}
+
+func TestTamper(t *testing.T) {
+	client, server := testPair(t)
+	opt, ct, req, err := client.ProtectRequest([]byte("POST"))
+	if err != nil {
+		t.Fatalf("ProtectRequest failed: %v", err)
+	}
+
+	badKID := append(append([]byte{}, opt...), 0x02)
+	if _, _, err := server.UnprotectRequest(badKID, ct); !errors.Is(err, ErrUnknownContext) {
+		t.Errorf("Wrong kid error = %v, want ErrUnknownContext", err)
+	}
+	badPIV := append([]byte{}, opt...)
+	badPIV[1] ^= 1
+	if _, _, err := server.UnprotectRequest(badPIV, ct); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Modified Partial IV error = %v, want ErrDecrypt", err)
+	}
+	if _, _, err := server.UnprotectRequest(opt, ct[:len(ct)-1]); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Truncated ciphertext error = %v, want ErrDecrypt", err)
+	}
+
+	// A response must be bound to its request
+	_, sreq, err := server.UnprotectRequest(opt, ct)
+	if err != nil {
+		t.Fatalf("UnprotectRequest failed: %v", err)
+	}
+	ropt, rct, err := server.ProtectResponse(sreq, []byte("ok"), true)
+	if err != nil {
+		t.Fatalf("ProtectResponse failed: %v", err)
+	}
+	other := &Request{KID: req.KID, PIV: []byte{0x63}}
+	if _, err := client.UnprotectResponse(other, ropt, rct); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Response to other request error = %v, want ErrDecrypt", err)
+	}
+}
+
+func TestSequenceExhausted(t *testing.T) {
+	client, _ := testPair(t)
+	client.SetSequenceNumber(MaxSequenceNumber)
+	if _, _, _, err := client.ProtectRequest(nil); err != nil {
+		t.Fatalf("Last sequence number rejected: %v", err)
+	}
+	if _, _, _, err := client.ProtectRequest(nil); !errors.Is(err, ErrSequenceExhausted) {
+		t.Errorf("Exhausted error = %v, want ErrSequenceExhausted", err)
+	}
+}
+
+func TestNewContextErrors(t *testing.T) {
+	secret := []byte("secret")
+	for _, cfg := range []Config{
+		{SenderID: []byte{1}, RecipientID: []byte{2}},
+		{MasterSecret: secret, SenderID: make([]byte, MaxIDLen+1), RecipientID: []byte{2}},
+		{MasterSecret: secret, SenderID: []byte{1}, RecipientID: []byte{1}},
+	} {
+		if _, err := NewContext(cfg); err == nil {
+			t.Errorf("NewContext(%+v) succeeded", cfg)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package hiae
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Password-based encryption
+//
+// A sealed blob is laid out as
+//
+//	params = version(1) || hash(1) || iterations(4, big-endian) || salt(16)
+//	blob   = params || nonce(16) || HiAE(key, nonce, ad = params || ad, msg) || tag
+//
+// The key is PBKDF2(hash, password, salt, iterations). The parameter block is authenticated,
+// so changing the hash or lowering the iteration count makes decryption fail, and
+// OpenWithPassword refuses parameters below its minimum cost before running PBKDF2 at all.
+
+// PasswordHash identifies the PBKDF2 hash function
+type PasswordHash uint8
+
+// Supported PBKDF2 hash functions
+const (
+	PasswordSHA256 PasswordHash = 1
+	PasswordSHA512 PasswordHash = 2
+)
+
+const (
+	// DefaultPasswordIterations is the PBKDF2 iteration count used when none is given
+	DefaultPasswordIterations = 600000
+	// DefaultMinPasswordIterations is the lowest iteration count OpenWithPassword accepts by default
+	DefaultMinPasswordIterations = 100000
+	// DefaultMaxPasswordIterations bounds the work an untrusted blob can demand from OpenWithPassword
+	DefaultMaxPasswordIterations = 10000000
+
+	passwordVersion   = 1
+	passwordSaltLen   = 16
+	passwordParamsLen = 2 + 4 + passwordSaltLen
+)
+
+// PasswordOptions controls the cost of password-based encryption
+type PasswordOptions struct {
+	// Hash selects the PBKDF2 hash. Zero selects PasswordSHA256.
+	Hash PasswordHash
+	// Iterations is the PBKDF2 iteration count used by SealWithPassword. Zero selects DefaultPasswordIterations.
+	Iterations int
+	// MinIterations is the lowest iteration count accepted by either function. Zero selects DefaultMinPasswordIterations.
+	MinIterations int
+	// MaxIterations is the highest iteration count accepted by OpenWithPassword. Zero selects DefaultMaxPasswordIterations.
+	MaxIterations int
+}
+
+// withDefaults returns the options with zero fields replaced by their defaults
+func (o *PasswordOptions) withDefaults() PasswordOptions {
+	var out PasswordOptions
+	if o != nil {
+		out = *o
+	}
+	if out.Hash == 0 {
+		out.Hash = PasswordSHA256
+	}
+	if out.Iterations == 0 {
+		out.Iterations = DefaultPasswordIterations
+	}
+	if out.MinIterations == 0 {
+		out.MinIterations = DefaultMinPasswordIterations
+	}
+	if out.MaxIterations == 0 {
+		out.MaxIterations = DefaultMaxPasswordIterations
+	}
+	return out
+}
+
+// passwordKey runs PBKDF2 with the given parameters
+func passwordKey(password string, hash PasswordHash, salt []byte, iterations int) ([]byte, error) {
+	switch hash {
+	case PasswordSHA256:
+		return pbkdf2.Key(sha256.New, password, salt, iterations, KeyLen)
+	case PasswordSHA512:
+		return pbkdf2.Key(sha512.New, password, salt, iterations, KeyLen)
+	}
+	return nil, errors.New("unsupported password hash")
+}
+
+// SealWithPassword encrypts msg under a key derived from password, returning a self-describing blob
+func SealWithPassword(msg, ad []byte, password string, opts *PasswordOptions) ([]byte, error) {
+	o := opts.withDefaults()
+	if o.Iterations < max(o.MinIterations, 1) || uint64(o.Iterations) > math.MaxUint32 {
+		return nil, errors.New("password iteration count out of range")
+	}
+
+	out := make([]byte, passwordParamsLen+NonceLen+len(msg)+TagLen)
+	params := out[:passwordParamsLen]
+	params[0] = passwordVersion
+	params[1] = byte(o.Hash)
+	binary.BigEndian.PutUint32(params[2:6], uint32(o.Iterations))
+	salt := params[6:]
+	nonce := out[len(params) : len(params)+NonceLen]
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	key, err := passwordKey(password, o.Hash, salt, o.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	defer zeroBytes(key)
+
+	ct := out[len(params)+NonceLen : len(params)+NonceLen+len(msg)]
+	tag := out[len(out)-TagLen:]
+	if err := EncryptTo(msg, append(append([]byte{}, params...), ad...), key, nonce, ct, tag); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// OpenWithPassword decrypts a blob produced by SealWithPassword
+// Blobs whose iteration count lies outside [MinIterations, MaxIterations] are rejected without deriving a key.
+func OpenWithPassword(sealed, ad []byte, password string, opts *PasswordOptions) ([]byte, error) {
+	o := opts.withDefaults()
+	headerLen := passwordParamsLen + NonceLen
+	if len(sealed) < headerLen+TagLen {
+		return nil, errors.New("sealed data too short")
+	}
+
+	params := sealed[:passwordParamsLen]
+	if params[0] != passwordVersion {
+		return nil, errors.New("unsupported password blob version")
+	}
+	hash := PasswordHash(params[1])
+	iterations := binary.BigEndian.Uint32(params[2:6])
+	if uint64(iterations) < uint64(o.MinIterations) {
+		return nil, errors.New("password iteration count below minimum")
+	}
+	if uint64(iterations) > uint64(o.MaxIterations) {
+		return nil, errors.New("password iteration count above maximum")
+	}
+
+	key, err := passwordKey(password, hash, params[6:], int(iterations))
+	if err != nil {
+		return nil, err
+	}
+	defer zeroBytes(key)
+
+	nonce := sealed[len(params):headerLen]
+	ct := sealed[headerLen : len(sealed)-TagLen]
+	tag := sealed[len(sealed)-TagLen:]
+	msg := make([]byte, len(ct))
+	if err := DecryptTo(ct, tag, append(append([]byte{}, params...), ad...), key, nonce, msg); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
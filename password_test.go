@@ -0,0 +1,90 @@
+package hiae
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+var testPasswordOpts = &PasswordOptions{Iterations: 1000, MinIterations: 1000}
+
+// TestPasswordRoundTrip seals and opens with both hashes
+func TestPasswordRoundTrip(t *testing.T) {
+	msg := []byte("export data")
+	ad := []byte("export-v1")
+	for _, hash := range []PasswordHash{PasswordSHA256, PasswordSHA512} {
+		opts := *testPasswordOpts
+		opts.Hash = hash
+		sealed, err := SealWithPassword(msg, ad, "correct horse", &opts)
+		if err != nil {
+			t.Fatalf("SealWithPassword failed: %v", err)
+		}
+		if len(sealed) != passwordParamsLen+NonceLen+len(msg)+TagLen {
+			t.Errorf("Unexpected sealed length %d", len(sealed))
+		}
+		pt, err := OpenWithPassword(sealed, ad, "correct horse", &opts)
+		if err != nil || !bytes.Equal(pt, msg) {
+			t.Fatalf("OpenWithPassword failed: %v", err)
+		}
+		if _, err := OpenWithPassword(sealed, ad, "wrong horse", &opts); err == nil {
+			t.Error("Expected error for wrong password")
+		}
+		if _, err := OpenWithPassword(sealed, []byte("other"), "correct horse", &opts); err == nil {
+			t.Error("Expected error for wrong associated data")
+		}
+	}
+
+	// The defaults must be usable as they are
+	sealed, err := SealWithPassword(msg, nil, "pw", nil)
+	if err != nil {
+		t.Fatalf("SealWithPassword with defaults failed: %v", err)
+	}
+	if _, err := OpenWithPassword(sealed, nil, "pw", nil); err != nil {
+		t.Fatalf("OpenWithPassword with defaults failed: %v", err)
+	}
+}
+
+// TestPasswordVector opens a pinned blob sealed with SHA-256 and 1000 iterations
+func TestPasswordVector(t *testing.T) {
+	sealed := hexDecode("0101000003e8718de629315a9b5fc9871ca8009382967749ec7e8933a696d9d48498a6dbbd2f0879e92d2e94ba86cf923cb6950157a3081314e8719651896057f559c6fc")
+	pt, err := OpenWithPassword(sealed, []byte("ad"), "password", testPasswordOpts)
+	if err != nil {
+		t.Fatalf("OpenWithPassword failed: %v", err)
+	}
+	if string(pt) != "pinned message" {
+		t.Errorf("Unexpected plaintext %q", pt)
+	}
+}
+
+// TestPasswordDowngrade checks that parameter changes are rejected
+func TestPasswordDowngrade(t *testing.T) {
+	opts := &PasswordOptions{Iterations: 2000, MinIterations: 1000}
+	sealed, err := SealWithPassword([]byte("msg"), nil, "pw", opts)
+	if err != nil {
+		t.Fatalf("SealWithPassword failed: %v", err)
+	}
+
+	mutations := map[string]func(b []byte){
+		"lower iterations": func(b []byte) { binary.BigEndian.PutUint32(b[2:6], 1000) },
+		"below minimum":    func(b []byte) { binary.BigEndian.PutUint32(b[2:6], 999) },
+		"above maximum":    func(b []byte) { binary.BigEndian.PutUint32(b[2:6], DefaultMaxPasswordIterations+1) },
+		"changed hash":     func(b []byte) { b[1] = byte(PasswordSHA512) },
+		"unknown hash":     func(b []byte) { b[1] = 9 },
+		"changed salt":     func(b []byte) { b[10] ^= 1 },
+		"changed version":  func(b []byte) { b[0] = 2 },
+	}
+	for name, mutate := range mutations {
+		bad := append([]byte{}, sealed...)
+		mutate(bad)
+		if _, err := OpenWithPassword(bad, nil, "pw", opts); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	if _, err := SealWithPassword(nil, nil, "pw", &PasswordOptions{Iterations: 10}); err == nil {
+		t.Error("Expected error for iterations below the default minimum")
+	}
+	if _, err := OpenWithPassword(sealed[:passwordParamsLen+NonceLen+TagLen-1], nil, "pw", opts); err == nil {
+		t.Error("Expected error for truncated blob")
+	}
+}
@@ -0,0 +1,230 @@
+// Package pqfile implements an age-style multi-recipient file format with hybrid post-quantum key wrapping
+//
+// A random 32-byte file key encrypts the body. It is wrapped separately for every recipient
+// with a hybrid of ML-KEM-768 and X25519, so an attacker must break both to recover it. A file
+// is laid out as
+//
+//	hiae-pqfile/v1
+//	-> mlkem768x25519 <base64 ephemeral X25519 share> <base64 ML-KEM ciphertext>
+//	<base64 wrapped file key>
+//	--- <base64 header MAC>
+//	payload nonce (16 bytes) || STREAM chunks
+//
+// The header MAC is a HiAE tag over the header text, keyed from the file key. The body is
+// split into 64 KiB chunks, each sealed under a key derived from the file key and the payload
+// nonce, with a chunk counter and a final-chunk flag as the HiAE nonce.
+package pqfile
+
+import (
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/mlkem"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+
+	hiae "github.com/hiae-aead/go-hiae"
+)
+
+// Key encoding prefixes
+const (
+	IdentityPrefix  = "HIAE-PQ-SECRET-KEY-1:"
+	RecipientPrefix = "hiae-pq-1:"
+)
+
+const (
+	x25519Len     = 32
+	identityLen   = mlkem.SeedSize + x25519Len
+	recipientLen  = mlkem.EncapsulationKeySize768 + x25519Len
+	fileKeyLen    = hiae.KeyLen
+	wrappedKeyLen = fileKeyLen + hiae.TagLen
+	hybridLabel   = "hiae-pqfile/v1 mlkem768x25519"
+	stanzaType    = "mlkem768x25519"
+)
+
+var b64 = base64.RawStdEncoding.Strict()
+
+var (
+	// ErrBadKey is returned when parsing a malformed identity or recipient
+	ErrBadKey = errors.New("pqfile: malformed key")
+	// ErrNoIdentityMatch is returned when no stanza in the header can be unwrapped with the identity
+	ErrNoIdentityMatch = errors.New("pqfile: no identity matched any recipient")
+)
+
+// Identity is a private key able to decrypt files addressed to its Recipient
+type Identity struct {
+	mlkem  *mlkem.DecapsulationKey768
+	x25519 *ecdh.PrivateKey
+}
+
+// Recipient is a public key that files can be encrypted to
+type Recipient struct {
+	mlkem  *mlkem.EncapsulationKey768
+	x25519 *ecdh.PublicKey
+}
+
+// GenerateIdentity returns a new random identity
+func GenerateIdentity() (*Identity, error) {
+	var seed [identityLen]byte
+	if _, err := io.ReadFull(rand.Reader, seed[:]); err != nil {
+		return nil, err
+	}
+	return newIdentity(seed[:])
+}
+
+// newIdentity expands an ML-KEM seed followed by an X25519 private key
+func newIdentity(seed []byte) (*Identity, error) {
+	if len(seed) != identityLen {
+		return nil, ErrBadKey
+	}
+	dk, err := mlkem.NewDecapsulationKey768(seed[:mlkem.SeedSize])
+	if err != nil {
+		return nil, ErrBadKey
+	}
+	xk, err := ecdh.X25519().NewPrivateKey(seed[mlkem.SeedSize:])
+	if err != nil {
+		return nil, ErrBadKey
+	}
+	return &Identity{mlkem: dk, x25519: xk}, nil
+}
+
+// ParseIdentity decodes an identity produced by Identity.String
+func ParseIdentity(s string) (*Identity, error) {
+	enc, ok := strings.CutPrefix(strings.TrimSpace(s), IdentityPrefix)
+	if !ok {
+		return nil, ErrBadKey
+	}
+	seed, err := b64.DecodeString(enc)
+	if err != nil {
+		return nil, ErrBadKey
+	}
+	return newIdentity(seed)
+}
+
+// String encodes the identity; the result is secret
+func (id *Identity) String() string {
+	seed := append(id.mlkem.Bytes(), id.x25519.Bytes()...)
+	return IdentityPrefix + b64.EncodeToString(seed)
+}
+
+// Recipient returns the public key matching the identity
+func (id *Identity) Recipient() *Recipient {
+	return &Recipient{mlkem: id.mlkem.EncapsulationKey(), x25519: id.x25519.PublicKey()}
+}
+
+// ParseRecipient decodes a recipient produced by Recipient.String
+func ParseRecipient(s string) (*Recipient, error) {
+	enc, ok := strings.CutPrefix(strings.TrimSpace(s), RecipientPrefix)
+	if !ok {
+		return nil, ErrBadKey
+	}
+	b, err := b64.DecodeString(enc)
+	if err != nil || len(b) != recipientLen {
+		return nil, ErrBadKey
+	}
+	ek, err := mlkem.NewEncapsulationKey768(b[:mlkem.EncapsulationKeySize768])
+	if err != nil {
+		return nil, ErrBadKey
+	}
+	xk, err := ecdh.X25519().NewPublicKey(b[mlkem.EncapsulationKeySize768:])
+	if err != nil {
+		return nil, ErrBadKey
+	}
+	return &Recipient{mlkem: ek, x25519: xk}, nil
+}
+
+// String encodes the recipient for sharing
+func (r *Recipient) String() string {
+	return RecipientPrefix + b64.EncodeToString(append(r.mlkem.Bytes(), r.x25519.Bytes()...))
+}
+
+// stanza is one recipient entry of a header
+type stanza struct {
+	typ  string
+	args []string
+	body []byte
+}
+
+// hybridKey combines both shared secrets into a wrapping key
+// The ML-KEM ciphertext and both X25519 shares are bound in as the salt, so the key commits
+// to the whole exchange even if one of the two components is broken.
+func hybridKey(ssM, ssX, ctM, ephX, pkX []byte) []byte {
+	ikm := append(append([]byte{}, ssM...), ssX...)
+	salt := append(append(append([]byte{}, ctM...), ephX...), pkX...)
+	key, err := hkdf.Key(sha256.New, ikm, salt, hybridLabel, hiae.KeyLen)
+	if err != nil {
+		panic("pqfile: hkdf failed: " + err.Error())
+	}
+	return key
+}
+
+// wrap seals the file key to the recipient
+func (r *Recipient) wrap(fileKey []byte) (*stanza, error) {
+	ssM, ctM := r.mlkem.Encapsulate()
+	eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	ssX, err := eph.ECDH(r.x25519)
+	if err != nil {
+		return nil, err
+	}
+	ephX := eph.PublicKey().Bytes()
+	key := hybridKey(ssM, ssX, ctM, ephX, r.x25519.Bytes())
+
+	// Every wrapping key is fresh, so a fixed nonce is safe
+	var nonce [hiae.NonceLen]byte
+	body := make([]byte, wrappedKeyLen)
+	if err := hiae.EncryptTo(fileKey, nil, key, nonce[:], body[:fileKeyLen], body[fileKeyLen:]); err != nil {
+		return nil, err
+	}
+
+	return &stanza{
+		typ:  stanzaType,
+		args: []string{b64.EncodeToString(ephX), b64.EncodeToString(ctM)},
+		body: body,
+	}, nil
+}
+
+// unwrap recovers the file key from a stanza addressed to the identity
+func (id *Identity) unwrap(s *stanza) ([]byte, error) {
+	if s.typ != stanzaType {
+		return nil, ErrNoIdentityMatch
+	}
+	if len(s.args) != 2 || len(s.body) != wrappedKeyLen {
+		return nil, ErrBadHeader
+	}
+	ephX, err := b64.DecodeString(s.args[0])
+	if err != nil || len(ephX) != x25519Len {
+		return nil, ErrBadHeader
+	}
+	ctM, err := b64.DecodeString(s.args[1])
+	if err != nil || len(ctM) != mlkem.CiphertextSize768 {
+		return nil, ErrBadHeader
+	}
+
+	ssM, err := id.mlkem.Decapsulate(ctM)
+	if err != nil {
+		return nil, ErrBadHeader
+	}
+	pub, err := ecdh.X25519().NewPublicKey(ephX)
+	if err != nil {
+		return nil, ErrBadHeader
+	}
+	ssX, err := id.x25519.ECDH(pub)
+	if err != nil {
+		return nil, ErrBadHeader
+	}
+	key := hybridKey(ssM, ssX, ctM, ephX, id.x25519.PublicKey().Bytes())
+
+	var nonce [hiae.NonceLen]byte
+	fileKey := make([]byte, fileKeyLen)
+	if err := hiae.DecryptTo(s.body[:fileKeyLen], s.body[fileKeyLen:], nil, key, nonce[:], fileKey); err != nil {
+		// ML-KEM decapsulation never fails outright, so a stanza for someone else ends up here
+		return nil, ErrNoIdentityMatch
+	}
+	return fileKey, nil
+}
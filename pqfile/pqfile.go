@@ -0,0 +1,190 @@
+package pqfile
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"io"
+	"strings"
+
+	hiae "github.com/hiae-aead/go-hiae"
+)
+
+const (
+	versionLine   = "hiae-pqfile/v1"
+	stanzaPrefix  = "-> "
+	footerPrefix  = "---"
+	maxHeaderLine = 4096
+	maxStanzas    = 1024
+)
+
+var (
+	// ErrBadHeader is returned for a header that is malformed or fails authentication
+	ErrBadHeader = errors.New("pqfile: bad header")
+	// ErrNoRecipients is returned by Encrypt when called without recipients
+	ErrNoRecipients = errors.New("pqfile: no recipients")
+)
+
+// deriveKey derives a subkey of the file key for one purpose
+func deriveKey(fileKey, salt []byte, label string) []byte {
+	key, err := hkdf.Key(sha256.New, fileKey, salt, label, hiae.KeyLen)
+	if err != nil {
+		panic("pqfile: hkdf failed: " + err.Error())
+	}
+	return key
+}
+
+// headerMAC computes the HiAE tag authenticating the header text up to and including "---"
+func headerMAC(fileKey, header []byte) []byte {
+	key := deriveKey(fileKey, nil, "header")
+	var nonce [hiae.NonceLen]byte
+	tag := make([]byte, hiae.TagLen)
+	if err := hiae.EncryptTo(nil, header, key, nonce[:], nil, tag); err != nil {
+		panic("pqfile: header MAC failed: " + err.Error())
+	}
+	return tag
+}
+
+// marshalHeader encodes the version line and stanzas, without the MAC
+func marshalHeader(stanzas []*stanza) []byte {
+	var b bytes.Buffer
+	b.WriteString(versionLine + "\n")
+	for _, s := range stanzas {
+		b.WriteString(stanzaPrefix + s.typ)
+		for _, a := range s.args {
+			b.WriteString(" " + a)
+		}
+		b.WriteString("\n" + b64.EncodeToString(s.body) + "\n")
+	}
+	b.WriteString(footerPrefix)
+	return b.Bytes()
+}
+
+// Encrypt writes a header for recipients to dst and returns a writer for the plaintext
+// The returned writer must be closed to write the final chunk.
+func Encrypt(dst io.Writer, recipients ...*Recipient) (io.WriteCloser, error) {
+	if len(recipients) == 0 {
+		return nil, ErrNoRecipients
+	}
+
+	fileKey := make([]byte, fileKeyLen)
+	if _, err := io.ReadFull(rand.Reader, fileKey); err != nil {
+		return nil, err
+	}
+	stanzas := make([]*stanza, 0, len(recipients))
+	for _, r := range recipients {
+		s, err := r.wrap(fileKey)
+		if err != nil {
+			return nil, err
+		}
+		stanzas = append(stanzas, s)
+	}
+
+	header := marshalHeader(stanzas)
+	mac := headerMAC(fileKey, header)
+	header = append(header, " "+b64.EncodeToString(mac)+"\n"...)
+
+	nonce := make([]byte, payloadNonceLen)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	if _, err := dst.Write(append(header, nonce...)); err != nil {
+		return nil, err
+	}
+
+	return newWriter(dst, deriveKey(fileKey, nonce, "payload")), nil
+}
+
+// readLine reads one newline-terminated header line without the newline
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) || len(line) > maxHeaderLine {
+		return "", ErrBadHeader
+	}
+	if err != nil {
+		return "", ErrBadHeader
+	}
+	return string(line[:len(line)-1]), nil
+}
+
+// parseHeader reads a header, returning its stanzas, the MAC and the authenticated header bytes
+func parseHeader(r *bufio.Reader) ([]*stanza, []byte, []byte, error) {
+	line, err := readLine(r)
+	if err != nil || line != versionLine {
+		return nil, nil, nil, ErrBadHeader
+	}
+
+	var stanzas []*stanza
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if rest, ok := strings.CutPrefix(line, footerPrefix+" "); ok {
+			mac, err := b64.DecodeString(rest)
+			if err != nil || len(mac) != hiae.TagLen || len(stanzas) == 0 {
+				return nil, nil, nil, ErrBadHeader
+			}
+			return stanzas, mac, marshalHeader(stanzas), nil
+		}
+
+		rest, ok := strings.CutPrefix(line, stanzaPrefix)
+		if !ok || len(stanzas) == maxStanzas {
+			return nil, nil, nil, ErrBadHeader
+		}
+		fields := strings.Split(rest, " ")
+		for _, f := range fields {
+			if f == "" {
+				return nil, nil, nil, ErrBadHeader
+			}
+		}
+		bodyLine, err := readLine(r)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		body, err := b64.DecodeString(bodyLine)
+		if err != nil {
+			return nil, nil, nil, ErrBadHeader
+		}
+		stanzas = append(stanzas, &stanza{typ: fields[0], args: fields[1:], body: body})
+	}
+}
+
+// Decrypt reads the header from src, unwraps the file key with identity and returns a reader for the plaintext
+// The header is authenticated before any plaintext is returned. Errors from the returned reader
+// mean the body was truncated or modified, and data read so far must be discarded.
+func Decrypt(src io.Reader, identity *Identity) (io.Reader, error) {
+	br := bufio.NewReaderSize(src, maxHeaderLine)
+	stanzas, mac, header, err := parseHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileKey []byte
+	for _, s := range stanzas {
+		fileKey, err = identity.unwrap(s)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, ErrNoIdentityMatch) {
+			return nil, err
+		}
+	}
+	if fileKey == nil {
+		return nil, ErrNoIdentityMatch
+	}
+	if subtle.ConstantTimeCompare(headerMAC(fileKey, header), mac) != 1 {
+		return nil, ErrBadHeader
+	}
+
+	nonce := make([]byte, payloadNonceLen)
+	if _, err := io.ReadFull(br, nonce); err != nil {
+		return nil, ErrBadHeader
+	}
+
+	return newReader(br, deriveKey(fileKey, nonce, "payload")), nil
+}
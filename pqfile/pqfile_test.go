@@ -0,0 +1,164 @@
+package pqfile
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func mustIdentity(t *testing.T) *Identity {
+	t.Helper()
+	id, err := GenerateIdentity()
+	if err != nil {
+		t.Fatalf("GenerateIdentity failed: %v", err)
+	}
+	return id
+}
+
+func encrypt(t *testing.T, msg []byte, recipients ...*Recipient) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	w, err := Encrypt(&out, recipients...)
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	return out.Bytes()
+}
+
+func decrypt(file []byte, id *Identity) ([]byte, error) {
+	r, err := Decrypt(bytes.NewReader(file), id)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// TestRoundTrip encrypts to several recipients at chunk boundary sizes
+func TestRoundTrip(t *testing.T) {
+	ids := []*Identity{mustIdentity(t), mustIdentity(t), mustIdentity(t)}
+	recipients := []*Recipient{ids[0].Recipient(), ids[1].Recipient(), ids[2].Recipient()}
+
+	for _, size := range []int{0, 1, ChunkSize - 1, ChunkSize, ChunkSize + 1, 2 * ChunkSize, 3*ChunkSize + 7} {
+		msg := make([]byte, size)
+		for i := range msg {
+			msg[i] = byte(i * 7)
+		}
+		file := encrypt(t, msg, recipients...)
+		for i, id := range ids {
+			got, err := decrypt(file, id)
+			if err != nil {
+				t.Fatalf("Size %d, identity %d: decrypt failed: %v", size, i, err)
+			}
+			if !bytes.Equal(got, msg) {
+				t.Fatalf("Size %d, identity %d: plaintext mismatch", size, i)
+			}
+		}
+	}
+
+	if _, err := decrypt(encrypt(t, []byte("x"), recipients...), mustIdentity(t)); !errors.Is(err, ErrNoIdentityMatch) {
+		t.Errorf("Expected ErrNoIdentityMatch, got %v", err)
+	}
+	if _, err := Encrypt(io.Discard); !errors.Is(err, ErrNoRecipients) {
+		t.Errorf("Expected ErrNoRecipients, got %v", err)
+	}
+}
+
+// TestKeyEncoding checks that identities and recipients survive encoding
+func TestKeyEncoding(t *testing.T) {
+	id := mustIdentity(t)
+	parsed, err := ParseIdentity(id.String())
+	if err != nil {
+		t.Fatalf("ParseIdentity failed: %v", err)
+	}
+	if parsed.String() != id.String() {
+		t.Error("Identity changed after encoding")
+	}
+
+	r, err := ParseRecipient(id.Recipient().String())
+	if err != nil {
+		t.Fatalf("ParseRecipient failed: %v", err)
+	}
+	if r.String() != id.Recipient().String() {
+		t.Error("Recipient changed after encoding")
+	}
+	got, err := decrypt(encrypt(t, []byte("parsed"), r), parsed)
+	if err != nil || string(got) != "parsed" {
+		t.Errorf("Parsed keys did not round trip: %v", err)
+	}
+
+	for _, bad := range []string{
+		"",
+		IdentityPrefix + "!!",
+		IdentityPrefix + b64.EncodeToString(make([]byte, identityLen-1)),
+		strings.Replace(id.String(), IdentityPrefix, RecipientPrefix, 1),
+	} {
+		if _, err := ParseIdentity(bad); !errors.Is(err, ErrBadKey) {
+			t.Errorf("Expected ErrBadKey for identity %.30q, got %v", bad, err)
+		}
+	}
+	if _, err := ParseRecipient(RecipientPrefix + b64.EncodeToString(make([]byte, 10))); !errors.Is(err, ErrBadKey) {
+		t.Errorf("Expected ErrBadKey for short recipient, got %v", err)
+	}
+}
+
+// TestHybridKey pins the key combiner
+func TestHybridKey(t *testing.T) {
+	key := hybridKey(bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32),
+		[]byte("ct"), []byte("eph"), []byte("pk"))
+	if got := hex.EncodeToString(key); got != "40c5f0b3504bb26406c6ed5ded4856e2b51cc379a645e750e4bce84ed8045271" {
+		t.Errorf("Unexpected hybrid key %s", got)
+	}
+	if got := chunkNonce(0x0102, true); hex.EncodeToString(got[:]) != "00000000000000000000000000010201" {
+		t.Errorf("Unexpected chunk nonce %x", got)
+	}
+}
+
+// TestTamper checks that modified headers and bodies are rejected
+func TestTamper(t *testing.T) {
+	id := mustIdentity(t)
+	msg := bytes.Repeat([]byte("tamper"), ChunkSize/3)
+	file := encrypt(t, msg, id.Recipient())
+	headerLen := bytes.Index(file, []byte("\n---")) + 1
+	headerLen += bytes.IndexByte(file[headerLen:], '\n') + 1
+	bodyStart := headerLen + payloadNonceLen
+
+	// A changed version line or MAC fails header parsing or authentication
+	for _, off := range []int{0, headerLen - 3} {
+		bad := append([]byte{}, file...)
+		bad[off] ^= 1
+		if _, err := decrypt(bad, id); !errors.Is(err, ErrBadHeader) {
+			t.Errorf("Offset %d: expected ErrBadHeader, got %v", off, err)
+		}
+	}
+
+	// Adding a stanza invalidates the MAC
+	extra := []byte("-> other arg\nAAAA\n")
+	bad := append(append(append([]byte{}, file[:len(versionLine)+1]...), extra...), file[len(versionLine)+1:]...)
+	if _, err := decrypt(bad, id); !errors.Is(err, ErrBadHeader) {
+		t.Errorf("Expected ErrBadHeader for added stanza, got %v", err)
+	}
+
+	// Body modifications and truncations fail authentication
+	cases := map[string][]byte{
+		"flipped":         append(append([]byte{}, file[:bodyStart+10]...), append([]byte{file[bodyStart+10] ^ 1}, file[bodyStart+11:]...)...),
+		"truncated":       file[:len(file)-1],
+		"dropped chunk":   file[:bodyStart+encChunkSize],
+		"changed nonce":   append(append(append([]byte{}, file[:headerLen]...), file[headerLen]^1), file[headerLen+1:]...),
+		"appended":        append(append([]byte{}, file...), 0),
+		"appended tagged": append(append([]byte{}, file...), make([]byte, 16)...),
+	}
+	for name, bad := range cases {
+		if _, err := decrypt(bad, id); !errors.Is(err, ErrBadChunk) {
+			t.Errorf("%s: expected ErrBadChunk, got %v", name, err)
+		}
+	}
+}
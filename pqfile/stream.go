@@ -0,0 +1,180 @@
+package pqfile
+
+import (
+	"errors"
+	"io"
+
+	hiae "github.com/hiae-aead/go-hiae"
+)
+
+// ChunkSize is the plaintext size of every chunk but the last
+const ChunkSize = 64 * 1024
+
+const (
+	payloadNonceLen = 16
+	encChunkSize    = ChunkSize + hiae.TagLen
+	lastChunkFlag   = 0x01
+)
+
+var (
+	// ErrBadChunk is returned when the body is truncated, reordered or modified
+	ErrBadChunk = errors.New("pqfile: bad chunk")
+
+	errWriterClosed = errors.New("pqfile: write after close")
+)
+
+// chunkNonce builds the nonce 0^7 || BE64(counter) || flag, flag being 1 for the final chunk
+// The counter is limited to 2^64-1 chunks; the leading seven bytes are always zero.
+func chunkNonce(counter uint64, last bool) [hiae.NonceLen]byte {
+	var nonce [hiae.NonceLen]byte
+	for i := 0; i < 8; i++ {
+		nonce[hiae.NonceLen-2-i] = byte(counter >> (8 * i))
+	}
+	if last {
+		nonce[hiae.NonceLen-1] = lastChunkFlag
+	}
+	return nonce
+}
+
+// writer seals plaintext into chunks
+type writer struct {
+	dst     io.Writer
+	key     []byte
+	counter uint64
+	buf     []byte // Plaintext of the pending chunk
+	out     []byte // Sealed chunk
+	err     error
+}
+
+func newWriter(dst io.Writer, key []byte) *writer {
+	return &writer{
+		dst: dst,
+		key: key,
+		buf: make([]byte, 0, ChunkSize),
+		out: make([]byte, encChunkSize),
+	}
+}
+
+// flush seals and writes the pending chunk
+func (w *writer) flush(last bool) error {
+	if w.counter == ^uint64(0) {
+		return &hiae.NonceExhaustedError{Source: "chunk"}
+	}
+	n := len(w.buf)
+	nonce := chunkNonce(w.counter, last)
+	if err := hiae.EncryptTo(w.buf, nil, w.key, nonce[:], w.out[:n], w.out[n:n+hiae.TagLen]); err != nil {
+		return err
+	}
+	w.counter++
+	w.buf = w.buf[:0]
+	_, err := w.dst.Write(w.out[:n+hiae.TagLen])
+	return err
+}
+
+// Write buffers p, sealing each chunk once the next byte shows it is not the last
+func (w *writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n := 0
+	for len(p) > 0 {
+		if len(w.buf) == ChunkSize {
+			if w.err = w.flush(false); w.err != nil {
+				return n, w.err
+			}
+		}
+		m := copy(w.buf[len(w.buf):ChunkSize], p)
+		w.buf = w.buf[:len(w.buf)+m]
+		n += m
+		p = p[m:]
+	}
+	return n, nil
+}
+
+// Close seals the final chunk; it does not close the underlying writer
+func (w *writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	w.err = w.flush(true)
+	if w.err == nil {
+		w.err = errWriterClosed
+		return nil
+	}
+	return w.err
+}
+
+// reader opens chunks and returns their plaintext
+type reader struct {
+	src     io.Reader
+	key     []byte
+	counter uint64
+	in      []byte // Sealed chunk plus one byte of lookahead
+	pending int    // Lookahead bytes carried over from the previous read
+	plain   []byte // Decrypted bytes not yet returned
+	buf     []byte
+	err     error
+}
+
+func newReader(src io.Reader, key []byte) *reader {
+	return &reader{
+		src: src,
+		key: key,
+		in:  make([]byte, encChunkSize+1),
+		buf: make([]byte, ChunkSize),
+	}
+}
+
+// Read returns decrypted plaintext, and io.EOF only after an authenticated final chunk
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.readChunk()
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// readChunk opens the next chunk into plain
+// A chunk is final when the input ends within one byte after it.
+func (r *reader) readChunk() error {
+	n, err := io.ReadFull(r.src, r.in[r.pending:])
+	n += r.pending
+	last := false
+	switch {
+	case err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF):
+		last = true
+	case err != nil:
+		return err
+	}
+
+	chunk := r.in[:n]
+	if !last {
+		chunk = r.in[:encChunkSize]
+	}
+	if len(chunk) < hiae.TagLen || (last && len(chunk) == hiae.TagLen && r.counter > 0) {
+		// Only an empty file may end with an empty chunk
+		return ErrBadChunk
+	}
+	if r.counter == ^uint64(0) {
+		return ErrBadChunk
+	}
+
+	m := len(chunk) - hiae.TagLen
+	nonce := chunkNonce(r.counter, last)
+	if err := hiae.DecryptTo(chunk[:m], chunk[m:], nil, r.key, nonce[:], r.buf[:m]); err != nil {
+		return ErrBadChunk
+	}
+	r.counter++
+	r.plain = r.buf[:m]
+
+	if last {
+		return io.EOF
+	}
+	r.in[0] = r.in[encChunkSize]
+	r.pending = 1
+	return nil
+}
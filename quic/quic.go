@@ -0,0 +1,261 @@
+// Package quic provides QUIC-style packet and header protection with HiAE, modelled on RFC 9001
+//
+// Packet keys are derived from a traffic secret with HKDF-Expand-Label over SHA-256:
+//
+//	key = HKDF-Expand-Label(secret, "quic key", "", 32)
+//	iv  = HKDF-Expand-Label(secret, "quic iv",  "", 16)
+//	hp  = HKDF-Expand-Label(secret, "quic hp",  "", 32)
+//
+// The payload nonce is the packet number, left-padded to hiae.NonceLen bytes and XORed
+// with iv, and the header up to and including the packet number is the associated data.
+//
+// Header protection takes a 16-byte sample of the ciphertext, starting 4 bytes after the
+// packet number offset, and uses it as the HiAE nonce under the hp key. The mask is the
+// HiAE encryption of five zero bytes, i.e. the first five bytes of keystream.
+package quic
+
+import (
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+
+	hiae "github.com/hiae-aead/go-hiae"
+)
+
+// Header protection parameters
+const (
+	SampleLen = hiae.NonceLen
+	MaskLen   = 5
+	// sampleOffset is the distance from the packet number offset to the sample
+	sampleOffset = 4
+)
+
+var (
+	// ErrShortPacket is returned when a packet is too short to sample for header protection
+	ErrShortPacket = errors.New("quic: packet too short for header protection sample")
+	// ErrDecryptFailed is returned for packets that fail authentication
+	ErrDecryptFailed = errors.New("quic: packet decryption failed")
+)
+
+// Keys holds the packet protection state derived from one traffic secret
+type Keys struct {
+	secret []byte
+	key    [hiae.KeyLen]byte
+	iv     [hiae.NonceLen]byte
+	hp     [hiae.KeyLen]byte
+}
+
+// hkdfExpandLabel implements HKDF-Expand-Label from RFC 8446 section 7.1 with SHA-256
+func hkdfExpandLabel(secret []byte, label string, context []byte, length int) ([]byte, error) {
+	full := "tls13 " + label
+	info := make([]byte, 0, 4+len(full)+len(context))
+	info = binary.BigEndian.AppendUint16(info, uint16(length))
+	info = append(info, byte(len(full)))
+	info = append(info, full...)
+	info = append(info, byte(len(context)))
+	info = append(info, context...)
+	return hkdf.Expand(sha256.New, secret, string(info), length)
+}
+
+// NewKeys derives packet protection keys from a traffic secret
+func NewKeys(secret []byte) (*Keys, error) {
+	if len(secret) != sha256.Size {
+		return nil, errors.New("quic: secret must be 32 bytes")
+	}
+	k := &Keys{secret: append([]byte{}, secret...)}
+	if err := k.derivePacketKeys(); err != nil {
+		return nil, err
+	}
+	hp, err := hkdfExpandLabel(secret, "quic hp", nil, hiae.KeyLen)
+	if err != nil {
+		return nil, err
+	}
+	copy(k.hp[:], hp)
+	return k, nil
+}
+
+// derivePacketKeys derives the payload key and IV from the current secret
+func (k *Keys) derivePacketKeys() error {
+	key, err := hkdfExpandLabel(k.secret, "quic key", nil, hiae.KeyLen)
+	if err != nil {
+		return err
+	}
+	iv, err := hkdfExpandLabel(k.secret, "quic iv", nil, hiae.NonceLen)
+	if err != nil {
+		return err
+	}
+	copy(k.key[:], key)
+	copy(k.iv[:], iv)
+	return nil
+}
+
+// NextGeneration returns the keys after a key update, as in RFC 9001 section 6
+// The secret is advanced with the "quic ku" label and new payload keys are derived from it;
+// the header protection key is carried over unchanged.
+func (k *Keys) NextGeneration() (*Keys, error) {
+	secret, err := hkdfExpandLabel(k.secret, "quic ku", nil, sha256.Size)
+	if err != nil {
+		return nil, err
+	}
+	next := &Keys{secret: secret, hp: k.hp}
+	if err := next.derivePacketKeys(); err != nil {
+		return nil, err
+	}
+	return next, nil
+}
+
+// nonce returns the payload nonce for a packet number
+func (k *Keys) nonce(pn uint64) [hiae.NonceLen]byte {
+	nonce := k.iv
+	var p [8]byte
+	binary.BigEndian.PutUint64(p[:], pn)
+	for i := range p {
+		nonce[hiae.NonceLen-8+i] ^= p[i]
+	}
+	return nonce
+}
+
+// HeaderMask computes the header protection mask for a ciphertext sample
+func (k *Keys) HeaderMask(sample []byte) ([MaskLen]byte, error) {
+	var mask [MaskLen]byte
+	if len(sample) != SampleLen {
+		return mask, errors.New("quic: sample must be 16 bytes")
+	}
+	var zeros [MaskLen]byte
+	var tag [hiae.TagLen]byte
+	err := hiae.EncryptTo(zeros[:], nil, k.hp[:], sample, mask[:], tag[:])
+	return mask, err
+}
+
+// applyHeaderMask XORs the mask into the first byte and packet number of a packet
+// It returns the packet number length, read from the first byte while it is unprotected.
+func applyHeaderMask(packet []byte, pnOffset int, mask [MaskLen]byte, protect bool) int {
+	bits := byte(0x1f) // Short header
+	if packet[0]&0x80 != 0 {
+		bits = 0x0f // Long header
+	}
+
+	var pnLen int
+	if protect {
+		pnLen = int(packet[0]&0x03) + 1
+		packet[0] ^= mask[0] & bits
+	} else {
+		packet[0] ^= mask[0] & bits
+		pnLen = int(packet[0]&0x03) + 1
+	}
+	for i := 0; i < pnLen; i++ {
+		packet[pnOffset+i] ^= mask[1+i]
+	}
+
+	return pnLen
+}
+
+// SealPacket encrypts payload and applies header protection
+// header must end with the truncated packet number, starting at pnOffset, and its first byte
+// must encode the packet number length. The protected packet is header || ciphertext || tag.
+func (k *Keys) SealPacket(header []byte, pnOffset int, pn uint64, payload []byte) ([]byte, error) {
+	if len(header) == 0 || pnOffset < 1 || pnOffset+int(header[0]&0x03)+1 != len(header) {
+		return nil, errors.New("quic: header must end with the packet number")
+	}
+	total := len(header) + len(payload) + hiae.TagLen
+	if total < pnOffset+sampleOffset+SampleLen {
+		return nil, ErrShortPacket
+	}
+
+	packet := make([]byte, total)
+	copy(packet, header)
+	ct := packet[len(header) : len(header)+len(payload)]
+	tag := packet[len(header)+len(payload):]
+	nonce := k.nonce(pn)
+	if err := hiae.EncryptTo(payload, header, k.key[:], nonce[:], ct, tag); err != nil {
+		return nil, err
+	}
+
+	sample := packet[pnOffset+sampleOffset : pnOffset+sampleOffset+SampleLen]
+	mask, err := k.HeaderMask(sample)
+	if err != nil {
+		return nil, err
+	}
+	applyHeaderMask(packet, pnOffset, mask, true)
+
+	return packet, nil
+}
+
+// OpenPacket removes header protection in place, recovers the full packet number and decrypts the payload
+// largestPN is the largest packet number successfully processed so far in this packet number space.
+// It returns the unprotected header, the payload and the full packet number.
+func (k *Keys) OpenPacket(packet []byte, pnOffset int, largestPN uint64) ([]byte, []byte, uint64, error) {
+	if pnOffset < 1 || len(packet) < pnOffset+sampleOffset+SampleLen {
+		return nil, nil, 0, ErrShortPacket
+	}
+
+	sample := packet[pnOffset+sampleOffset : pnOffset+sampleOffset+SampleLen]
+	mask, err := k.HeaderMask(sample)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	pnLen := applyHeaderMask(packet, pnOffset, mask, false)
+
+	hdrLen := pnOffset + pnLen
+	if len(packet) < hdrLen+hiae.TagLen {
+		return nil, nil, 0, ErrShortPacket
+	}
+	var truncated uint64
+	for _, b := range packet[pnOffset:hdrLen] {
+		truncated = truncated<<8 | uint64(b)
+	}
+	pn := DecodePacketNumber(largestPN, truncated, pnLen*8)
+
+	header := packet[:hdrLen]
+	ct := packet[hdrLen : len(packet)-hiae.TagLen]
+	tag := packet[len(packet)-hiae.TagLen:]
+	payload := make([]byte, len(ct))
+	nonce := k.nonce(pn)
+	if err := hiae.DecryptTo(ct, tag, header, k.key[:], nonce[:], payload); err != nil {
+		return nil, nil, 0, ErrDecryptFailed
+	}
+
+	return header, payload, pn, nil
+}
+
+// PacketNumberLen returns the number of bytes needed to encode pn given the largest acknowledged packet number
+// It follows RFC 9000 Appendix A.2, using at least twice the number of unacknowledged packets.
+// Pass hasAcked as false if no packet has been acknowledged yet.
+func PacketNumberLen(pn, largestAcked uint64, hasAcked bool) int {
+	unacked := pn + 1
+	if hasAcked {
+		unacked = pn - largestAcked
+	}
+	for n := 1; n < 4; n++ {
+		if unacked < 1<<(8*n-1) {
+			return n
+		}
+	}
+	return 4
+}
+
+// AppendPacketNumber appends the pnLen least significant bytes of pn in network byte order
+func AppendPacketNumber(dst []byte, pn uint64, pnLen int) []byte {
+	for i := pnLen - 1; i >= 0; i-- {
+		dst = append(dst, byte(pn>>(8*i)))
+	}
+	return dst
+}
+
+// DecodePacketNumber recovers a full packet number from its truncated encoding, per RFC 9000 Appendix A.3
+func DecodePacketNumber(largestPN, truncatedPN uint64, pnNBits int) uint64 {
+	expected := largestPN + 1
+	win := uint64(1) << pnNBits
+	hwin := win / 2
+	mask := win - 1
+
+	candidate := (expected &^ mask) | truncatedPN
+	if candidate+hwin <= expected && candidate < (1<<62)-win {
+		return candidate + win
+	}
+	if candidate > expected+hwin && candidate >= win {
+		return candidate - win
+	}
+	return candidate
+}
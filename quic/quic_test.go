@@ -0,0 +1,161 @@
+package quic
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+var testSecret = mustHex("c00cf151ca5be075ed0ebfb5c80323c42d6b7db67881289af4008f1f6c357aea")
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic("invalid hex string: " + s)
+	}
+	return b
+}
+
+// TestHKDFExpandLabel checks the label encoding against the client Initial keys of RFC 9001 Appendix A.1
+func TestHKDFExpandLabel(t *testing.T) {
+	vectors := []struct {
+		label    string
+		length   int
+		expected string
+	}{
+		{"quic key", 16, "1f369613dd76d5467730efcbe3b1a22d"},
+		{"quic iv", 12, "fa044b2f42a3fd3b46fb255c"},
+		{"quic hp", 16, "9f50449e04a0e810283a1e9933adedd2"},
+	}
+	for _, tv := range vectors {
+		out, err := hkdfExpandLabel(testSecret, tv.label, nil, tv.length)
+		if err != nil {
+			t.Fatalf("%s: hkdfExpandLabel failed: %v", tv.label, err)
+		}
+		if hex.EncodeToString(out) != tv.expected {
+			t.Errorf("%s: expected %s, got %x", tv.label, tv.expected, out)
+		}
+	}
+}
+
+// TestKeyDerivation pins the HiAE packet keys and the first key update
+func TestKeyDerivation(t *testing.T) {
+	k, err := NewKeys(testSecret)
+	if err != nil {
+		t.Fatalf("NewKeys failed: %v", err)
+	}
+	if hex.EncodeToString(k.key[:]) != "9f81a6a9be9eaa9bdebb3ceba916a2c23d29d6fa91ac3cfb9804c56e41a654a5" ||
+		hex.EncodeToString(k.iv[:]) != "4861a3569afd8b9e428aca2a3db061b4" ||
+		hex.EncodeToString(k.hp[:]) != "e9c80d02fcfb9ad0557fb8a9eb09aebf075d0edf57f253a616d64198d789f31c" {
+		t.Errorf("Unexpected keys: key %x iv %x hp %x", k.key, k.iv, k.hp)
+	}
+
+	next, err := k.NextGeneration()
+	if err != nil {
+		t.Fatalf("NextGeneration failed: %v", err)
+	}
+	if hex.EncodeToString(next.secret) != "4428ffa195ad665b9ebf9456945b99e8ff848512cab93d0426436409047d666c" ||
+		hex.EncodeToString(next.key[:]) != "d0c4b4706a4b21674a171d8b404f34fc4f847797475568336ae667f75239c73a" ||
+		hex.EncodeToString(next.iv[:]) != "bdd178558c0a510a7829a9b058a7322c" {
+		t.Errorf("Unexpected updated keys: secret %x key %x iv %x", next.secret, next.key, next.iv)
+	}
+	if next.hp != k.hp {
+		t.Error("Key update must not change the header protection key")
+	}
+}
+
+// Short-header packets with destination connection ID deadbeef01020304, one per packet number length
+var packetVectors = []struct {
+	pnLen  int
+	pn     uint64
+	packet string
+}{
+	{1, 0x1000000d4, "50deadbeef0102030490f23a1d5442ea8c8ef0c35059c8f8a8712679419c7ba0afe9a3255d51b5a3f50f1b74c160135c3fbc60a5e3f0ea79ff03800acb97e2abbbef"},
+	{2, 0x10000c3d4, "41deadbeef01020304e68319cfa6fbabaf716e7faae972eb13601c8203c797727d829cff98ad78d2f78255c46ab845f3a71e2d267713e878f62b82bbb8ec67680e8ae6"},
+	{3, 0x100b2c3d4, "50deadbeef010203045b023ddb09d6fe4c74cce64334818e8150fce18831b2b33afdba9e7ede6b7f5bf16b64fe232b48cad5e4512d643f2c46fa40b66ba479b575683c0b"},
+	{4, 0x1a1b2c3d4, "56deadbeef010203046316b84cf2967f03a2d042d15c7076a7d0f34687bcd6e26ced36658fd4be625b7a2d3d4b89f5e107cdf1308f485ed4ac0306f72ee8ef8f2caf87ee12"},
+}
+
+const testPayload = "HiAE QUIC payload, long enough to sample"
+
+// TestPacketVectors seals and opens packets with packet number lengths 1 to 4
+func TestPacketVectors(t *testing.T) {
+	k, _ := NewKeys(testSecret)
+	for _, tv := range packetVectors {
+		hdr := []byte{0x40 | byte(tv.pnLen-1), 0xde, 0xad, 0xbe, 0xef, 0x01, 0x02, 0x03, 0x04}
+		hdr = AppendPacketNumber(hdr, tv.pn, tv.pnLen)
+
+		packet, err := k.SealPacket(hdr, 9, tv.pn, []byte(testPayload))
+		if err != nil {
+			t.Fatalf("pnLen %d: SealPacket failed: %v", tv.pnLen, err)
+		}
+		if hex.EncodeToString(packet) != tv.packet {
+			t.Errorf("pnLen %d: packet mismatch\nExpected: %s\nGot:      %s", tv.pnLen, tv.packet, hex.EncodeToString(packet))
+		}
+
+		header, payload, pn, err := k.OpenPacket(mustHex(tv.packet), 9, tv.pn-1)
+		if err != nil {
+			t.Fatalf("pnLen %d: OpenPacket failed: %v", tv.pnLen, err)
+		}
+		if !bytes.Equal(header, hdr) || string(payload) != testPayload || pn != tv.pn {
+			t.Errorf("pnLen %d: got header %x payload %q pn %x", tv.pnLen, header, payload, pn)
+		}
+	}
+}
+
+// TestPacketTamper verifies that header and payload modifications are rejected
+func TestPacketTamper(t *testing.T) {
+	k, _ := NewKeys(testSecret)
+	packet := mustHex(packetVectors[1].packet)
+	for i := range packet {
+		bad := append([]byte{}, packet...)
+		bad[i] ^= 0x04
+		if _, _, _, err := k.OpenPacket(bad, 9, packetVectors[1].pn-1); err == nil {
+			t.Errorf("Byte %d: tampered packet accepted", i)
+		}
+	}
+
+	next, _ := k.NextGeneration()
+	if _, _, _, err := next.OpenPacket(append([]byte{}, packet...), 9, packetVectors[1].pn-1); !errors.Is(err, ErrDecryptFailed) {
+		t.Errorf("Expected ErrDecryptFailed with updated keys, got %v", err)
+	}
+
+	hdr := AppendPacketNumber([]byte{0x40, 0xaa}, 1, 1)
+	if _, err := k.SealPacket(hdr, 2, 1, []byte("x")); !errors.Is(err, ErrShortPacket) {
+		t.Errorf("Expected ErrShortPacket, got %v", err)
+	}
+}
+
+// TestPacketNumberEncoding checks truncation and recovery of packet numbers
+func TestPacketNumberEncoding(t *testing.T) {
+	// Example from RFC 9000 Appendix A.3
+	if pn := DecodePacketNumber(0xa82f30ea, 0x9b32, 16); pn != 0xa82f9b32 {
+		t.Errorf("Expected 0xa82f9b32, got %x", pn)
+	}
+	// Examples from RFC 9000 Section 17.1
+	if n := PacketNumberLen(0xac5c02, 0xabe8b3, true); n != 2 {
+		t.Errorf("Expected 2 bytes, got %d", n)
+	}
+	if n := PacketNumberLen(0xace8fe, 0xabe8b3, true); n != 3 {
+		t.Errorf("Expected 3 bytes, got %d", n)
+	}
+	if n := PacketNumberLen(0, 0, false); n != 1 {
+		t.Errorf("Expected 1 byte, got %d", n)
+	}
+
+	for _, largest := range []uint64{0, 200, 0xffff, 0x12345678, 1 << 40} {
+		for _, delta := range []uint64{1, 2, 100, 30000} {
+			pn := largest + delta
+			pnLen := PacketNumberLen(pn, largest, true)
+			enc := AppendPacketNumber(nil, pn, pnLen)
+			var truncated uint64
+			for _, b := range enc {
+				truncated = truncated<<8 | uint64(b)
+			}
+			if got := DecodePacketNumber(largest, truncated, pnLen*8); got != pn {
+				t.Errorf("largest %d pn %d: decoded %d", largest, pn, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,224 @@
+// Package record implements TLS 1.3-style record protection with HiAE
+//
+// Each record is sealed as
+//
+//	header    = opaque_type(1) || legacy_version(2) || length(2)
+//	inner     = content || content_type(1) || zeros(padding)
+//	encrypted = HiAE(key, nonce, ad = header, msg = inner) || tag
+//
+// where the nonce is the 64-bit record sequence number, left-padded with zeros to
+// hiae.NonceLen bytes and XORed with a static IV, as in RFC 8446 section 5.3.
+package record
+
+import (
+	"encoding/binary"
+	"errors"
+
+	hiae "github.com/hiae-aead/go-hiae"
+)
+
+// Record layer parameters
+const (
+	HeaderLen = 5 // opaque_type || legacy_version || length
+
+	// MaxPlaintext is the largest inner plaintext (content, content type and padding)
+	MaxPlaintext = 1<<14 + 1
+	// MaxCiphertext is the largest encrypted record body, including the tag
+	MaxCiphertext = 1<<14 + 256
+
+	// OpaqueType is the outer content type of every protected record
+	OpaqueType = 0x17
+	// LegacyVersion is the outer protocol version of every protected record
+	LegacyVersion = 0x0303
+)
+
+// Content types carried inside the encrypted inner plaintext
+const (
+	TypeChangeCipherSpec = 20
+	TypeAlert            = 21
+	TypeHandshake        = 22
+	TypeApplicationData  = 23
+)
+
+var (
+	// ErrSequenceOverflow is returned once all 2^64 sequence numbers have been used
+	ErrSequenceOverflow = errors.New("record: sequence number exhausted")
+	// ErrRecordTooLarge is returned for records above the TLS 1.3 size limits
+	ErrRecordTooLarge = errors.New("record: record too large")
+	// ErrBadRecord is returned for records that are malformed or fail authentication
+	ErrBadRecord = errors.New("record: bad record")
+)
+
+// state holds the key, IV and sequence number of one direction
+type state struct {
+	key       [hiae.KeyLen]byte
+	iv        [hiae.NonceLen]byte
+	seq       uint64
+	exhausted bool
+}
+
+func newState(key, iv []byte) (state, error) {
+	var s state
+	if len(key) != hiae.KeyLen {
+		return s, errors.New("record: key must be 32 bytes")
+	}
+	if len(iv) != hiae.NonceLen {
+		return s, errors.New("record: iv must be 16 bytes")
+	}
+	copy(s.key[:], key)
+	copy(s.iv[:], iv)
+	return s, nil
+}
+
+// nextNonce writes the nonce for the current sequence number and advances it
+func (s *state) nextNonce(nonce []byte) error {
+	if s.exhausted {
+		return ErrSequenceOverflow
+	}
+	copy(nonce, s.iv[:])
+	var seq [8]byte
+	binary.BigEndian.PutUint64(seq[:], s.seq)
+	for i := range seq {
+		nonce[hiae.NonceLen-8+i] ^= seq[i]
+	}
+	if s.seq == ^uint64(0) {
+		s.exhausted = true
+	} else {
+		s.seq++
+	}
+	return nil
+}
+
+// Sealer protects outgoing records
+type Sealer struct {
+	state
+}
+
+// NewSealer returns a Sealer with the given traffic key and static IV, starting at sequence number 0
+func NewSealer(key, iv []byte) (*Sealer, error) {
+	s, err := newState(key, iv)
+	if err != nil {
+		return nil, err
+	}
+	return &Sealer{state: s}, nil
+}
+
+// Seq returns the sequence number of the next record
+func (s *Sealer) Seq() uint64 {
+	return s.seq
+}
+
+// Seal appends a protected record carrying content of the given type to dst
+// padding zero bytes are appended to the inner plaintext to hide the content length.
+func (s *Sealer) Seal(dst []byte, contentType uint8, content []byte, padding int) ([]byte, error) {
+	if contentType == 0 {
+		return nil, errors.New("record: content type must not be zero")
+	}
+	if padding < 0 {
+		return nil, errors.New("record: padding must not be negative")
+	}
+	innerLen := len(content) + 1 + padding
+	if innerLen > MaxPlaintext {
+		return nil, ErrRecordTooLarge
+	}
+
+	var nonce [hiae.NonceLen]byte
+	if err := s.nextNonce(nonce[:]); err != nil {
+		return nil, err
+	}
+
+	total := HeaderLen + innerLen + hiae.TagLen
+	start := len(dst)
+	dst = append(dst, make([]byte, total)...)
+	rec := dst[start:]
+
+	hdr := rec[:HeaderLen]
+	hdr[0] = OpaqueType
+	binary.BigEndian.PutUint16(hdr[1:3], LegacyVersion)
+	binary.BigEndian.PutUint16(hdr[3:5], uint16(innerLen+hiae.TagLen))
+
+	inner := rec[HeaderLen : HeaderLen+innerLen]
+	copy(inner, content)
+	inner[len(content)] = contentType
+
+	tag := rec[HeaderLen+innerLen:]
+	if err := hiae.EncryptTo(inner, hdr, s.key[:], nonce[:], inner, tag); err != nil {
+		return nil, err
+	}
+
+	return dst, nil
+}
+
+// Opener verifies and decrypts incoming records
+type Opener struct {
+	state
+}
+
+// NewOpener returns an Opener with the given traffic key and static IV, starting at sequence number 0
+func NewOpener(key, iv []byte) (*Opener, error) {
+	s, err := newState(key, iv)
+	if err != nil {
+		return nil, err
+	}
+	return &Opener{state: s}, nil
+}
+
+// Seq returns the sequence number expected for the next record
+func (o *Opener) Seq() uint64 {
+	return o.seq
+}
+
+// Open verifies a complete protected record and appends its content to dst
+// It returns the inner content type and the extended dst. The sequence number only
+// advances for records that authenticate.
+func (o *Opener) Open(dst, record []byte) (uint8, []byte, error) {
+	if len(record) < HeaderLen {
+		return 0, nil, ErrBadRecord
+	}
+	hdr := record[:HeaderLen]
+	if hdr[0] != OpaqueType || binary.BigEndian.Uint16(hdr[1:3]) != LegacyVersion {
+		return 0, nil, ErrBadRecord
+	}
+	length := int(binary.BigEndian.Uint16(hdr[3:5]))
+	if length > MaxCiphertext {
+		return 0, nil, ErrRecordTooLarge
+	}
+	if length != len(record)-HeaderLen || length < hiae.TagLen+1 {
+		return 0, nil, ErrBadRecord
+	}
+	if o.exhausted {
+		return 0, nil, ErrSequenceOverflow
+	}
+
+	body := record[HeaderLen:]
+	ct := body[:length-hiae.TagLen]
+	tag := body[length-hiae.TagLen:]
+	if len(ct) > MaxPlaintext {
+		return 0, nil, ErrRecordTooLarge
+	}
+
+	var nonce [hiae.NonceLen]byte
+	seq, exhausted := o.seq, o.exhausted
+	if err := o.nextNonce(nonce[:]); err != nil {
+		return 0, nil, err
+	}
+
+	start := len(dst)
+	dst = append(dst, make([]byte, len(ct))...)
+	inner := dst[start:]
+	if err := hiae.DecryptTo(ct, tag, hdr, o.key[:], nonce[:], inner); err != nil {
+		o.seq, o.exhausted = seq, exhausted
+		return 0, nil, ErrBadRecord
+	}
+
+	// Strip the zero padding; the last non-zero byte is the content type
+	i := len(inner) - 1
+	for i >= 0 && inner[i] == 0 {
+		i--
+	}
+	if i < 0 {
+		return 0, nil, ErrBadRecord
+	}
+
+	return inner[i], dst[:start+i], nil
+}
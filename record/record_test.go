@@ -0,0 +1,146 @@
+package record
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+var (
+	testKey = mustHex("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	testIV  = mustHex("a0a1a2a3a4a5a6a7a8a9aaabacadaeaf")
+)
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic("invalid hex string: " + s)
+	}
+	return b
+}
+
+// Records sealed in order starting at sequence number 0
+var recordVectors = []struct {
+	name        string
+	contentType uint8
+	content     string
+	padding     int
+	record      string
+}{
+	{
+		name:        "Handshake, seq 0",
+		contentType: TypeHandshake,
+		content:     "hello",
+		record:      "17030300168a3c5543293665b5de971b5d6f9ab1fd08f389d82099",
+	},
+	{
+		name:        "Application data with padding, seq 1",
+		contentType: TypeApplicationData,
+		content:     "HiAE record layer",
+		padding:     3,
+		record:      "17030300255a130a3d00a755197c112f923b7c8c7fdfb7e621bb7a32487e5d2f5b64c3ab3cd89e1777b9",
+	},
+	{
+		name:        "Empty alert, seq 2",
+		contentType: TypeAlert,
+		content:     "",
+		record:      "17030300117048ddea32bca203357e016293b13081fc",
+	},
+}
+
+// TestRecordVectors checks sealing against fixed records and opens them again
+func TestRecordVectors(t *testing.T) {
+	s, err := NewSealer(testKey, testIV)
+	if err != nil {
+		t.Fatalf("NewSealer failed: %v", err)
+	}
+	o, err := NewOpener(testKey, testIV)
+	if err != nil {
+		t.Fatalf("NewOpener failed: %v", err)
+	}
+
+	for _, tv := range recordVectors {
+		rec, err := s.Seal(nil, tv.contentType, []byte(tv.content), tv.padding)
+		if err != nil {
+			t.Fatalf("%s: Seal failed: %v", tv.name, err)
+		}
+		if hex.EncodeToString(rec) != tv.record {
+			t.Errorf("%s: record mismatch\nExpected: %s\nGot:      %s", tv.name, tv.record, hex.EncodeToString(rec))
+		}
+
+		typ, content, err := o.Open(nil, mustHex(tv.record))
+		if err != nil {
+			t.Fatalf("%s: Open failed: %v", tv.name, err)
+		}
+		if typ != tv.contentType || string(content) != tv.content {
+			t.Errorf("%s: opened type %d content %q", tv.name, typ, content)
+		}
+	}
+}
+
+// TestRecordTamper verifies that modified, replayed and reordered records are rejected
+func TestRecordTamper(t *testing.T) {
+	s, _ := NewSealer(testKey, testIV)
+	first, _ := s.Seal(nil, TypeApplicationData, []byte("first"), 0)
+	second, _ := s.Seal(nil, TypeApplicationData, []byte("second"), 0)
+
+	for i := range first {
+		o, _ := NewOpener(testKey, testIV)
+		rec := append([]byte{}, first...)
+		rec[i] ^= 0x01
+		if _, _, err := o.Open(nil, rec); !errors.Is(err, ErrBadRecord) {
+			t.Errorf("Byte %d: expected ErrBadRecord, got %v", i, err)
+		}
+		if o.Seq() != 0 {
+			t.Errorf("Byte %d: sequence advanced on failure", i)
+		}
+	}
+
+	o, _ := NewOpener(testKey, testIV)
+	if _, _, err := o.Open(nil, second); err == nil {
+		t.Error("Out-of-order record should fail")
+	}
+	if _, _, err := o.Open(nil, first); err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if _, _, err := o.Open(nil, first); err == nil {
+		t.Error("Replayed record should fail")
+	}
+	if _, _, err := o.Open(nil, first[:len(first)-1]); err == nil {
+		t.Error("Truncated record should fail")
+	}
+}
+
+// TestRecordLimits verifies size limits and sequence number exhaustion
+func TestRecordLimits(t *testing.T) {
+	s, _ := NewSealer(testKey, testIV)
+	if _, err := s.Seal(nil, TypeApplicationData, make([]byte, 1<<14), 1); !errors.Is(err, ErrRecordTooLarge) {
+		t.Errorf("Expected ErrRecordTooLarge, got %v", err)
+	}
+	rec, err := s.Seal([]byte("prefix"), TypeApplicationData, make([]byte, 1<<14), 0)
+	if err != nil {
+		t.Fatalf("Seal of maximum record failed: %v", err)
+	}
+	if !bytes.HasPrefix(rec, []byte("prefix")) {
+		t.Error("Seal did not append to dst")
+	}
+
+	s.seq = ^uint64(0)
+	last, err := s.Seal(nil, TypeApplicationData, []byte("last"), 0)
+	if err != nil {
+		t.Fatalf("Seal with the last sequence number failed: %v", err)
+	}
+	if _, err := s.Seal(nil, TypeApplicationData, []byte("wrap"), 0); !errors.Is(err, ErrSequenceOverflow) {
+		t.Errorf("Expected ErrSequenceOverflow, got %v", err)
+	}
+
+	o, _ := NewOpener(testKey, testIV)
+	o.seq = ^uint64(0)
+	if _, content, err := o.Open(nil, last); err != nil || string(content) != "last" {
+		t.Fatalf("Open with the last sequence number failed: %v", err)
+	}
+	if _, _, err := o.Open(nil, last); !errors.Is(err, ErrSequenceOverflow) {
+		t.Errorf("Expected ErrSequenceOverflow, got %v", err)
+	}
+}
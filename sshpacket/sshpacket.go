@@ -0,0 +1,203 @@
+// Package sshpacket implements SSH binary packets with encrypted lengths, after OpenSSH's chacha20-poly1305
+//
+// Each direction uses a 64-byte key: the first 32 bytes key the payload, the last 32 bytes
+// key the packet length. The nonce is the 32-bit packet sequence number, big-endian in the
+// last bytes of a zero hiae.NonceLen-byte block. A packet is sent as
+//
+//	enclen = packet_length XOR HiAE-keystream(length key, nonce)[:4]
+//	packet = enclen || HiAE(payload key, nonce, ad = enclen, msg = padding_length || payload || padding) || tag
+//
+// The length is decrypted only to know how much to read; the tag, which covers the
+// encrypted length, is verified before anything else in the packet is parsed.
+package sshpacket
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	hiae "github.com/hiae-aead/go-hiae"
+)
+
+// Packet parameters
+const (
+	KeyLen = 2 * hiae.KeyLen
+
+	// MaxPacketLen is the largest accepted packet_length, as recommended by RFC 4253 section 6.1
+	MaxPacketLen = 35000
+
+	lengthLen  = 4
+	blockSize  = 8
+	minPadding = 4
+)
+
+var (
+	// ErrRekeyRequired is returned before the 32-bit sequence number would wrap around
+	ErrRekeyRequired = errors.New("sshpacket: sequence number exhausted, rekey required")
+	// ErrBadPacket is returned for packets that are malformed or fail authentication
+	ErrBadPacket = errors.New("sshpacket: bad packet")
+)
+
+// direction holds the keys and sequence number of one direction
+type direction struct {
+	payloadKey [hiae.KeyLen]byte
+	lengthKey  [hiae.KeyLen]byte
+	seq        uint32
+	exhausted  bool
+}
+
+func newDirection(key []byte) (direction, error) {
+	var d direction
+	if len(key) != KeyLen {
+		return d, errors.New("sshpacket: key must be 64 bytes")
+	}
+	copy(d.payloadKey[:], key[:hiae.KeyLen])
+	copy(d.lengthKey[:], key[hiae.KeyLen:])
+	return d, nil
+}
+
+// nextNonce returns the nonce for the current sequence number and advances it
+func (d *direction) nextNonce() ([hiae.NonceLen]byte, error) {
+	var nonce [hiae.NonceLen]byte
+	if d.exhausted {
+		return nonce, ErrRekeyRequired
+	}
+	binary.BigEndian.PutUint32(nonce[hiae.NonceLen-4:], d.seq)
+	if d.seq == ^uint32(0) {
+		d.exhausted = true
+	} else {
+		d.seq++
+	}
+	return nonce, nil
+}
+
+// lengthMask returns the keystream that hides the packet length
+// The first HiAE ciphertext block is the plaintext XORed with a keystream, so encrypting
+// zeros yields the mask.
+func (d *direction) lengthMask(nonce []byte) ([lengthLen]byte, error) {
+	var zeros, mask [lengthLen]byte
+	var tag [hiae.TagLen]byte
+	err := hiae.EncryptTo(zeros[:], nil, d.lengthKey[:], nonce, mask[:], tag[:])
+	return mask, err
+}
+
+// Transport reads and writes encrypted SSH packets over an io.ReadWriter
+// A Transport is not safe for concurrent use by multiple readers or multiple writers.
+type Transport struct {
+	rw io.ReadWriter
+	w  direction
+	r  direction
+
+	rbuf []byte
+}
+
+// New returns a Transport writing with writeKey and reading with readKey
+func New(rw io.ReadWriter, writeKey, readKey []byte) (*Transport, error) {
+	w, err := newDirection(writeKey)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newDirection(readKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Transport{rw: rw, w: w, r: r}, nil
+}
+
+// WritePacket encrypts payload into a single packet with random padding
+func (t *Transport) WritePacket(payload []byte) error {
+	padLen := blockSize - (1+len(payload))%blockSize
+	if padLen < minPadding {
+		padLen += blockSize
+	}
+	packetLen := 1 + len(payload) + padLen
+	if packetLen > MaxPacketLen {
+		return errors.New("sshpacket: payload too large")
+	}
+
+	nonce, err := t.w.nextNonce()
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, lengthLen+packetLen+hiae.TagLen)
+	enclen := buf[:lengthLen]
+	body := buf[lengthLen : lengthLen+packetLen]
+	tag := buf[lengthLen+packetLen:]
+
+	mask, err := t.w.lengthMask(nonce[:])
+	if err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint32(enclen, uint32(packetLen))
+	for i := range enclen {
+		enclen[i] ^= mask[i]
+	}
+
+	body[0] = byte(padLen)
+	copy(body[1:], payload)
+	if _, err := rand.Read(body[1+len(payload):]); err != nil {
+		return err
+	}
+	if err := hiae.EncryptTo(body, enclen, t.w.payloadKey[:], nonce[:], body, tag); err != nil {
+		return err
+	}
+
+	_, err = t.rw.Write(buf)
+	return err
+}
+
+// ReadPacket reads, authenticates and decrypts the next packet, returning its payload
+// A stream that ends before a complete packet returns io.ErrUnexpectedEOF; one that ends
+// cleanly between packets returns io.EOF.
+func (t *Transport) ReadPacket() ([]byte, error) {
+	var enclen [lengthLen]byte
+	if _, err := io.ReadFull(t.rw, enclen[:]); err != nil {
+		return nil, err
+	}
+
+	nonce, err := t.r.nextNonce()
+	if err != nil {
+		return nil, err
+	}
+	mask, err := t.r.lengthMask(nonce[:])
+	if err != nil {
+		return nil, err
+	}
+	var plainLen [lengthLen]byte
+	for i := range plainLen {
+		plainLen[i] = enclen[i] ^ mask[i]
+	}
+	packetLen := binary.BigEndian.Uint32(plainLen[:])
+	if packetLen < blockSize || packetLen > MaxPacketLen || packetLen%blockSize != 0 {
+		return nil, ErrBadPacket
+	}
+
+	if cap(t.rbuf) < int(packetLen)+hiae.TagLen {
+		t.rbuf = make([]byte, int(packetLen)+hiae.TagLen)
+	}
+	buf := t.rbuf[:int(packetLen)+hiae.TagLen]
+	if _, err := io.ReadFull(t.rw, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	body := buf[:packetLen]
+	tag := buf[packetLen:]
+	if err := hiae.DecryptTo(body, tag, enclen[:], t.r.payloadKey[:], nonce[:], body); err != nil {
+		return nil, ErrBadPacket
+	}
+
+	// Only authenticated data is parsed from here on
+	padLen := int(body[0])
+	if padLen < minPadding || 1+padLen > len(body) {
+		return nil, ErrBadPacket
+	}
+	payload := make([]byte, len(body)-1-padLen)
+	copy(payload, body[1:])
+
+	return payload, nil
+}
@@ -0,0 +1,136 @@
+package sshpacket
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+var (
+	clientKey = bytes.Repeat([]byte{0x0c}, KeyLen)
+	serverKey = bytes.Repeat([]byte{0x05}, KeyLen)
+)
+
+// loopback returns a Transport whose writes can be read back by a peer Transport over the same buffer
+func loopback(t *testing.T) (*Transport, *Transport, *bytes.Buffer) {
+	var buf bytes.Buffer
+	w, err := New(&buf, clientKey, serverKey)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	r, err := New(&buf, serverKey, clientKey)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return w, r, &buf
+}
+
+// TestRoundTrip sends packets of various sizes in both directions over net.Pipe
+func TestRoundTrip(t *testing.T) {
+	c1, c2 := net.Pipe()
+	client, _ := New(c1, clientKey, serverKey)
+	server, _ := New(c2, serverKey, clientKey)
+
+	sizes := []int{0, 1, 3, 4, 7, 8, 255, 256, 1000, 32768}
+	go func() {
+		for _, n := range sizes {
+			client.WritePacket(bytes.Repeat([]byte{byte(n)}, n))
+		}
+		reply, err := client.ReadPacket()
+		if err != nil || string(reply) != "done" {
+			c1.Close()
+		}
+	}()
+
+	for _, n := range sizes {
+		payload, err := server.ReadPacket()
+		if err != nil {
+			t.Fatalf("size %d: ReadPacket failed: %v", n, err)
+		}
+		if !bytes.Equal(payload, bytes.Repeat([]byte{byte(n)}, n)) {
+			t.Fatalf("size %d: payload mismatch", n)
+		}
+	}
+	if err := server.WritePacket([]byte("done")); err != nil {
+		t.Fatalf("WritePacket failed: %v", err)
+	}
+}
+
+// TestLengthIsEncrypted verifies that the packet length is not sent in the clear
+func TestLengthIsEncrypted(t *testing.T) {
+	w, _, buf := loopback(t)
+	if err := w.WritePacket(make([]byte, 11)); err != nil {
+		t.Fatalf("WritePacket failed: %v", err)
+	}
+	// 1 + 11 + 4 = 16 bytes of packet_length in the clear would be 00000010
+	if bytes.Equal(buf.Bytes()[:4], []byte{0, 0, 0, 16}) {
+		t.Fatal("Packet length was sent in the clear")
+	}
+	if buf.Len() != 4+16+16 {
+		t.Fatalf("Unexpected packet size %d", buf.Len())
+	}
+}
+
+// TestTamper verifies that any modified byte, including the encrypted length, is rejected
+func TestTamper(t *testing.T) {
+	w, _, buf := loopback(t)
+	w.WritePacket([]byte("authenticated payload"))
+	packet := append([]byte{}, buf.Bytes()...)
+
+	for i := range packet {
+		bad := append([]byte{}, packet...)
+		bad[i] ^= 0x01
+		r, _ := New(bytes.NewBuffer(bad), serverKey, clientKey)
+		payload, err := r.ReadPacket()
+		if err == nil {
+			t.Fatalf("Byte %d: tampered packet accepted: %q", i, payload)
+		}
+	}
+}
+
+// TestTruncation verifies that a stream cut inside a packet is reported
+func TestTruncation(t *testing.T) {
+	w, _, buf := loopback(t)
+	w.WritePacket([]byte("truncated"))
+	packet := append([]byte{}, buf.Bytes()...)
+
+	for _, n := range []int{2, 4, 10, len(packet) - 1} {
+		r, _ := New(bytes.NewBuffer(packet[:n]), serverKey, clientKey)
+		if _, err := r.ReadPacket(); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("Cut at %d: expected io.ErrUnexpectedEOF, got %v", n, err)
+		}
+	}
+
+	r, _ := New(bytes.NewBuffer(packet), serverKey, clientKey)
+	if _, err := r.ReadPacket(); err != nil {
+		t.Fatalf("ReadPacket failed: %v", err)
+	}
+	if _, err := r.ReadPacket(); err != io.EOF {
+		t.Errorf("Expected io.EOF between packets, got %v", err)
+	}
+}
+
+// TestReorderAndExhaustion verifies sequence number binding and the rekey limit
+func TestReorderAndExhaustion(t *testing.T) {
+	w, _, buf := loopback(t)
+	w.WritePacket([]byte("first"))
+	first := append([]byte{}, buf.Bytes()...)
+	buf.Reset()
+	w.WritePacket([]byte("second"))
+	second := append([]byte{}, buf.Bytes()...)
+
+	r, _ := New(bytes.NewBuffer(append(second, first...)), serverKey, clientKey)
+	if _, err := r.ReadPacket(); err == nil {
+		t.Error("Packet with the wrong sequence number accepted")
+	}
+
+	w.w.seq = ^uint32(0)
+	if err := w.WritePacket(nil); err != nil {
+		t.Fatalf("WritePacket with the last sequence number failed: %v", err)
+	}
+	if err := w.WritePacket(nil); !errors.Is(err, ErrRekeyRequired) {
+		t.Fatalf("Expected ErrRekeyRequired, got %v", err)
+	}
+}
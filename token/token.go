@@ -0,0 +1,146 @@
+// Package token issues and parses opaque, expiring tokens sealed with HiAE
+//
+// A token is the unpadded URL-safe base64 encoding of
+//
+//	version (1) || issued-at (8) || expiry (8) || nonce (16) || ciphertext || tag
+//
+// Timestamps are big-endian Unix seconds. Everything before the ciphertext is the header and
+// is authenticated as associated data, so the timestamps cannot be changed without
+// invalidating the tag. Parse verifies the tag before looking at the timestamps, so a forged
+// token is always reported as invalid rather than expired.
+package token
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+
+	hiae "github.com/hiae-aead/go-hiae"
+)
+
+const (
+	// Version is the token format version written by Issue
+	Version = 0x01
+
+	headerLen = 1 + 8 + 8 + hiae.NonceLen
+	// Overhead is the number of bytes a token adds to the payload, before encoding
+	Overhead = headerLen + hiae.TagLen
+)
+
+var (
+	// ErrMalformed is returned for tokens that are not valid base64 or are too short
+	ErrMalformed = errors.New("token: malformed token")
+	// ErrVersion is returned for tokens with an unknown version
+	ErrVersion = errors.New("token: unsupported version")
+	// ErrInvalid is returned for tokens that fail authentication
+	ErrInvalid = errors.New("token: invalid token")
+	// ErrExpired is returned for authentic tokens past their expiry
+	ErrExpired = errors.New("token: token expired")
+	// ErrNotYetValid is returned for authentic tokens issued in the future
+	ErrNotYetValid = errors.New("token: token not yet valid")
+)
+
+// encoding is strict so that every token has exactly one string form; Parse also rejects the
+// CR and LF characters the decoder would otherwise skip
+var encoding = base64.RawURLEncoding.Strict()
+
+// Token is a parsed, authenticated token
+type Token struct {
+	Payload   []byte
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// Codec issues and parses tokens under a single key
+type Codec struct {
+	key [hiae.KeyLen]byte
+
+	// Now returns the current time. It defaults to time.Now.
+	Now func() time.Time
+	// Skew is the clock-skew tolerance applied to both the issued-at and expiry checks
+	Skew time.Duration
+}
+
+// New returns a Codec using key
+func New(key []byte) (*Codec, error) {
+	if len(key) != hiae.KeyLen {
+		return nil, errors.New("token: key must be 32 bytes")
+	}
+	c := &Codec{}
+	copy(c.key[:], key)
+	return c, nil
+}
+
+func (c *Codec) now() time.Time {
+	if c.Now != nil {
+		return c.Now()
+	}
+	return time.Now()
+}
+
+// Issue seals payload into a token valid for ttl from now, rounded up to a whole second
+func (c *Codec) Issue(payload []byte, ttl time.Duration) (string, error) {
+	if ttl <= 0 {
+		return "", errors.New("token: ttl must be positive")
+	}
+	now := c.now()
+
+	buf := make([]byte, Overhead+len(payload))
+	buf[0] = Version
+	binary.BigEndian.PutUint64(buf[1:9], uint64(now.Unix()))
+	// Round the expiry up, so a token is never issued already expired
+	exp := now.Add(ttl)
+	expSec := exp.Unix()
+	if exp.Nanosecond() != 0 {
+		expSec++
+	}
+	binary.BigEndian.PutUint64(buf[9:17], uint64(expSec))
+	nonce := buf[17:headerLen]
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	ct := buf[headerLen : headerLen+len(payload)]
+	if err := hiae.EncryptTo(payload, buf[:headerLen], c.key[:], nonce, ct, buf[headerLen+len(payload):]); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(buf), nil
+}
+
+// Parse authenticates a token, then checks its timestamps against the clock
+func (c *Codec) Parse(tok string) (*Token, error) {
+	if strings.ContainsAny(tok, "\r\n") {
+		return nil, ErrMalformed
+	}
+	buf, err := encoding.DecodeString(tok)
+	if err != nil || len(buf) < Overhead {
+		return nil, ErrMalformed
+	}
+	if buf[0] != Version {
+		return nil, ErrVersion
+	}
+
+	n := len(buf) - Overhead
+	header := buf[:headerLen]
+	payload := make([]byte, n)
+	if err := hiae.DecryptTo(buf[headerLen:headerLen+n], buf[headerLen+n:], header, c.key[:], header[17:], payload); err != nil {
+		return nil, ErrInvalid
+	}
+
+	t := &Token{
+		Payload:   payload,
+		IssuedAt:  time.Unix(int64(binary.BigEndian.Uint64(header[1:9])), 0),
+		ExpiresAt: time.Unix(int64(binary.BigEndian.Uint64(header[9:17])), 0),
+	}
+	now := c.now()
+	if t.IssuedAt.After(now.Add(c.Skew)) {
+		return nil, ErrNotYetValid
+	}
+	if !now.Add(-c.Skew).Before(t.ExpiresAt) {
+		return nil, ErrExpired
+	}
+	return t, nil
+}
@@ -0,0 +1,186 @@
+package token
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	hiae "github.com/hiae-aead/go-hiae"
+)
+
+var testKey = bytes.Repeat([]byte{0x42}, 32)
+
+func testCodec(t *testing.T, now *time.Time) *Codec {
+	t.Helper()
+	c, err := New(testKey)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	c.Now = func() time.Time { return *now }
+	return c
+}
+
+func TestRoundTrip(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	c := testCodec(t, &now)
+	for _, payload := range [][]byte{nil, []byte("user:42"), bytes.Repeat([]byte{0xaa}, 1000)} {
+		tok, err := c.Issue(payload, time.Hour)
+		if err != nil {
+			t.Fatalf("Issue failed: %v", err)
+		}
+		if strings.ContainsAny(tok, "+/=") {
+			t.Errorf("Token %q is not URL-safe", tok)
+		}
+		got, err := c.Parse(tok)
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		if !bytes.Equal(got.Payload, payload) {
+			t.Errorf("Payload = %x, want %x", got.Payload, payload)
+		}
+		if !got.IssuedAt.Equal(now) || !got.ExpiresAt.Equal(now.Add(time.Hour)) {
+			t.Errorf("Timestamps = %v, %v", got.IssuedAt, got.ExpiresAt)
+		}
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	c := testCodec(t, &now)
+	tok, err := c.Issue([]byte("reset"), time.Minute)
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	issued := now
+
+	for _, tc := range []struct {
+		offset time.Duration
+		skew   time.Duration
+		want   error
+	}{
+		{59 * time.Second, 0, nil},
+		{time.Minute, 0, ErrExpired},
+		{time.Minute, 30 * time.Second, nil},
+		{2 * time.Minute, 30 * time.Second, ErrExpired},
+		{-time.Second, 0, ErrNotYetValid},
+		{-time.Second, 5 * time.Second, nil},
+	} {
+		now = issued.Add(tc.offset)
+		c.Skew = tc.skew
+		if _, err := c.Parse(tok); !errors.Is(err, tc.want) {
+			t.Errorf("Parse at %v with skew %v: error = %v, want %v", tc.offset, tc.skew, err, tc.want)
+		}
+	}
+}
+
+func TestTagCheckedFirst(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	c := testCodec(t, &now)
+	tok, err := c.Issue([]byte("session"), time.Minute)
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	raw, _ := encoding.DecodeString(tok)
+
+	// Extending the expiry breaks the tag
+	ext := append([]byte{}, raw...)
+	binary.BigEndian.PutUint64(ext[9:17], uint64(now.Add(time.Hour).Unix()))
+	if _, err := c.Parse(encoding.EncodeToString(ext)); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Extended expiry error = %v, want ErrInvalid", err)
+	}
+
+	// An expired forgery is reported as invalid, not expired
+	now = now.Add(time.Hour)
+	forged := append([]byte{}, raw...)
+	forged[len(forged)-1] ^= 1
+	if _, err := c.Parse(encoding.EncodeToString(forged)); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Expired forgery error = %v, want ErrInvalid", err)
+	}
+	if _, err := c.Parse(tok); !errors.Is(err, ErrExpired) {
+		t.Errorf("Expired token error = %v, want ErrExpired", err)
+	}
+
+	other, _ := New(bytes.Repeat([]byte{0x43}, 32))
+	other.Now = c.Now
+	if _, err := other.Parse(tok); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Wrong key error = %v, want ErrInvalid", err)
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	c := testCodec(t, &now)
+	tok, err := c.Issue(nil, time.Minute)
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	raw, _ := encoding.DecodeString(tok)
+
+	for _, bad := range []string{"", "!!!!", tok + "=", encoding.EncodeToString(raw[:Overhead-1])} {
+		if _, err := c.Parse(bad); !errors.Is(err, ErrMalformed) {
+			t.Errorf("Parse(%q) error = %v, want ErrMalformed", bad, err)
+		}
+	}
+
+	raw[0] = Version + 1
+	if _, err := c.Parse(encoding.EncodeToString(raw)); !errors.Is(err, ErrVersion) {
+		t.Errorf("Unknown version error = %v, want ErrVersion", err)
+	}
+}
+
+// TestSubSecondTTL checks that truncating timestamps to seconds never yields an expired token
+func TestSubSecondTTL(t *testing.T) {
+	now := time.Unix(1700000000, 900_000_000)
+	c := testCodec(t, &now)
+	tok, err := c.Issue([]byte("otp"), 500*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	got, err := c.Parse(tok)
+	if err != nil {
+		t.Fatalf("Parse right after Issue failed: %v", err)
+	}
+	if !got.ExpiresAt.Equal(time.Unix(1700000002, 0)) {
+		t.Errorf("ExpiresAt = %v, want the expiry rounded up", got.ExpiresAt)
+	}
+	now = now.Add(500 * time.Millisecond)
+	if _, err := c.Parse(tok); err != nil {
+		t.Errorf("Parse at the requested expiry failed: %v", err)
+	}
+	now = time.Unix(1700000002, 0)
+	if _, err := c.Parse(tok); !errors.Is(err, ErrExpired) {
+		t.Errorf("Parse after the rounded expiry error = %v, want ErrExpired", err)
+	}
+}
+
+// TestCanonical checks that a token has a single string form, so revocation lists keyed on it hold
+func TestCanonical(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	c := testCodec(t, &now)
+	tok, err := c.Issue(nil, time.Minute) // 49 bytes, so the last character carries 4 unused bits
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+	last := strings.IndexByte(alphabet, tok[len(tok)-1])
+	altered := tok[:len(tok)-1] + string(alphabet[last^1])
+
+	for _, bad := range []string{altered, tok[:10] + "\n" + tok[10:], tok + "\r\n"} {
+		if _, err := c.Parse(bad); !errors.Is(err, ErrMalformed) {
+			t.Errorf("Parse(%q) error = %v, want ErrMalformed", bad, err)
+		}
+	}
+}
+
+func TestIssueErrors(t *testing.T) {
+	if _, err := New(make([]byte, hiae.KeyLen-1)); err == nil {
+		t.Error("New accepted a short key")
+	}
+	c, _ := New(testKey)
+	if _, err := c.Issue(nil, 0); err == nil {
+		t.Error("Issue accepted a zero ttl")
+	}
+}
@@ -0,0 +1,212 @@
+package hiae
+
+import "errors"
+
+// vecCursor walks a list of buffers as if they were one contiguous slice
+type vecCursor struct {
+	bufs [][]byte
+	idx  int // Index of the current buffer
+	off  int // Position within the current buffer
+}
+
+// vecLen returns the total length of a list of buffers
+func vecLen(bufs [][]byte) int {
+	n := 0
+	for _, b := range bufs {
+		n += len(b)
+	}
+	return n
+}
+
+// contiguous returns the unconsumed part of the current buffer, skipping empty buffers
+func (c *vecCursor) contiguous() []byte {
+	for c.idx < len(c.bufs) && c.off == len(c.bufs[c.idx]) {
+		c.idx++
+		c.off = 0
+	}
+	if c.idx == len(c.bufs) {
+		return nil
+	}
+	return c.bufs[c.idx][c.off:]
+}
+
+// advance consumes n bytes, which must not exceed the current contiguous region
+func (c *vecCursor) advance(n int) {
+	c.off += n
+}
+
+// read copies the next len(p) bytes into p, crossing buffer boundaries as needed
+func (c *vecCursor) read(p []byte) {
+	for len(p) > 0 {
+		n := copy(p, c.contiguous())
+		c.advance(n)
+		p = p[n:]
+	}
+}
+
+// write copies p into the next len(p) bytes, crossing buffer boundaries as needed
+func (c *vecCursor) write(p []byte) {
+	for len(p) > 0 {
+		n := copy(c.contiguous(), p)
+		c.advance(n)
+		p = p[n:]
+	}
+}
+
+// absorbVec absorbs a list of buffers as one logically concatenated associated data string
+func (h *HiAE) absorbVec(ad [][]byte, adLen int) {
+	src := vecCursor{bufs: ad}
+	remaining := adLen
+	for remaining >= BlockLen {
+		s := src.contiguous()
+		if n := min(len(s), remaining) / BlockLen * BlockLen; n > 0 {
+			// Absorb whole blocks directly from the current buffer
+			h.absorbPadded(s[:n])
+			src.advance(n)
+			remaining -= n
+			continue
+		}
+
+		// The block straddles a buffer boundary
+		var block [BlockLen]byte
+		src.read(block[:])
+		h.absorb(block[:])
+		remaining -= BlockLen
+	}
+
+	if remaining > 0 {
+		var paddedBlock [BlockLen]byte
+		src.read(paddedBlock[:remaining])
+		h.absorb(paddedBlock[:])
+	}
+}
+
+// EncryptVec encrypts a message given as a list of buffers, writing the ciphertext across the dst buffers
+// The msg, ad and dst lists are each treated as one logically concatenated slice, and their buffer
+// boundaries need not line up. Large buffers are still processed with the batch fast path.
+// The dst buffers must not overlap the msg buffers.
+func EncryptVec(dst, msg, ad [][]byte, key, nonce, tagOut []byte) error {
+	if len(key) != KeyLen {
+		return errors.New("key must be 32 bytes")
+	}
+	if len(nonce) != NonceLen {
+		return errors.New("nonce must be 16 bytes")
+	}
+	msgLen := vecLen(msg)
+	if vecLen(dst) < msgLen {
+		return errors.New("ciphertext output buffer too small")
+	}
+	if len(tagOut) < TagLen {
+		return errors.New("tag output buffer too small")
+	}
+	adLen := vecLen(ad)
+
+	h := NewHiAE()
+	h.init(key, nonce)
+	h.absorbVec(ad, adLen)
+
+	src := vecCursor{bufs: msg}
+	out := vecCursor{bufs: dst}
+	remaining := msgLen
+	for remaining >= BlockLen {
+		s, d := src.contiguous(), out.contiguous()
+		if n := min(len(s), len(d), remaining) / BlockLen * BlockLen; n > 0 {
+			// Both sides have whole blocks available in their current buffers
+			h.encryptBlocks(s[:n], d[:n])
+			src.advance(n)
+			out.advance(n)
+			remaining -= n
+			continue
+		}
+
+		// The block straddles a buffer boundary on at least one side
+		var mi, ci [BlockLen]byte
+		src.read(mi[:])
+		h.enc(mi[:], ci[:])
+		out.write(ci[:])
+		remaining -= BlockLen
+	}
+
+	if remaining > 0 {
+		var paddedBlock, ctBlock [BlockLen]byte
+		src.read(paddedBlock[:remaining])
+		h.enc(paddedBlock[:], ctBlock[:])
+		out.write(ctBlock[:remaining])
+	}
+
+	h.finalize(uint64(adLen*8), uint64(msgLen*8), tagOut[:TagLen])
+
+	return nil
+}
+
+// DecryptVec decrypts a ciphertext given as a list of buffers, writing the plaintext across the dst buffers
+// The buffer lists are treated like in EncryptVec. On authentication failure the dst buffers are wiped.
+func DecryptVec(dst, ct, ad [][]byte, key, nonce, tag []byte) error {
+	if len(key) != KeyLen {
+		return errors.New("key must be 32 bytes")
+	}
+	if len(nonce) != NonceLen {
+		return errors.New("nonce must be 16 bytes")
+	}
+	if len(tag) != TagLen {
+		return errors.New("tag must be 16 bytes")
+	}
+	ctLen := vecLen(ct)
+	if vecLen(dst) < ctLen {
+		return errors.New("message output buffer too small")
+	}
+	adLen := vecLen(ad)
+
+	h := NewHiAE()
+	h.init(key, nonce)
+	h.absorbVec(ad, adLen)
+
+	src := vecCursor{bufs: ct}
+	out := vecCursor{bufs: dst}
+	remaining := ctLen
+	for remaining >= BlockLen {
+		s, d := src.contiguous(), out.contiguous()
+		if n := min(len(s), len(d), remaining) / BlockLen * BlockLen; n > 0 {
+			// Both sides have whole blocks available in their current buffers
+			h.decryptBlocks(s[:n], d[:n])
+			src.advance(n)
+			out.advance(n)
+			remaining -= n
+			continue
+		}
+
+		// The block straddles a buffer boundary on at least one side
+		var ci, mi [BlockLen]byte
+		src.read(ci[:])
+		h.dec(ci[:], mi[:])
+		out.write(mi[:])
+		remaining -= BlockLen
+	}
+
+	if remaining > 0 {
+		var cn, mn [BlockLen]byte
+		src.read(cn[:remaining])
+		h.decPartial(cn[:remaining], mn[:remaining])
+		out.write(mn[:remaining])
+	}
+
+	// Generate expected tag
+	var expectedTag [TagLen]byte
+	h.finalize(uint64(adLen*8), uint64(ctLen*8), expectedTag[:])
+
+	// Verify tag in constant time
+	if !ctEq(tag, expectedTag[:]) {
+		wipe := vecCursor{bufs: dst}
+		for n := ctLen; n > 0; {
+			d := wipe.contiguous()
+			d = d[:min(len(d), n)]
+			zeroBytes(d)
+			wipe.advance(len(d))
+			n -= len(d)
+		}
+		zeroBytes(expectedTag[:])
+		return errors.New("authentication verification failed")
+	}
+
+	return nil
+}
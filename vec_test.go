@@ -0,0 +1,99 @@
+package hiae
+
+import (
+	"bytes"
+	"testing"
+)
+
+// splitAt cuts data into consecutive buffers of the given sizes, with the remainder in the last buffer
+func splitAt(data []byte, sizes ...int) [][]byte {
+	var bufs [][]byte
+	for _, n := range sizes {
+		n = min(n, len(data))
+		bufs = append(bufs, data[:n])
+		data = data[n:]
+	}
+	return append(bufs, data)
+}
+
+// TestVecMatchesEncryptTo verifies that vectored encryption matches EncryptTo for various buffer layouts
+func TestVecMatchesEncryptTo(t *testing.T) {
+	key := hexDecode("4c8b7a9f3e5d2c6b1a8f9e7d6c5b4a3f2e1d0c9b8a7f6e5d4c3b2a1f0e9d8c7b")
+	nonce := hexDecode("7e3c9a5f1d8b4e6c2a9f5d7b3e8c1a4f")
+
+	msg := make([]byte, 1000)
+	for i := range msg {
+		msg[i] = byte(i * 7)
+	}
+	ad := make([]byte, 45)
+	for i := range ad {
+		ad[i] = byte(i)
+	}
+
+	expectedCt := make([]byte, len(msg))
+	expectedTag := make([]byte, TagLen)
+	if err := EncryptTo(msg, ad, key, nonce, expectedCt, expectedTag); err != nil {
+		t.Fatalf("EncryptTo failed: %v", err)
+	}
+
+	layouts := []struct {
+		name    string
+		msg, ad []int
+		dst     []int
+	}{
+		{"single buffers", nil, nil, nil},
+		{"aligned pages", []int{256, 512}, []int{16}, []int{256, 512}},
+		{"straddling blocks", []int{7, 300, 1}, []int{3, 0, 20}, []int{33, 600}},
+		{"header payload trailer", []int{20, 960}, []int{44}, []int{1, 2, 3, 500}},
+		{"byte by byte ad", []int{999}, []int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1}, []int{1000}},
+	}
+
+	for _, l := range layouts {
+		t.Run(l.name, func(t *testing.T) {
+			ct := make([]byte, len(msg))
+			tag := make([]byte, TagLen)
+			err := EncryptVec(splitAt(ct, l.dst...), splitAt(msg, l.msg...), splitAt(ad, l.ad...), key, nonce, tag)
+			if err != nil {
+				t.Fatalf("EncryptVec failed: %v", err)
+			}
+			if !bytes.Equal(ct, expectedCt) || !bytes.Equal(tag, expectedTag) {
+				t.Fatal("EncryptVec output differs from EncryptTo")
+			}
+
+			pt := make([]byte, len(msg))
+			err = DecryptVec(splitAt(pt, l.msg...), splitAt(ct, l.dst...), splitAt(ad, l.ad...), key, nonce, tag)
+			if err != nil {
+				t.Fatalf("DecryptVec failed: %v", err)
+			}
+			if !bytes.Equal(pt, msg) {
+				t.Fatal("DecryptVec output mismatch")
+			}
+		})
+	}
+}
+
+// TestVecAuthFailure verifies that a tampered ciphertext is rejected and the output wiped
+func TestVecAuthFailure(t *testing.T) {
+	key := make([]byte, KeyLen)
+	nonce := make([]byte, NonceLen)
+	msg := bytes.Repeat([]byte{0x42}, 300)
+
+	ct := make([]byte, len(msg))
+	tag := make([]byte, TagLen)
+	if err := EncryptVec([][]byte{ct}, splitAt(msg, 100), nil, key, nonce, tag); err != nil {
+		t.Fatalf("EncryptVec failed: %v", err)
+	}
+	ct[150] ^= 0x01
+
+	pt := make([]byte, len(msg))
+	if err := DecryptVec(splitAt(pt, 5, 200), [][]byte{ct}, nil, key, nonce, tag); err == nil {
+		t.Fatal("DecryptVec should have failed with tampered ciphertext")
+	}
+	if !bytes.Equal(pt, make([]byte, len(pt))) {
+		t.Error("Plaintext was not wiped after authentication failure")
+	}
+
+	if err := EncryptVec([][]byte{make([]byte, 10)}, [][]byte{msg}, nil, key, nonce, tag); err == nil {
+		t.Error("Expected error for short output buffers")
+	}
+}